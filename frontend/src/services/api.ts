@@ -1,7 +1,13 @@
 const API_BASE_URL = import.meta.env.VITE_API_BASE_URL || 'http://localhost:8080/api/v1'
 
+export const AUTH_TOKEN_KEY = 'auth_token'
+export const REFRESH_TOKEN_KEY = 'refresh_token'
+
 export interface AuthResponse {
   access: string
+  refresh: string
+  mfa_required?: boolean
+  mfa_token?: string
 }
 
 export interface SignUpResponse {
@@ -17,15 +23,90 @@ export interface AuthError {
 export interface FilesResponse {
   files: string[] | null
   directories: string[] | null
+  is_truncated: boolean
+  next_page_token: string
+}
+
+export function storeTokens(access: string | null, refresh: string | null) {
+  if (access) {
+    localStorage.setItem(AUTH_TOKEN_KEY, access)
+  } else {
+    localStorage.removeItem(AUTH_TOKEN_KEY)
+  }
+  if (refresh) {
+    localStorage.setItem(REFRESH_TOKEN_KEY, refresh)
+  } else {
+    localStorage.removeItem(REFRESH_TOKEN_KEY)
+  }
 }
 
 class ApiService {
   private baseUrl: string
+  private refreshing: Promise<boolean> | null = null
+  private sessionExpired: () => void = () => {}
 
   constructor(baseUrl: string) {
     this.baseUrl = baseUrl
   }
 
+  // Вызывается, когда refresh токен больше не принимается сервером
+  onSessionExpired(handler: () => void) {
+    this.sessionExpired = handler
+  }
+
+  // Access токен живет 15 минут, поэтому на 401 один раз обновляем пару
+  // токенов и повторяем запрос
+  private async authorizedFetch(url: string, options: RequestInit = {}): Promise<Response> {
+    const send = () => {
+      const headers: Record<string, string> = {
+        ...(options.headers as Record<string, string>),
+      }
+      const token = localStorage.getItem(AUTH_TOKEN_KEY)
+      if (token) {
+        headers['Authorization'] = `Bearer ${token}`
+      }
+      return fetch(url, { ...options, headers })
+    }
+
+    const response = await send()
+    if (response.status !== 401 || !localStorage.getItem(REFRESH_TOKEN_KEY)) {
+      return response
+    }
+    if (!(await this.refreshTokens())) {
+      return response
+    }
+    return send()
+  }
+
+  // Параллельные запросы с истекшим токеном ждут одного обновления,
+  // иначе второй запрос предъявит уже использованный refresh токен
+  private refreshTokens(): Promise<boolean> {
+    if (!this.refreshing) {
+      this.refreshing = this.refresh().finally(() => {
+        this.refreshing = null
+      })
+    }
+    return this.refreshing
+  }
+
+  private async refresh(): Promise<boolean> {
+    const response = await fetch(`${this.baseUrl}/users/refresh`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ refresh: localStorage.getItem(REFRESH_TOKEN_KEY) }),
+    }).catch(() => null)
+    if (!response || !response.ok) {
+      if (response && response.status === 401) {
+        storeTokens(null, null)
+        this.sessionExpired()
+      }
+      return false
+    }
+    const tokens: AuthResponse = await response.json()
+    storeTokens(tokens.access, tokens.refresh)
+    return true
+  }
+
   private async request<T>(
     endpoint: string,
     options: RequestInit = {}
@@ -36,12 +117,7 @@ class ApiService {
       ...(options.headers as Record<string, string>),
     }
 
-    const token = localStorage.getItem('auth_token')
-    if (token) {
-      headers['Authorization'] = `Bearer ${token}`
-    }
-
-    const response = await fetch(url, {
+    const response = await this.authorizedFetch(url, {
       ...options,
       headers,
     })
@@ -62,6 +138,13 @@ class ApiService {
     })
   }
 
+  async verifyMfa(mfaToken: string, code: string): Promise<AuthResponse> {
+    return this.request<AuthResponse>('/users/auth/mfa', {
+      method: 'POST',
+      body: JSON.stringify({ mfa_token: mfaToken, code }),
+    })
+  }
+
   async signUp(username: string, password: string): Promise<SignUpResponse> {
     return this.request<SignUpResponse>('/users/sign-up', {
       method: 'POST',
@@ -80,16 +163,9 @@ class ApiService {
 
   async downloadFile(filePath: string): Promise<void> {
     const url = `${this.baseUrl}/files/${encodeURIComponent(filePath)}/download`
-    const token = localStorage.getItem('auth_token')
-
-    const headers: Record<string, string> = {}
-    if (token) {
-      headers['Authorization'] = `Bearer ${token}`
-    }
 
-    const response = await fetch(url, {
+    const response = await this.authorizedFetch(url, {
       method: 'GET',
-      headers,
     })
 
     if (!response.ok) {
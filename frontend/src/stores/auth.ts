@@ -1,31 +1,34 @@
 import { defineStore } from 'pinia'
 import { ref, computed } from 'vue'
-import { apiService } from '@/services/api'
-
-const AUTH_TOKEN_KEY = 'auth_token'
+import { apiService, storeTokens, AUTH_TOKEN_KEY } from '@/services/api'
 
 export const useAuthStore = defineStore('auth', () => {
   const token = ref<string | null>(localStorage.getItem(AUTH_TOKEN_KEY))
   const loading = ref(false)
   const error = ref<string | null>(null)
+  const mfaToken = ref<string | null>(null)
 
   const isAuthenticated = computed(() => !!token.value)
 
-  function setToken(newToken: string | null) {
-    token.value = newToken
-    if (newToken) {
-      localStorage.setItem(AUTH_TOKEN_KEY, newToken)
-    } else {
-      localStorage.removeItem(AUTH_TOKEN_KEY)
-    }
+  function setTokens(access: string | null, refresh: string | null) {
+    token.value = access
+    storeTokens(access, refresh)
   }
 
+  apiService.onSessionExpired(() => {
+    token.value = null
+  })
+
   async function login(username: string, password: string) {
     loading.value = true
     error.value = null
     try {
       const response = await apiService.login(username, password)
-      setToken(response.access)
+      if (response.mfa_required && response.mfa_token) {
+        mfaToken.value = response.mfa_token
+        return { success: false, mfaRequired: true }
+      }
+      setTokens(response.access, response.refresh)
       return { success: true }
     } catch (err) {
       const message = err instanceof Error ? err.message : 'Ошибка авторизации'
@@ -36,6 +39,28 @@ export const useAuthStore = defineStore('auth', () => {
     }
   }
 
+  // Второй шаг входа: код из приложения или один из резервных кодов
+  async function verifyMfa(code: string) {
+    if (!mfaToken.value) {
+      return { success: false, error: 'Войдите заново' }
+    }
+    loading.value = true
+    error.value = null
+    try {
+      const response = await apiService.verifyMfa(mfaToken.value, code)
+      setTokens(response.access, response.refresh)
+      return { success: true }
+    } catch (err) {
+      const message = err instanceof Error ? err.message : 'Неверный код'
+      error.value = message
+      return { success: false, error: message }
+    } finally {
+      // mfa токен одноразовый, после любой попытки нужен новый вход
+      mfaToken.value = null
+      loading.value = false
+    }
+  }
+
   async function signUp(username: string, password: string) {
     loading.value = true
     error.value = null
@@ -52,7 +77,7 @@ export const useAuthStore = defineStore('auth', () => {
   }
 
   function logout() {
-    setToken(null)
+    setTokens(null, null)
   }
 
   return {
@@ -60,7 +85,9 @@ export const useAuthStore = defineStore('auth', () => {
     loading,
     error,
     isAuthenticated,
+    mfaToken,
     login,
+    verifyMfa,
     signUp,
     logout,
   }
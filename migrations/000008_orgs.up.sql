@@ -0,0 +1,54 @@
+-- The MIT License (MIT)
+--
+-- Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+--
+-- Permission is hereby granted, free of charge, to any person obtaining a copy
+-- of this software and associated documentation files (the "Software"), to deal
+-- in the Software without restriction, including without limitation the rights
+-- to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+-- copies of the Software, and to permit persons to whom the Software is
+-- furnished to do so, subject to the following conditions:
+--
+-- The above copyright notice and this permission notice shall be included in all
+-- copies or substantial portions of the Software.
+--
+-- THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+-- EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+-- MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+-- IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+-- DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+-- OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+-- OR OTHER DEALINGS IN THE SOFTWARE.
+
+
+CREATE TABLE orgs (
+    org_id serial PRIMARY KEY,
+    name varchar(128) NOT NULL,
+    created_by integer REFERENCES users(user_id) ON DELETE SET NULL,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE org_members (
+    org_id integer NOT NULL REFERENCES orgs(org_id) ON DELETE CASCADE,
+    user_id integer NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    role varchar(16) NOT NULL,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (org_id, user_id)
+);
+
+CREATE INDEX idx_org_members_user_id ON org_members(user_id);
+
+CREATE TABLE org_invites (
+    org_invite_id serial PRIMARY KEY,
+    org_id integer NOT NULL REFERENCES orgs(org_id) ON DELETE CASCADE,
+    user_id integer NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    invited_by integer REFERENCES users(user_id) ON DELETE SET NULL,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(org_id, user_id)
+);
+
+CREATE INDEX idx_org_invites_user_id ON org_invites(user_id);
+
+ALTER TABLE buckets ADD COLUMN org_id integer REFERENCES orgs(org_id) ON DELETE SET NULL;
+
+CREATE INDEX idx_buckets_org_id ON buckets(org_id);
@@ -0,0 +1,63 @@
+-- The MIT License (MIT)
+--
+-- Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+--
+-- Permission is hereby granted, free of charge, to any person obtaining a copy
+-- of this software and associated documentation files (the "Software"), to deal
+-- in the Software without restriction, including without limitation the rights
+-- to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+-- copies of the Software, and to permit persons to whom the Software is
+-- furnished to do so, subject to the following conditions:
+--
+-- The above copyright notice and this permission notice shall be included in all
+-- copies or substantial portions of the Software.
+--
+-- THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+-- EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+-- MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+-- IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+-- DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+-- OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+-- OR OTHER DEALINGS IN THE SOFTWARE.
+
+CREATE TABLE audit_log (
+    audit_id bigserial PRIMARY KEY,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    user_id integer,
+    username varchar(255),
+    action varchar(255) NOT NULL,
+    bucket_id integer,
+    object_key text,
+    ip varchar(64) NOT NULL,
+    user_agent varchar(1024) NOT NULL,
+    status integer NOT NULL,
+    result varchar(16) NOT NULL
+);
+
+CREATE INDEX idx_audit_log_created_at ON audit_log(created_at);
+CREATE INDEX idx_audit_log_user_id ON audit_log(user_id, audit_id);
+CREATE INDEX idx_audit_log_bucket_id ON audit_log(bucket_id, audit_id);
+
+-- Entries must outlive the users and buckets they mention, so there are no
+-- foreign keys, and nothing may rewrite them. Only the retention job may
+-- delete, it sets audit_log.retention for its transaction and each removed
+-- entry has to be older than that.
+CREATE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+DECLARE
+    retention text := NULLIF(current_setting('audit_log.retention', true), '');
+BEGIN
+    IF TG_OP = 'DELETE' AND retention IS NOT NULL
+        AND OLD.created_at < now() - retention::interval THEN
+        RETURN OLD;
+    END IF;
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_no_update_delete
+    BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+CREATE TRIGGER audit_log_no_truncate
+    BEFORE TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
@@ -0,0 +1,67 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/blablatdinov/web-s3/src/repo"
+	"github.com/jmoiron/sqlx"
+	"github.com/joho/godotenv"
+	_ "github.com/lib/pq"
+)
+
+const minRetention = 24 * time.Hour
+
+// Maintains the append-only audit log:
+//
+//	audit-log prune  deletes entries older than AUDIT_RETENTION, e.g. 2160h
+func main() {
+	if len(os.Args) != 2 || os.Args[1] != "prune" {
+		log.Fatalf("Usage: %s prune", os.Args[0])
+	}
+	err := godotenv.Load()
+	if err != nil {
+		log.Fatalf("Error loading .env file")
+	}
+	retention, err := time.ParseDuration(os.Getenv("AUDIT_RETENTION"))
+	if err != nil {
+		log.Fatalf("Invalid AUDIT_RETENTION val \"%s\" expected duration like 2160h", os.Getenv("AUDIT_RETENTION"))
+	}
+	if retention < minRetention {
+		log.Fatalf("AUDIT_RETENTION must be at least %s", minRetention)
+	}
+	pgsql, err := sqlx.Connect("postgres", repo.DatabaseDsn())
+	if err != nil {
+		log.Fatalf("Error connectiing to db: %s\n", err)
+	}
+	deleted, err := repo.PgAuditRepoCtor(pgsql).Prune(retention)
+	if err != nil {
+		log.Fatalf("Error pruning audit log: %s", err)
+	}
+	fmt.Printf("Deleted %d audit entries older than %s\n", deleted, retention)
+}
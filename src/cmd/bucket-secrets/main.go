@@ -0,0 +1,86 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/blablatdinov/web-s3/src/repo"
+	"github.com/jmoiron/sqlx"
+	"github.com/joho/godotenv"
+	_ "github.com/lib/pq"
+)
+
+// Maintains bucket secret encryption:
+//
+//	bucket-secrets encrypt  encrypts secrets stored in plain text
+//	bucket-secrets rotate   re-wraps all data keys with MASTER_KEY_ID,
+//	                        including the ones of TOTP secrets
+//	bucket-secrets decrypt  stores secrets in plain text again before
+//	                        rolling the encryption migration back
+func main() {
+	if len(os.Args) != 2 || (os.Args[1] != "encrypt" && os.Args[1] != "rotate" && os.Args[1] != "decrypt") {
+		log.Fatalf("Usage: %s encrypt|rotate|decrypt", os.Args[0])
+	}
+	err := godotenv.Load()
+	if err != nil {
+		log.Fatalf("Error loading .env file")
+	}
+	pgsql, err := sqlx.Connect("postgres", repo.DatabaseDsn())
+	if err != nil {
+		log.Fatalf("Error connectiing to db: %s\n", err)
+	}
+	masterKeys, err := repo.ParseMasterKeys(os.Getenv("MASTER_KEYS"))
+	if err != nil {
+		log.Fatalf("Invalid MASTER_KEYS: %s", err)
+	}
+	secretCipher, err := repo.AesGcmSecretCipherCtor(masterKeys, os.Getenv("MASTER_KEY_ID"))
+	if err != nil {
+		log.Fatalf("Invalid MASTER_KEY_ID: %s", err)
+	}
+	secretsRepo := repo.PgBucketSecretsRepoCtor(pgsql, secretCipher)
+	var count int
+	switch os.Args[1] {
+	case "encrypt":
+		count, err = secretsRepo.EncryptPlaintext()
+	case "rotate":
+		count, err = secretsRepo.Rewrap()
+	case "decrypt":
+		count, err = secretsRepo.DecryptAll()
+	}
+	if err != nil {
+		log.Fatalf("Error on %s bucket secrets: %s", os.Args[1], err)
+	}
+	fmt.Printf("Processed %d bucket secrets with master key \"%s\"\n", count, secretCipher.ActiveKeyID())
+	if os.Args[1] == "rotate" {
+		count, err = repo.PgMfaRepoCtor(pgsql, secretCipher).RewrapSecrets()
+		if err != nil {
+			log.Fatalf("Error on rotate TOTP secrets: %s", err)
+		}
+		fmt.Printf("Processed %d TOTP secrets with master key \"%s\"\n", count, secretCipher.ActiveKeyID())
+	}
+}
@@ -30,6 +30,8 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/blablatdinov/web-s3/src/handlers"
 	"github.com/blablatdinov/web-s3/src/repo"
@@ -42,27 +44,145 @@ import (
 	redis "github.com/redis/go-redis/v9"
 )
 
-func databaseDsn() string {
-	password := os.Getenv("PG_PASSWORD")
-	if password != "" {
-		return fmt.Sprintf(
-			"host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
-			os.Getenv("PG_HOST"),
-			os.Getenv("PG_USER"),
-			os.Getenv("PG_PASSWORD"),
-			os.Getenv("PG_DBNAME"),
-			os.Getenv("PG_PORT"),
-		)
-	} else {
-		return fmt.Sprintf(
-			"host=%s user=%s dbname=%s port=%s sslmode=disable",
-			os.Getenv("PG_HOST"),
-			os.Getenv("PG_USER"),
-			os.Getenv("PG_DBNAME"),
-			os.Getenv("PG_PORT"),
-		)
+const defaultArchiveMaxSize = 5 * 1024 * 1024 * 1024
 
+func envInt(name string, val int) int {
+	if os.Getenv(name) == "" {
+		return val
+	}
+	val, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		log.Fatalf("Invalid %s val \"%s\" expected number", name, os.Getenv(name))
 	}
+	return val
+}
+
+func envDuration(name string, val time.Duration) time.Duration {
+	if os.Getenv(name) == "" {
+		return val
+	}
+	val, err := time.ParseDuration(os.Getenv(name))
+	if err != nil {
+		log.Fatalf("Invalid %s val \"%s\" expected duration like 30s or 15m", name, os.Getenv(name))
+	}
+	return val
+}
+
+func authLimits() srv.AuthLimits {
+	limits := srv.DefaultAuthLimits()
+	return srv.AuthLimits{
+		LoginPerIP:           envInt("LOGIN_RATE_LIMIT_IP", limits.LoginPerIP),
+		LoginPerUsername:     envInt("LOGIN_RATE_LIMIT_USERNAME", limits.LoginPerUsername),
+		LoginWindow:          envDuration("LOGIN_RATE_WINDOW", limits.LoginWindow),
+		SignupPerIP:          envInt("SIGNUP_RATE_LIMIT_IP", limits.SignupPerIP),
+		SignupWindow:         envDuration("SIGNUP_RATE_WINDOW", limits.SignupWindow),
+		SharePasswordPerIP:   envInt("SHARE_PASSWORD_RATE_LIMIT_IP", limits.SharePasswordPerIP),
+		SharePasswordPerLink: envInt("SHARE_PASSWORD_RATE_LIMIT_LINK", limits.SharePasswordPerLink),
+		SharePasswordWindow:  envDuration("SHARE_PASSWORD_RATE_WINDOW", limits.SharePasswordWindow),
+		LockoutThreshold:     envInt("LOGIN_LOCKOUT_THRESHOLD", limits.LockoutThreshold),
+		LockoutBase:          envDuration("LOGIN_LOCKOUT_BASE", limits.LockoutBase),
+		LockoutMax:           envDuration("LOGIN_LOCKOUT_MAX", limits.LockoutMax),
+	}
+}
+
+func passwordPolicy() srv.PasswordPolicy {
+	policy := srv.DefaultPasswordPolicy()
+	policy.MinLength = envInt("PASSWORD_MIN_LENGTH", policy.MinLength)
+	if classes, ok := os.LookupEnv("PASSWORD_REQUIRED_CLASSES"); ok {
+		parsed, err := srv.ParseCharClasses(classes)
+		if err != nil {
+			log.Fatalf("Invalid PASSWORD_REQUIRED_CLASSES val \"%s\": %s", classes, err)
+		}
+		policy.Classes = parsed
+	}
+	if path := os.Getenv("PASSWORD_DENYLIST_FILE"); path != "" {
+		denylist, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("Error opening PASSWORD_DENYLIST_FILE: %s", err)
+		}
+		defer denylist.Close()
+		policy, err = policy.WithDenylist(denylist)
+		if err != nil {
+			log.Fatalf("Error reading PASSWORD_DENYLIST_FILE: %s", err)
+		}
+	}
+	return policy
+}
+
+func oidcConfig() srv.OidcConfig {
+	return srv.OidcConfig{
+		Issuer:        os.Getenv("OIDC_ISSUER"),
+		ClientID:      os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret:  os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:   os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:        strings.Fields(os.Getenv("OIDC_SCOPES")),
+		UsernameClaim: os.Getenv("OIDC_USERNAME_CLAIM"),
+		AutoProvision: envBool("OIDC_AUTO_PROVISION"),
+	}
+}
+
+func envBool(name string) bool {
+	if os.Getenv(name) == "" {
+		return false
+	}
+	val, err := strconv.ParseBool(os.Getenv(name))
+	if err != nil {
+		log.Fatalf("Invalid %s val \"%s\" expected boolean", name, os.Getenv(name))
+	}
+	return val
+}
+
+// auditAdmins lists the ids of users allowed to read the whole audit log.
+func auditAdmins() []int {
+	admins := []int{}
+	for _, item := range strings.Split(os.Getenv("AUDIT_ADMIN_IDS"), ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		userID, err := strconv.Atoi(strings.TrimSpace(item))
+		if err != nil {
+			log.Fatalf("Invalid AUDIT_ADMIN_IDS val \"%s\" expected comma separated user ids", os.Getenv("AUDIT_ADMIN_IDS"))
+		}
+		admins = append(admins, userID)
+	}
+	return admins
+}
+
+// authenticator chains the backends listed in AUTH_BACKENDS, local
+// password check only by default.
+func authenticator(pgsql *sqlx.DB, userAuthRepo repo.UserAuthRepo) srv.Authenticator {
+	backendNames := strings.Split(os.Getenv("AUTH_BACKENDS"), ",")
+	if os.Getenv("AUTH_BACKENDS") == "" {
+		backendNames = []string{"local"}
+	}
+	backends := []srv.Authenticator{}
+	for _, name := range backendNames {
+		switch strings.TrimSpace(name) {
+		case "local":
+			backends = append(backends, srv.LocalAuthenticatorCtor(userAuthRepo))
+		case "ldap":
+			allowedGroups := []string{}
+			for _, group := range strings.Split(os.Getenv("LDAP_ALLOWED_GROUPS"), ";") {
+				if strings.TrimSpace(group) != "" {
+					allowedGroups = append(allowedGroups, strings.TrimSpace(group))
+				}
+			}
+			backends = append(backends, srv.LdapAuthenticatorCtor(srv.LdapConfig{
+				URL:               os.Getenv("LDAP_URL"),
+				StartTLS:          envBool("LDAP_START_TLS"),
+				BindDN:            os.Getenv("LDAP_BIND_DN"),
+				BindPassword:      os.Getenv("LDAP_BIND_PASSWORD"),
+				BaseDN:            os.Getenv("LDAP_BASE_DN"),
+				UserFilter:        os.Getenv("LDAP_USER_FILTER"),
+				UsernameAttribute: os.Getenv("LDAP_USERNAME_ATTRIBUTE"),
+				GroupAttribute:    os.Getenv("LDAP_GROUP_ATTRIBUTE"),
+				AllowedGroups:     allowedGroups,
+			}, repo.PgIdentitiesRepoCtor(pgsql)))
+		default:
+			log.Fatalf("Invalid AUTH_BACKENDS val \"%s\" expected local and/or ldap", os.Getenv("AUTH_BACKENDS"))
+		}
+	}
+	return srv.ChainAuthenticatorCtor(backends...)
 }
 
 func main() {
@@ -70,7 +190,7 @@ func main() {
 	if err != nil {
 		log.Fatalf("Error loading .env file")
 	}
-	pgsql, err := sqlx.Connect("postgres", databaseDsn())
+	pgsql, err := sqlx.Connect("postgres", repo.DatabaseDsn())
 	ctx := context.Background()
 	if err != nil {
 		log.Fatalf("Error connectiing to db: %s\n", err)
@@ -84,42 +204,175 @@ func main() {
 		Password: os.Getenv("REDIS_PASSWORD"),
 		DB:       rdbIdx,
 	})
+	masterKeys, err := repo.ParseMasterKeys(os.Getenv("MASTER_KEYS"))
+	if err != nil {
+		log.Fatalf("Invalid MASTER_KEYS: %s", err)
+	}
+	secretCipher, err := repo.AesGcmSecretCipherCtor(masterKeys, os.Getenv("MASTER_KEY_ID"))
+	if err != nil {
+		log.Fatalf("Invalid MASTER_KEY_ID: %s", err)
+	}
+	// Secrets left in plain text by older versions are encrypted before
+	// serving, plain rows are refused afterwards.
+	encrypted, err := repo.PgBucketSecretsRepoCtor(pgsql, secretCipher).EncryptPlaintext()
+	if err != nil {
+		log.Fatalf("Error encrypting bucket secrets: %s", err)
+	}
+	if encrypted > 0 {
+		log.Printf("Encrypted %d bucket secrets stored in plain text", encrypted)
+	}
+	archiveMaxSize := int64(defaultArchiveMaxSize)
+	if os.Getenv("ARCHIVE_MAX_SIZE") != "" {
+		archiveMaxSize, err = strconv.ParseInt(os.Getenv("ARCHIVE_MAX_SIZE"), 10, 64)
+		if err != nil {
+			log.Fatalf("Invalid ARCHIVE_MAX_SIZE val \"%s\" expected number of bytes", os.Getenv("ARCHIVE_MAX_SIZE"))
+		}
+	}
 	app := fiber.New(fiber.Config{
-		Immutable: true,
+		Immutable:         true,
+		StreamRequestBody: true,
+		ProxyHeader:       os.Getenv("PROXY_HEADER"),
 	})
 	app.Use(cors.New(cors.Config{
-		AllowOrigins: "*",
-		AllowMethods: "GET,POST,PUT,DELETE,OPTIONS",
-		AllowHeaders: "Origin,Content-Type,Accept,Authorization",
+		AllowOrigins:  "*",
+		AllowMethods:  "GET,POST,PUT,PATCH,DELETE,OPTIONS",
+		AllowHeaders:  "Origin,Content-Type,Accept,Authorization,Range,If-None-Match,If-Modified-Since,If-Match,If-Unmodified-Since,X-Share-Password",
+		ExposeHeaders: "Content-Disposition,Content-Range,Accept-Ranges,ETag,Last-Modified,Retry-After",
 	}))
 	app.Get("/health-check", handlers.HealthCheckCtor(pgsql, rdb, ctx).Handle)
 	api := app.Group("/api/v1")
+	pwdPolicy := passwordPolicy()
+	authLimiter := srv.AuthLimiterCtor(authLimits(), repo.RedisAttemptStoreCtor(rdb))
 	api.Post("/users/sign-up", handlers.UserSingUpCtor(
 		srv.UsrSignupSrvCtor(
 			repo.PgUserSignupRepoCtor(pgsql),
+			pwdPolicy,
 		),
+		authLimiter,
 	).Handle)
-	api.Post("/users/auth", handlers.UserAuthCtor(
-		srv.UserAuthSrvCtor(
-			os.Getenv("SECRET_KEY"),
-			repo.PgUserAuthRepoCtor(pgsql),
-		),
-	).Handle)
+	userAuthRepo := repo.PgUserAuthRepoCtor(pgsql)
+	mfaIssuer := os.Getenv("MFA_ISSUER")
+	if mfaIssuer == "" {
+		mfaIssuer = "web-s3"
+	}
+	mfa := srv.TotpMfaSrvCtor(repo.PgMfaRepoCtor(pgsql, secretCipher), mfaIssuer)
+	userAuthSrv := srv.UserAuthSrvCtor(
+		os.Getenv("SECRET_KEY"),
+		authenticator(pgsql, userAuthRepo),
+		repo.RedisTokenStoreCtor(rdb),
+		mfa,
+	)
+	bucketsRepo := repo.PgBucketsRepoCtor(pgsql, secretCipher)
+	orgsRepo := repo.PgOrgsRepoCtor(pgsql)
+	bucketAuthz := srv.GrantsBucketAuthzCtor(repo.PgBucketGrantsRepoCtor(pgsql), orgsRepo)
+	auditLog := srv.AuditLogSrvCtor(
+		repo.PgAuditRepoCtor(pgsql),
+		repo.RedisAttemptStoreCtor(rdb),
+		bucketsRepo,
+		bucketAuthz,
+		auditAdmins(),
+	)
+	audit := handlers.AuditMiddleware(auditLog, "/api/v1")
+	api.Post("/users/auth", audit, handlers.UserAuthCtor(userAuthSrv, authLimiter).Handle)
+	api.Post("/users/auth/mfa", audit, handlers.UserMfaVerifyCtor(userAuthSrv, authLimiter).Handle)
+	var oidcLogin srv.OidcLogin
+	if os.Getenv("OIDC_ISSUER") != "" {
+		oidcLogin, err = srv.OidcSrvCtor(
+			ctx,
+			oidcConfig(),
+			repo.RedisOidcStateStoreCtor(rdb),
+			repo.PgIdentitiesRepoCtor(pgsql),
+			userAuthSrv,
+		)
+		if err != nil {
+			log.Fatalf("Error configuring OIDC: %s", err)
+		}
+		api.Get("/users/oidc/login", handlers.OidcLoginCtor(oidcLogin).Handle)
+		api.Get("/users/oidc/callback", audit, handlers.OidcCallbackCtor(oidcLogin, os.Getenv("OIDC_FRONTEND_URL")).Handle)
+	}
+	api.Post("/users/refresh", handlers.UserRefreshCtor(userAuthSrv).Handle)
+	shareLinks := srv.ShareLinksSrvCtor(repo.PgShareLinksRepoCtor(pgsql), bucketAuthz)
+	shareServe := handlers.ShareLinkServeHandlerCtor(shareLinks, bucketsRepo, bucketAuthz, authLimiter)
+	api.Get("/s/:token", audit, shareServe.Handle)
+	api.Post("/s/:token", audit, shareServe.Handle)
+	dropLinks := srv.DropLinksSrvCtor(repo.PgDropLinksRepoCtor(pgsql), bucketAuthz)
+	api.Get("/d/:token", handlers.DropLinkInfoHandlerCtor(dropLinks).Handle)
+	api.Post("/d/:token", audit, handlers.DropLinkUploadHandlerCtor(dropLinks, bucketsRepo, bucketAuthz).Handle)
+	apiTokens := srv.ApiTokensSrvCtor(repo.PgApiTokensRepoCtor(pgsql))
+	// Audit goes first to also record requests refused by authentication.
 	protected := api.Group(
 		"",
-		handlers.AuthMiddleware(
-			srv.UserAuthSrvCtor(
-				os.Getenv("SECRET_KEY"),
-				repo.PgUserAuthRepoCtor(pgsql),
-			),
-		),
+		audit,
+		handlers.AuthMiddleware(userAuthSrv, apiTokens),
 	)
-	bucketsRepo := repo.PgBucketsRepoCtor(pgsql)
-	protected.Get("/files", handlers.FilesCtor(pgsql, bucketsRepo).Handle)
-	protected.Get("/files/:path/download", handlers.FileDownloadHandlerCtor(bucketsRepo).Handle)
+	protected.Post("/users/logout", handlers.SessionOnly(), handlers.UserLogoutCtor(userAuthSrv).Handle)
+	protected.Post("/users/password", handlers.SessionOnly(), handlers.UserPasswordCtor(
+		srv.PasswordChangeSrvCtor(userAuthRepo, pwdPolicy, userAuthSrv),
+		userAuthSrv,
+		authLimiter,
+	).Handle)
+	protected.Get("/users/mfa", handlers.SessionOnly(), handlers.UserMfaStatusCtor(mfa).Handle)
+	protected.Post("/users/mfa/totp", handlers.SessionOnly(), handlers.UserTotpEnrollCtor(mfa).Handle)
+	protected.Post("/users/mfa/totp/confirm", handlers.SessionOnly(), handlers.UserTotpConfirmCtor(mfa).Handle)
+	protected.Delete("/users/mfa/totp", handlers.SessionOnly(), handlers.UserTotpDisableCtor(mfa, userAuthSrv, authLimiter).Handle)
+	protected.Post("/users/mfa/recovery-codes", handlers.SessionOnly(), handlers.UserRecoveryCodesCtor(mfa, authLimiter).Handle)
+	protected.Get("/users/sessions", handlers.SessionOnly(), handlers.UserSessionsListCtor(userAuthSrv).Handle)
+	protected.Delete("/users/sessions/:id", handlers.SessionOnly(), handlers.UserSessionDeleteCtor(userAuthSrv).Handle)
+	if oidcLogin != nil {
+		protected.Post("/users/oidc/link", handlers.SessionOnly(), handlers.OidcLinkCtor(oidcLogin).Handle)
+	}
+	protected.Get("/users/tokens", handlers.SessionOnly(), handlers.ApiTokensListHandlerCtor(apiTokens).Handle)
+	protected.Post("/users/tokens", handlers.SessionOnly(), handlers.ApiTokenCreateHandlerCtor(apiTokens, bucketsRepo).Handle)
+	protected.Delete("/users/tokens/:id", handlers.SessionOnly(), handlers.ApiTokenDeleteHandlerCtor(apiTokens).Handle)
+	protected.Get("/files", handlers.FilesCtor(pgsql, bucketsRepo, bucketAuthz).Handle)
+	protected.Get("/files/:path/download", handlers.FileDownloadHandlerCtor(bucketsRepo, bucketAuthz).Handle)
+	protected.Get("/files/:path/presign", handlers.FilePresignHandlerCtor(bucketsRepo, bucketAuthz).Handle)
+	protected.Post("/files/upload", handlers.FileUploadHandlerCtor(bucketsRepo, bucketAuthz).Handle)
+	protected.Delete("/files/:path", handlers.FileDeleteHandlerCtor(bucketsRepo, bucketAuthz).Handle)
+	protected.Post("/files/archive", handlers.FileArchiveHandlerCtor(bucketsRepo, bucketAuthz, archiveMaxSize).Handle)
+	protected.Post("/files/copy", handlers.FileCopyHandlerCtor(bucketsRepo, bucketAuthz).Handle)
+	protected.Post("/files/move", handlers.FileMoveHandlerCtor(bucketsRepo, bucketAuthz).Handle)
+	uploadSessionsRepo := repo.PgUploadSessionsRepoCtor(pgsql)
+	uploads := protected.Group("/uploads")
+	uploads.Get("/", handlers.UploadSessionsListHandlerCtor(uploadSessionsRepo).Handle)
+	uploads.Post("/", handlers.UploadSessionCreateHandlerCtor(uploadSessionsRepo, bucketsRepo, bucketAuthz).Handle)
+	uploads.Get("/:id", handlers.UploadSessionDetailHandlerCtor(uploadSessionsRepo, bucketsRepo, bucketAuthz).Handle)
+	uploads.Put("/:id/parts/:number", handlers.UploadPartHandlerCtor(uploadSessionsRepo, bucketsRepo, bucketAuthz).Handle)
+	uploads.Post("/:id/complete", handlers.UploadSessionCompleteHandlerCtor(uploadSessionsRepo, bucketsRepo, bucketAuthz).Handle)
+	uploads.Delete("/:id", handlers.UploadSessionAbortHandlerCtor(uploadSessionsRepo, bucketsRepo, bucketAuthz).Handle)
 	buckets := protected.Group("/buckets")
 	buckets.Get("/", handlers.BucketsListHandlerCtor(bucketsRepo).Handle)
-	buckets.Post("/", handlers.NewBucketHandlerCtor(bucketsRepo).Handle)
+	bucketProbe := srv.S3BucketProbeCtor()
+	buckets.Post("/", handlers.AllBucketsOnly(), handlers.NewBucketHandlerCtor(bucketsRepo, bucketProbe).Handle)
+	buckets.Post("/test-connection", handlers.AllBucketsOnly(), handlers.TestBucketConnectionHandlerCtor(bucketProbe).Handle)
+	buckets.Post("/discover", handlers.AllBucketsOnly(), handlers.DiscoverBucketsHandlerCtor().Handle)
+	buckets.Post("/import", handlers.AllBucketsOnly(), handlers.ImportBucketsHandlerCtor(bucketsRepo, bucketProbe).Handle)
+	buckets.Patch("/:id", handlers.SessionOnly(), handlers.UpdateBucketHandlerCtor(bucketsRepo, bucketProbe).Handle)
+	buckets.Delete("/:id", handlers.SessionOnly(), handlers.DeleteBucketHandlerCtor(bucketsRepo).Handle)
+	buckets.Put("/:id/org", handlers.SessionOnly(), handlers.TransferBucketHandlerCtor(bucketsRepo).Handle)
+	buckets.Get("/:id/grants", handlers.BucketGrantsListHandlerCtor(bucketsRepo, bucketAuthz).Handle)
+	buckets.Post("/:id/grants", handlers.SessionOnly(), handlers.BucketGrantCreateHandlerCtor(bucketsRepo, bucketAuthz).Handle)
+	buckets.Delete("/:id/grants/:grant_id", handlers.SessionOnly(), handlers.BucketGrantDeleteHandlerCtor(bucketsRepo, bucketAuthz).Handle)
+	protected.Get("/shares", handlers.ShareLinksListHandlerCtor(shareLinks).Handle)
+	protected.Post("/shares", handlers.SessionOnly(), handlers.ShareLinkCreateHandlerCtor(shareLinks, bucketsRepo).Handle)
+	protected.Delete("/shares/:id", handlers.ShareLinkDeleteHandlerCtor(shareLinks).Handle)
+	protected.Get("/drops", handlers.DropLinksListHandlerCtor(dropLinks).Handle)
+	protected.Post("/drops", handlers.SessionOnly(), handlers.DropLinkCreateHandlerCtor(dropLinks, bucketsRepo).Handle)
+	protected.Delete("/drops/:id", handlers.DropLinkDeleteHandlerCtor(dropLinks).Handle)
+	orgs := srv.OrgsSrvCtor(orgsRepo)
+	protected.Get("/users/invites", handlers.SessionOnly(), handlers.OrgInvitesListHandlerCtor(orgs).Handle)
+	protected.Post("/users/invites/:id/accept", handlers.SessionOnly(), handlers.OrgInviteAcceptHandlerCtor(orgs).Handle)
+	protected.Delete("/users/invites/:id", handlers.SessionOnly(), handlers.OrgInviteDeclineHandlerCtor(orgs).Handle)
+	orgsGroup := protected.Group("/orgs", handlers.SessionOnly())
+	orgsGroup.Get("/", handlers.OrgsListHandlerCtor(orgs).Handle)
+	orgsGroup.Post("/", handlers.OrgCreateHandlerCtor(orgs).Handle)
+	orgsGroup.Delete("/:id", handlers.OrgDeleteHandlerCtor(orgs).Handle)
+	orgsGroup.Get("/:id/members", handlers.OrgMembersListHandlerCtor(orgs).Handle)
+	orgsGroup.Patch("/:id/members/:user_id", handlers.OrgMemberUpdateHandlerCtor(orgs).Handle)
+	orgsGroup.Delete("/:id/members/:user_id", handlers.OrgMemberDeleteHandlerCtor(orgs).Handle)
+	orgsGroup.Post("/:id/invites", handlers.OrgInviteCreateHandlerCtor(orgs).Handle)
+	protected.Get("/audit", handlers.SessionOnly(), handlers.AuditListHandlerCtor(auditLog).Handle)
+	protected.Get("/audit/export", handlers.SessionOnly(), handlers.AuditExportHandlerCtor(auditLog).Handle)
 	fmt.Println("Run server...")
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -0,0 +1,105 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package handlers
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/blablatdinov/web-s3/src/repo"
+	fiber "github.com/gofiber/fiber/v2"
+)
+
+// tokenAllowsBucket reports whether the request may touch the bucket, only
+// API tokens limited to specific buckets can forbid it.
+func tokenAllowsBucket(c *fiber.Ctx, bucketID int) bool {
+	apiToken, ok := GetApiToken(c)
+	if !ok || len(apiToken.BucketIDs) == 0 {
+		return true
+	}
+	return slices.Contains(apiToken.BucketIDs, bucketID)
+}
+
+// tokenScopedBuckets hides buckets outside of the API token scope as if
+// they did not exist. Every bucket looked up through it is also noted as
+// the target of the request for the audit log.
+type tokenScopedBuckets struct {
+	repo.BucketsRepo
+	c *fiber.Ctx
+}
+
+func scopedBuckets(c *fiber.Ctx, bucketsRepo repo.BucketsRepo) repo.BucketsRepo {
+	return tokenScopedBuckets{bucketsRepo, c}
+}
+
+func (r tokenScopedBuckets) List(userID int) ([]repo.Bucket, error) {
+	buckets, err := r.BucketsRepo.List(userID)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(buckets, func(bucket repo.Bucket) bool {
+		return !tokenAllowsBucket(r.c, bucket.BucketID)
+	}), nil
+}
+
+func (r tokenScopedBuckets) GetByID(userID, bucketID int) (*repo.Bucket, error) {
+	auditTarget(r.c, bucketID)
+	if !tokenAllowsBucket(r.c, bucketID) {
+		return nil, fmt.Errorf("%w: %d", repo.ErrBucketNotFound, bucketID)
+	}
+	return r.BucketsRepo.GetByID(userID, bucketID)
+}
+
+func (r tokenScopedBuckets) GetManageable(userID, bucketID int) (*repo.Bucket, error) {
+	auditTarget(r.c, bucketID)
+	if !tokenAllowsBucket(r.c, bucketID) {
+		return nil, fmt.Errorf("%w: %d", repo.ErrBucketNotFound, bucketID)
+	}
+	return r.BucketsRepo.GetManageable(userID, bucketID)
+}
+
+func (r tokenScopedBuckets) Update(userID, bucketID int, bucketName, accessKeyID, secretAccessKey, region string, endpoint *string) error {
+	auditTarget(r.c, bucketID)
+	if !tokenAllowsBucket(r.c, bucketID) {
+		return fmt.Errorf("%w: %d", repo.ErrBucketNotFound, bucketID)
+	}
+	return r.BucketsRepo.Update(userID, bucketID, bucketName, accessKeyID, secretAccessKey, region, endpoint)
+}
+
+func (r tokenScopedBuckets) Delete(userID, bucketID int) error {
+	auditTarget(r.c, bucketID)
+	if !tokenAllowsBucket(r.c, bucketID) {
+		return fmt.Errorf("%w: %d", repo.ErrBucketNotFound, bucketID)
+	}
+	return r.BucketsRepo.Delete(userID, bucketID)
+}
+
+func (r tokenScopedBuckets) Transfer(userID, bucketID int, orgID *int) error {
+	auditTarget(r.c, bucketID)
+	if !tokenAllowsBucket(r.c, bucketID) {
+		return fmt.Errorf("%w: %d", repo.ErrBucketNotFound, bucketID)
+	}
+	return r.BucketsRepo.Transfer(userID, bucketID, orgID)
+}
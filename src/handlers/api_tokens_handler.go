@@ -0,0 +1,188 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/blablatdinov/web-s3/src/repo"
+	"github.com/blablatdinov/web-s3/src/srv"
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+func apiTokenJSON(token repo.ApiToken) fiber.Map {
+	return fiber.Map{
+		"api_token_id": token.ApiTokenID,
+		"name":         token.Name,
+		"prefix":       token.Prefix,
+		"scope":        token.Scope,
+		"bucket_ids":   token.BucketIDs,
+		"created_at":   token.CreatedAt,
+		"last_used_at": token.LastUsedAt,
+		"expires_at":   token.ExpiresAt,
+	}
+}
+
+type ApiTokensListHandler struct {
+	apiTokens srv.ApiTokens
+}
+
+func ApiTokensListHandlerCtor(apiTokens srv.ApiTokens) Handler {
+	return ApiTokensListHandler{apiTokens}
+}
+
+func (h ApiTokensListHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	tokens, err := h.apiTokens.List(userID)
+	if err != nil {
+		log.Error("Error listing api tokens. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error listing api tokens",
+		})
+	}
+	result := make([]fiber.Map, 0, len(tokens))
+	for _, token := range tokens {
+		result = append(result, apiTokenJSON(token))
+	}
+	return c.JSON(fiber.Map{
+		"tokens": result,
+	})
+}
+
+type ApiTokenCreateHandler struct {
+	apiTokens   srv.ApiTokens
+	bucketsRepo repo.BucketsRepo
+}
+
+func ApiTokenCreateHandlerCtor(apiTokens srv.ApiTokens, bucketsRepo repo.BucketsRepo) Handler {
+	return ApiTokenCreateHandler{apiTokens, bucketsRepo}
+}
+
+func (h ApiTokenCreateHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	body := struct {
+		Name      string     `json:"name"`
+		Scope     string     `json:"scope"`
+		BucketIDs []int      `json:"bucket_ids"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}{}
+	err := c.BodyParser(&body)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if body.Name == "" {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "name is required",
+		})
+	}
+	if body.Scope == "" {
+		body.Scope = repo.ApiTokenScopeRead
+	}
+	if body.ExpiresAt != nil && body.ExpiresAt.Before(time.Now()) {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "expires_at must be in the future",
+		})
+	}
+	for _, bucketID := range body.BucketIDs {
+		_, err = h.bucketsRepo.GetByID(userID, bucketID)
+		if err != nil {
+			if errors.Is(err, repo.ErrBucketNotFound) {
+				return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+					"error": "Bucket " + strconv.Itoa(bucketID) + " not found",
+				})
+			}
+			log.Error("Error getting bucket. Err=%s\n", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Error getting bucket",
+			})
+		}
+	}
+	token, rawToken, err := h.apiTokens.Create(userID, body.Name, body.Scope, body.BucketIDs, body.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, srv.ErrInvalidScope) {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error": "scope must be read or read-write",
+			})
+		}
+		log.Error("Error creating api token. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error creating api token",
+		})
+	}
+	result := apiTokenJSON(token)
+	result["token"] = rawToken
+	return c.Status(fiber.StatusCreated).JSON(result)
+}
+
+type ApiTokenDeleteHandler struct {
+	apiTokens srv.ApiTokens
+}
+
+func ApiTokenDeleteHandlerCtor(apiTokens srv.ApiTokens) Handler {
+	return ApiTokenDeleteHandler{apiTokens}
+}
+
+func (h ApiTokenDeleteHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	apiTokenID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid token id",
+		})
+	}
+	err = h.apiTokens.Revoke(userID, apiTokenID)
+	if err != nil {
+		if errors.Is(err, repo.ErrApiTokenNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Token not found",
+			})
+		}
+		log.Error("Error revoking api token. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error revoking api token",
+		})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,341 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blablatdinov/web-s3/src/repo"
+	"github.com/blablatdinov/web-s3/src/srv"
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+const (
+	auditBucketKey   = "audit_bucket_id"
+	auditObjectKey   = "audit_object_key"
+	auditUsernameKey = "audit_username"
+)
+
+// AuditMiddleware records every request passing through it once it is
+// answered. Actions are named by method and route, e.g. "GET
+// /files/:path/download", with routePrefix cut off. Requests stopped before
+// reaching a route, like ones with a bad token, keep their raw path.
+func AuditMiddleware(auditLog srv.AuditLog, routePrefix string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		mount := c.Route().Path
+		err := c.Next()
+		status := c.Response().StatusCode()
+		if err != nil {
+			status = fiber.StatusInternalServerError
+			var fiberErr *fiber.Error
+			if errors.As(err, &fiberErr) {
+				status = fiberErr.Code
+			}
+		}
+		path := c.Route().Path
+		if path == mount {
+			path = c.Path()
+		}
+		path = strings.TrimPrefix(path, routePrefix)
+		if len(path) > 1 {
+			path = strings.TrimSuffix(path, "/")
+		}
+		entry := repo.AuditEntry{
+			Action:    c.Method() + " " + path,
+			IP:        c.IP(),
+			UserAgent: c.Get(fiber.HeaderUserAgent),
+			Status:    status,
+		}
+		if userID, ok := GetUserID(c); ok {
+			entry.UserID = &userID
+		}
+		if username, ok := GetUsername(c); ok {
+			entry.Username = &username
+		} else if username, ok := c.Locals(auditUsernameKey).(string); ok && username != "" {
+			entry.Username = &username
+		}
+		if bucketID, ok := c.Locals(auditBucketKey).(int); ok {
+			entry.BucketID = &bucketID
+		}
+		if key, ok := c.Locals(auditObjectKey).(string); ok {
+			entry.Key = &key
+		}
+		recordErr := auditLog.Record(entry)
+		if recordErr != nil {
+			log.Error("Error recording audit entry. Err=%s\n", recordErr)
+		}
+		return err
+	}
+}
+
+// auditTarget names the bucket and keys the request is about. The first
+// call wins, so copy and move are recorded with their source.
+func auditTarget(c *fiber.Ctx, bucketID int, keys ...string) {
+	if c.Locals(auditBucketKey) == nil {
+		c.Locals(auditBucketKey, bucketID)
+	}
+	if len(keys) > 0 && c.Locals(auditObjectKey) == nil {
+		c.Locals(auditObjectKey, strings.Join(keys, "\n"))
+	}
+}
+
+// auditUsername names the user of a request made before authentication,
+// like a login attempt.
+func auditUsername(c *fiber.Ctx, username string) {
+	c.Locals(auditUsernameKey, username)
+}
+
+func auditEntryJSON(entry repo.AuditEntry) fiber.Map {
+	return fiber.Map{
+		"audit_id":   entry.AuditID,
+		"created_at": entry.CreatedAt,
+		"user_id":    entry.UserID,
+		"username":   entry.Username,
+		"action":     entry.Action,
+		"bucket_id":  entry.BucketID,
+		"key":        entry.Key,
+		"ip":         entry.IP,
+		"user_agent": entry.UserAgent,
+		"status":     entry.Status,
+		"result":     entry.Result,
+	}
+}
+
+// auditFilter reads the search query. On failure the error response is
+// already written and nil is returned.
+func auditFilter(c *fiber.Ctx) (*repo.AuditFilter, error) {
+	filter := repo.AuditFilter{
+		Username:  c.Query("username"),
+		Action:    c.Query("action"),
+		KeyPrefix: c.Query("key"),
+		Result:    c.Query("result"),
+	}
+	for name, dst := range map[string]**int{"user_id": &filter.UserID, "bucket_id": &filter.BucketID} {
+		if c.Query(name) == "" {
+			continue
+		}
+		val, err := strconv.Atoi(c.Query(name))
+		if err != nil {
+			return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid " + name,
+			})
+		}
+		*dst = &val
+	}
+	for name, dst := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		if c.Query(name) == "" {
+			continue
+		}
+		val, err := time.Parse(time.RFC3339, c.Query(name))
+		if err != nil {
+			return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid " + name + ", expected RFC 3339 time",
+			})
+		}
+		*dst = &val
+	}
+	if c.Query("before") != "" {
+		before, err := strconv.ParseInt(c.Query("before"), 10, 64)
+		if err != nil || before <= 0 {
+			return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid before",
+			})
+		}
+		filter.BeforeID = before
+	}
+	return &filter, nil
+}
+
+func auditSearchError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, srv.ErrInvalidAuditResult) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "result must be success, denied or failure",
+		})
+	}
+	log.Error("Error searching audit log. Err=%s\n", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Error searching audit log",
+	})
+}
+
+type AuditListHandler struct {
+	auditLog srv.AuditLog
+}
+
+func AuditListHandlerCtor(auditLog srv.AuditLog) Handler {
+	return AuditListHandler{auditLog}
+}
+
+func (h AuditListHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	filter, err := auditFilter(c)
+	if filter == nil {
+		return err
+	}
+	filter.Limit = c.QueryInt("page_size", srv.DefaultAuditPageSize)
+	if filter.Limit <= 0 || filter.Limit > srv.MaxAuditPageSize {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "page_size must be between 1 and " + strconv.Itoa(srv.MaxAuditPageSize),
+		})
+	}
+	entries, err := h.auditLog.Search(userID, *filter)
+	if err != nil {
+		return auditSearchError(c, err)
+	}
+	result := make([]fiber.Map, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, auditEntryJSON(entry))
+	}
+	var next *int64
+	if len(entries) == filter.Limit {
+		next = &entries[len(entries)-1].AuditID
+	}
+	return c.JSON(fiber.Map{
+		"entries": result,
+		"before":  next,
+	})
+}
+
+type AuditExportHandler struct {
+	auditLog srv.AuditLog
+}
+
+// AuditExportHandlerCtor streams every matching entry, newest first, as
+// CSV or JSON lines.
+func AuditExportHandlerCtor(auditLog srv.AuditLog) Handler {
+	return AuditExportHandler{auditLog}
+}
+
+var auditCsvHeader = []string{
+	"audit_id", "created_at", "user_id", "username", "action", "bucket_id",
+	"key", "ip", "user_agent", "status", "result",
+}
+
+func auditCsvRecord(entry repo.AuditEntry) []string {
+	optional := func(val *string) string {
+		if val == nil {
+			return ""
+		}
+		return *val
+	}
+	optionalInt := func(val *int) string {
+		if val == nil {
+			return ""
+		}
+		return strconv.Itoa(*val)
+	}
+	return []string{
+		strconv.FormatInt(entry.AuditID, 10),
+		entry.CreatedAt.UTC().Format(time.RFC3339),
+		optionalInt(entry.UserID),
+		optional(entry.Username),
+		entry.Action,
+		optionalInt(entry.BucketID),
+		optional(entry.Key),
+		entry.IP,
+		entry.UserAgent,
+		strconv.Itoa(entry.Status),
+		entry.Result,
+	}
+}
+
+func (h AuditExportHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	format := c.Query("format", "csv")
+	if format != "csv" && format != "jsonl" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "format must be csv or jsonl",
+		})
+	}
+	filter, err := auditFilter(c)
+	if filter == nil {
+		return err
+	}
+	filter.Limit = srv.MaxAuditPageSize
+	// The first page is fetched up front so that bad filters still get a
+	// proper error response instead of a broken download.
+	entries, err := h.auditLog.Search(userID, *filter)
+	if err != nil {
+		return auditSearchError(c, err)
+	}
+	if format == "csv" {
+		c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	} else {
+		c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	}
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="audit.`+format+`"`)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		csvWriter := csv.NewWriter(w)
+		jsonWriter := json.NewEncoder(w)
+		if format == "csv" {
+			csvWriter.Write(auditCsvHeader)
+		}
+		for {
+			for _, entry := range entries {
+				if format == "csv" {
+					err = csvWriter.Write(auditCsvRecord(entry))
+				} else {
+					err = jsonWriter.Encode(auditEntryJSON(entry))
+				}
+				if err != nil {
+					log.Errorf("Error streaming audit export: %s", err)
+					return
+				}
+			}
+			csvWriter.Flush()
+			err = w.Flush()
+			if err != nil {
+				log.Errorf("Error flushing audit export: %s", err)
+				return
+			}
+			if len(entries) < filter.Limit {
+				return
+			}
+			filter.BeforeID = entries[len(entries)-1].AuditID
+			entries, err = h.auditLog.Search(userID, *filter)
+			if err != nil {
+				log.Errorf("Error searching audit log for export: %s", err)
+				return
+			}
+		}
+	})
+	return nil
+}
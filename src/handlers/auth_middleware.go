@@ -1,18 +1,25 @@
 package handlers
 
 import (
+	"errors"
 	"strings"
 
+	"github.com/blablatdinov/web-s3/src/repo"
 	"github.com/blablatdinov/web-s3/src/srv"
 	fiber "github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
 )
 
 const (
-	UserIDKey   = "user_id"
-	UsernameKey = "username"
+	UserIDKey    = "user_id"
+	UsernameKey  = "username"
+	TokenKey     = "token"
+	SessionIDKey = "session_id"
+	ApiTokenKey  = "api_token"
 )
 
-func AuthMiddleware(userAuthSrv srv.UserAuth) fiber.Handler {
+// AuthMiddleware accepts either an access JWT or a personal API token.
+func AuthMiddleware(userAuthSrv srv.UserAuth, apiTokens srv.ApiTokens) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		authHeader := c.Get("Authorization")
 		if authHeader == "" {
@@ -28,22 +35,99 @@ func AuthMiddleware(userAuthSrv srv.UserAuth) fiber.Handler {
 		}
 
 		token := parts[1]
+		if srv.IsApiToken(token) {
+			return authenticateApiToken(c, apiTokens, token)
+		}
 		claims, err := userAuthSrv.ExtractClaims(token)
 		if err != nil {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"error": "Invalid or expired token",
 			})
 		}
+		c.Locals(TokenKey, token)
 		if userID, ok := claims["user_id"].(float64); ok {
 			c.Locals(UserIDKey, int(userID))
 		}
 		if username, ok := claims["username"].(string); ok {
 			c.Locals(UsernameKey, username)
 		}
+		if sessionID, ok := claims["sid"].(string); ok {
+			c.Locals(SessionIDKey, sessionID)
+			err = userAuthSrv.Touch(sessionID, requestClient(c))
+			if err != nil {
+				log.Warnf("Error updating session last use: %s", err)
+			}
+		}
+		return c.Next()
+	}
+}
+
+func authenticateApiToken(c *fiber.Ctx, apiTokens srv.ApiTokens, token string) error {
+	apiToken, err := apiTokens.Authenticate(token)
+	if err != nil {
+		if !errors.Is(err, srv.ErrInvalidApiToken) {
+			log.Errorf("Error checking api token: %s", err)
+		}
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired token",
+		})
+	}
+	if apiToken.Scope == repo.ApiTokenScopeRead && !readOnlyRequest(c) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Token is read-only",
+		})
+	}
+	c.Locals(UserIDKey, apiToken.UserID)
+	c.Locals(UsernameKey, apiToken.Username)
+	c.Locals(ApiTokenKey, apiToken)
+	return c.Next()
+}
+
+// readOnlyRequest tells whether a read-only token may make the request.
+// Archive downloads are POSTed only because of the key list in the body.
+func readOnlyRequest(c *fiber.Ctx) bool {
+	switch c.Method() {
+	case fiber.MethodGet, fiber.MethodHead:
+		return true
+	case fiber.MethodPost:
+		return strings.HasSuffix(c.Path(), "/files/archive")
+	}
+	return false
+}
+
+// SessionOnly rejects requests authenticated with an API token. It guards
+// account and bucket management and public links, so a leaked token can
+// not mint new tokens, grant access or publish files in a way that
+// outlives revoking it, nor lock the owner out.
+func SessionOnly() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if _, ok := GetApiToken(c); ok {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not available for API tokens",
+			})
+		}
 		return c.Next()
 	}
 }
 
+// AllBucketsOnly rejects API tokens limited to specific buckets, for
+// routes that are not about one existing bucket.
+func AllBucketsOnly() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if apiToken, ok := GetApiToken(c); ok && len(apiToken.BucketIDs) > 0 {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Token is limited to specific buckets",
+			})
+		}
+		return c.Next()
+	}
+}
+
+func GetApiToken(c *fiber.Ctx) (repo.ApiToken, bool) {
+	apiToken, ok := c.Locals(ApiTokenKey).(repo.ApiToken)
+	return apiToken, ok
+}
+
 func GetUserID(c *fiber.Ctx) (int, bool) {
 	userID, ok := c.Locals(UserIDKey).(int)
 	return userID, ok
@@ -53,3 +137,13 @@ func GetUsername(c *fiber.Ctx) (string, bool) {
 	username, ok := c.Locals(UsernameKey).(string)
 	return username, ok
 }
+
+func GetToken(c *fiber.Ctx) (string, bool) {
+	token, ok := c.Locals(TokenKey).(string)
+	return token, ok
+}
+
+func GetSessionID(c *fiber.Ctx) (string, bool) {
+	sessionID, ok := c.Locals(SessionIDKey).(string)
+	return sessionID, ok
+}
@@ -0,0 +1,278 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/blablatdinov/web-s3/src/repo"
+	"github.com/blablatdinov/web-s3/src/srv"
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+// authorizeBucket asks the central bucket authorization whether the user
+// may do the action on the keys. On refusal the error response is already
+// written and false is returned.
+func authorizeBucket(
+	c *fiber.Ctx,
+	authz srv.BucketAuthz,
+	userID int,
+	bucket *repo.Bucket,
+	action srv.BucketAction,
+	keys ...string,
+) (bool, error) {
+	auditTarget(c, bucket.BucketID, keys...)
+	err := authz.Authorize(userID, bucket, action, keys...)
+	if err == nil {
+		return true, nil
+	}
+	return false, bucketAuthzError(c, err)
+}
+
+// guardOverwrite keeps users who may write but not overwrite, like
+// uploaders, from replacing an existing object. It returns the If-None-Match
+// value to send with the write, nil when overwriting is allowed. On refusal
+// the error response is already written and false is returned.
+func guardOverwrite(
+	c *fiber.Ctx,
+	ctx context.Context,
+	authz srv.BucketAuthz,
+	userID int,
+	bucket *repo.Bucket,
+	s3Client *s3.Client,
+	key string,
+) (*string, bool, error) {
+	err := authz.Authorize(userID, bucket, srv.BucketActionOverwrite, key)
+	if err == nil {
+		return nil, true, nil
+	}
+	if !errors.Is(err, srv.ErrBucketForbidden) {
+		return nil, false, bucketAuthzError(c, err)
+	}
+	exists, err := srv.ObjectExists(ctx, s3Client, bucket.BucketName, key)
+	if err != nil {
+		log.Errorf("Error checking object in S3: %s", err)
+		return nil, false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error checking existing file",
+		})
+	}
+	if exists {
+		return nil, false, objectExistsError(c)
+	}
+	return aws.String("*"), true, nil
+}
+
+func objectExistsError(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+		"error": "File already exists, your role can not overwrite it",
+	})
+}
+
+func bucketAuthzError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, srv.ErrBucketForbidden) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Permission denied",
+		})
+	}
+	log.Error("Error checking bucket permissions. Err=%s\n", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Error checking bucket permissions",
+	})
+}
+
+func bucketGrantJSON(grant repo.BucketGrant) fiber.Map {
+	return fiber.Map{
+		"bucket_grant_id": grant.BucketGrantID,
+		"user_id":         grant.UserID,
+		"username":        grant.Username,
+		"org_id":          grant.OrgID,
+		"org_name":        grant.OrgName,
+		"role":            grant.Role,
+		"prefix":          grant.Prefix,
+		"created_at":      grant.CreatedAt,
+	}
+}
+
+// grantsBucket resolves the bucket of the ":id" route param. On failure the
+// error response is already written and a nil bucket is returned.
+func grantsBucket(c *fiber.Ctx, bucketsRepo repo.BucketsRepo, userID int) (*repo.Bucket, error) {
+	bucketID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid bucket_id",
+		})
+	}
+	bucket, err := scopedBuckets(c, bucketsRepo).GetByID(userID, bucketID)
+	if err != nil {
+		if errors.Is(err, repo.ErrBucketNotFound) {
+			return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Bucket not found",
+			})
+		}
+		log.Error("Error getting bucket. Err=%s\n", err)
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error getting bucket",
+		})
+	}
+	return bucket, nil
+}
+
+type BucketGrantsListHandler struct {
+	bucketsRepo repo.BucketsRepo
+	authz       srv.BucketAuthz
+}
+
+func BucketGrantsListHandlerCtor(bucketsRepo repo.BucketsRepo, authz srv.BucketAuthz) Handler {
+	return BucketGrantsListHandler{bucketsRepo, authz}
+}
+
+func (h BucketGrantsListHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	bucket, err := grantsBucket(c, h.bucketsRepo, userID)
+	if bucket == nil {
+		return err
+	}
+	grants, err := h.authz.Grants(userID, bucket)
+	if err != nil {
+		return bucketAuthzError(c, err)
+	}
+	result := make([]fiber.Map, 0, len(grants))
+	for _, grant := range grants {
+		result = append(result, bucketGrantJSON(grant))
+	}
+	return c.JSON(fiber.Map{
+		"grants": result,
+	})
+}
+
+type BucketGrantCreateHandler struct {
+	bucketsRepo repo.BucketsRepo
+	authz       srv.BucketAuthz
+}
+
+func BucketGrantCreateHandlerCtor(bucketsRepo repo.BucketsRepo, authz srv.BucketAuthz) Handler {
+	return BucketGrantCreateHandler{bucketsRepo, authz}
+}
+
+func (h BucketGrantCreateHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	body := struct {
+		Username string `json:"username"`
+		OrgID    *int   `json:"org_id"`
+		Role     string `json:"role"`
+		Prefix   string `json:"prefix"`
+	}{}
+	err := c.BodyParser(&body)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	bucket, err := grantsBucket(c, h.bucketsRepo, userID)
+	if bucket == nil {
+		return err
+	}
+	grant, err := h.authz.Grant(userID, bucket, repo.NewBucketGrant{
+		Username: body.Username,
+		OrgID:    body.OrgID,
+		Role:     body.Role,
+		Prefix:   body.Prefix,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, srv.ErrInvalidBucketRole):
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error": "role must be viewer, uploader, editor or admin",
+			})
+		case errors.Is(err, srv.ErrInvalidGrantee):
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error": "Either username or org_id is required",
+			})
+		case errors.Is(err, repo.ErrUserNotFound):
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		case errors.Is(err, repo.ErrOrgNotFound):
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error": "Organization not found",
+			})
+		}
+		return bucketAuthzError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(bucketGrantJSON(grant))
+}
+
+type BucketGrantDeleteHandler struct {
+	bucketsRepo repo.BucketsRepo
+	authz       srv.BucketAuthz
+}
+
+func BucketGrantDeleteHandlerCtor(bucketsRepo repo.BucketsRepo, authz srv.BucketAuthz) Handler {
+	return BucketGrantDeleteHandler{bucketsRepo, authz}
+}
+
+func (h BucketGrantDeleteHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	bucketGrantID, err := strconv.Atoi(c.Params("grant_id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid grant id",
+		})
+	}
+	bucket, err := grantsBucket(c, h.bucketsRepo, userID)
+	if bucket == nil {
+		return err
+	}
+	err = h.authz.Revoke(userID, bucket, bucketGrantID)
+	if err != nil {
+		if errors.Is(err, repo.ErrBucketGrantNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Grant not found",
+			})
+		}
+		return bucketAuthzError(c, err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -25,14 +25,31 @@ OR OTHER DEALINGS IN THE SOFTWARE.
 package handlers
 
 import (
+	"context"
 	"errors"
-	"fmt"
+	"strconv"
 
 	"github.com/blablatdinov/web-s3/src/repo"
+	"github.com/blablatdinov/web-s3/src/srv"
 	fiber "github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/log"
 )
 
+// safeBucket renders a bucket without its secret access key.
+func safeBucket(bucket repo.Bucket) fiber.Map {
+	return fiber.Map{
+		"bucket_id":     bucket.BucketID,
+		"user_id":       bucket.UserID,
+		"org_id":        bucket.OrgID,
+		"bucket_name":   bucket.BucketName,
+		"access_key_id": bucket.AccessKeyID,
+		"region":        bucket.Region,
+		"endpoint":      bucket.Endpoint,
+		"created_at":    bucket.CreatedAt,
+		"updated_at":    bucket.UpdatedAt,
+	}
+}
+
 type BucketsListHandler struct {
 	bucketsRepo repo.BucketsRepo
 }
@@ -48,7 +65,7 @@ func (h BucketsListHandler) Handle(c *fiber.Ctx) error {
 			"error": "User ID not found in context",
 		})
 	}
-	buckets, err := h.bucketsRepo.List(userID)
+	buckets, err := scopedBuckets(c, h.bucketsRepo).List(userID)
 	if err != nil {
 		if errors.Is(err, repo.ErrSQL) {
 			log.Error("Error listing buckets. Err=%s\n", err)
@@ -63,16 +80,7 @@ func (h BucketsListHandler) Handle(c *fiber.Ctx) error {
 	}
 	safeBuckets := make([]fiber.Map, 0, len(buckets))
 	for _, bucket := range buckets {
-		safeBuckets = append(safeBuckets, fiber.Map{
-			"bucket_id":     bucket.BucketID,
-			"user_id":       bucket.UserID,
-			"bucket_name":   bucket.BucketName,
-			"access_key_id": bucket.AccessKeyID,
-			"region":        bucket.Region,
-			"endpoint":      bucket.Endpoint,
-			"created_at":    bucket.CreatedAt,
-			"updated_at":    bucket.UpdatedAt,
-		})
+		safeBuckets = append(safeBuckets, safeBucket(bucket))
 	}
 	return c.JSON(fiber.Map{
 		"buckets": safeBuckets,
@@ -81,10 +89,11 @@ func (h BucketsListHandler) Handle(c *fiber.Ctx) error {
 
 type NewBucketHandler struct {
 	bucketsRepo repo.BucketsRepo
+	probe       srv.BucketProbe
 }
 
-func NewBucketHandlerCtor(bucketsRepo repo.BucketsRepo) Handler {
-	return NewBucketHandler{bucketsRepo: bucketsRepo}
+func NewBucketHandlerCtor(bucketsRepo repo.BucketsRepo, probe srv.BucketProbe) Handler {
+	return NewBucketHandler{bucketsRepo: bucketsRepo, probe: probe}
 }
 
 func (h NewBucketHandler) Handle(c *fiber.Ctx) error {
@@ -108,7 +117,6 @@ func (h NewBucketHandler) Handle(c *fiber.Ctx) error {
 			"error": "Invalid request body",
 		})
 	}
-	fmt.Printf("body=%v\n", body)
 	if body.BucketName == "" {
 		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
 			"error": "bucket_name is required",
@@ -127,6 +135,19 @@ func (h NewBucketHandler) Handle(c *fiber.Ctx) error {
 	if body.Region == "" {
 		body.Region = "us-east-1"
 	}
+	diagnosis := h.probe.Probe(context.Background(), &repo.Bucket{
+		BucketName:      body.BucketName,
+		AccessKeyID:     body.AccessKeyID,
+		SecretAccessKey: body.SecretAccessKey,
+		Region:          body.Region,
+		Endpoint:        body.Endpoint,
+	})
+	if !diagnosis.OK {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":     "Bucket connection check failed",
+			"diagnosis": diagnosis,
+		})
+	}
 	bucketID, err := h.bucketsRepo.Create(
 		userID,
 		body.BucketName,
@@ -152,8 +173,412 @@ func (h NewBucketHandler) Handle(c *fiber.Ctx) error {
 			"error": "Internal server error",
 		})
 	}
+	auditTarget(c, bucketID)
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
 		"bucket_id":   bucketID,
 		"bucket_name": body.BucketName,
 	})
 }
+
+type UpdateBucketHandler struct {
+	bucketsRepo repo.BucketsRepo
+	probe       srv.BucketProbe
+}
+
+func UpdateBucketHandlerCtor(bucketsRepo repo.BucketsRepo, probe srv.BucketProbe) Handler {
+	return UpdateBucketHandler{bucketsRepo: bucketsRepo, probe: probe}
+}
+
+func (h UpdateBucketHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	bucketID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid bucket_id",
+		})
+	}
+	body := struct {
+		BucketName      *string `json:"bucket_name"`
+		AccessKeyID     *string `json:"access_key_id"`
+		SecretAccessKey *string `json:"secret_access_key"`
+		Region          *string `json:"region"`
+		Endpoint        *string `json:"endpoint"`
+	}{}
+	err = c.BodyParser(&body)
+	if err != nil {
+		log.Error("Error parsing body. Err=%s\n", err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	// The probe signs requests with the stored keys, so only users allowed
+	// to change the bucket may point it at another endpoint.
+	bucket, err := scopedBuckets(c, h.bucketsRepo).GetManageable(userID, bucketID)
+	if err != nil {
+		if errors.Is(err, repo.ErrBucketNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Bucket not found",
+			})
+		}
+		log.Error("Error getting bucket. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error getting bucket",
+		})
+	}
+	if body.BucketName != nil {
+		if *body.BucketName == "" {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error": "bucket_name must not be empty",
+			})
+		}
+		bucket.BucketName = *body.BucketName
+	}
+	if body.AccessKeyID != nil {
+		if *body.AccessKeyID == "" {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error": "access_key_id must not be empty",
+			})
+		}
+		bucket.AccessKeyID = *body.AccessKeyID
+	}
+	if body.SecretAccessKey != nil {
+		if *body.SecretAccessKey == "" {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error": "secret_access_key must not be empty",
+			})
+		}
+		bucket.SecretAccessKey = *body.SecretAccessKey
+	}
+	if body.Region != nil {
+		bucket.Region = *body.Region
+		if bucket.Region == "" {
+			bucket.Region = "us-east-1"
+		}
+	}
+	if body.Endpoint != nil {
+		bucket.Endpoint = body.Endpoint
+		if *body.Endpoint == "" {
+			bucket.Endpoint = nil
+		}
+	}
+	diagnosis := h.probe.Probe(context.Background(), bucket)
+	if !diagnosis.OK {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":     "Bucket connection check failed",
+			"diagnosis": diagnosis,
+		})
+	}
+	err = scopedBuckets(c, h.bucketsRepo).Update(
+		userID,
+		bucketID,
+		bucket.BucketName,
+		bucket.AccessKeyID,
+		bucket.SecretAccessKey,
+		bucket.Region,
+		bucket.Endpoint,
+	)
+	if err != nil {
+		if errors.Is(err, repo.ErrBucketNameAlreadyExists) {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error": "Bucket name already exists",
+			})
+		}
+		if errors.Is(err, repo.ErrBucketNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Bucket not found",
+			})
+		}
+		log.Error("Error updating bucket. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error updating bucket",
+		})
+	}
+	updated, err := scopedBuckets(c, h.bucketsRepo).GetByID(userID, bucketID)
+	if err != nil {
+		log.Error("Error getting bucket. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error getting bucket",
+		})
+	}
+	return c.JSON(safeBucket(*updated))
+}
+
+type DeleteBucketHandler struct {
+	bucketsRepo repo.BucketsRepo
+}
+
+func DeleteBucketHandlerCtor(bucketsRepo repo.BucketsRepo) Handler {
+	return DeleteBucketHandler{bucketsRepo: bucketsRepo}
+}
+
+func (h DeleteBucketHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	bucketID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid bucket_id",
+		})
+	}
+	err = scopedBuckets(c, h.bucketsRepo).Delete(userID, bucketID)
+	if err != nil {
+		if errors.Is(err, repo.ErrBucketNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Bucket not found",
+			})
+		}
+		log.Error("Error deleting bucket. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error deleting bucket",
+		})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+type TransferBucketHandler struct {
+	bucketsRepo repo.BucketsRepo
+}
+
+func TransferBucketHandlerCtor(bucketsRepo repo.BucketsRepo) Handler {
+	return TransferBucketHandler{bucketsRepo: bucketsRepo}
+}
+
+// Handle shares the bucket with an organization or, for a null org_id,
+// takes it back as a personal bucket.
+func (h TransferBucketHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	bucketID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid bucket_id",
+		})
+	}
+	body := struct {
+		OrgID *int `json:"org_id"`
+	}{}
+	err = c.BodyParser(&body)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	err = scopedBuckets(c, h.bucketsRepo).Transfer(userID, bucketID, body.OrgID)
+	if err != nil {
+		if errors.Is(err, repo.ErrBucketNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Bucket or organization not found",
+			})
+		}
+		if errors.Is(err, repo.ErrBucketNameAlreadyExists) {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error": "Bucket name already exists",
+			})
+		}
+		log.Error("Error transferring bucket. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error transferring bucket",
+		})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+type TestBucketConnectionHandler struct {
+	probe srv.BucketProbe
+}
+
+func TestBucketConnectionHandlerCtor(probe srv.BucketProbe) Handler {
+	return TestBucketConnectionHandler{probe: probe}
+}
+
+func (h TestBucketConnectionHandler) Handle(c *fiber.Ctx) error {
+	body := struct {
+		BucketName      string  `json:"bucket_name"`
+		AccessKeyID     string  `json:"access_key_id"`
+		SecretAccessKey string  `json:"secret_access_key"`
+		Region          string  `json:"region"`
+		Endpoint        *string `json:"endpoint,omitempty"`
+	}{}
+	err := c.BodyParser(&body)
+	if err != nil {
+		log.Error("Error parsing body. Err=%s\n", err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if body.BucketName == "" {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "bucket_name is required",
+		})
+	}
+	if body.Region == "" {
+		body.Region = "us-east-1"
+	}
+	diagnosis := h.probe.Probe(context.Background(), &repo.Bucket{
+		BucketName:      body.BucketName,
+		AccessKeyID:     body.AccessKeyID,
+		SecretAccessKey: body.SecretAccessKey,
+		Region:          body.Region,
+		Endpoint:        body.Endpoint,
+	})
+	return c.JSON(diagnosis)
+}
+
+type DiscoverBucketsHandler struct{}
+
+func DiscoverBucketsHandlerCtor() Handler {
+	return DiscoverBucketsHandler{}
+}
+
+func (h DiscoverBucketsHandler) Handle(c *fiber.Ctx) error {
+	body := struct {
+		AccessKeyID     string  `json:"access_key_id"`
+		SecretAccessKey string  `json:"secret_access_key"`
+		Region          string  `json:"region"`
+		Endpoint        *string `json:"endpoint,omitempty"`
+	}{}
+	err := c.BodyParser(&body)
+	if err != nil {
+		log.Error("Error parsing body. Err=%s\n", err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if body.AccessKeyID == "" || body.SecretAccessKey == "" {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "access_key_id and secret_access_key are required",
+		})
+	}
+	if body.Region == "" {
+		body.Region = "us-east-1"
+	}
+	discovered, err := srv.DiscoverBuckets(context.Background(), &repo.Bucket{
+		AccessKeyID:     body.AccessKeyID,
+		SecretAccessKey: body.SecretAccessKey,
+		Region:          body.Region,
+		Endpoint:        body.Endpoint,
+	})
+	if err != nil {
+		log.Errorf("Failed to list buckets: %s", err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":     "Failed to list buckets",
+			"diagnosis": srv.DiagnoseS3Error(err),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"buckets": discovered,
+	})
+}
+
+type ImportBucketsHandler struct {
+	bucketsRepo repo.BucketsRepo
+	probe       srv.BucketProbe
+}
+
+func ImportBucketsHandlerCtor(bucketsRepo repo.BucketsRepo, probe srv.BucketProbe) Handler {
+	return ImportBucketsHandler{bucketsRepo: bucketsRepo, probe: probe}
+}
+
+// Handle registers buckets picked from discovery. Names and regions are
+// resolved again on the server, nothing is imported unless every bucket
+// is visible to the key and passes the connection check.
+func (h ImportBucketsHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	body := struct {
+		AccessKeyID     string  `json:"access_key_id"`
+		SecretAccessKey string  `json:"secret_access_key"`
+		Region          string  `json:"region"`
+		Endpoint        *string `json:"endpoint,omitempty"`
+		Buckets         []struct {
+			BucketName string `json:"bucket_name"`
+		} `json:"buckets"`
+	}{}
+	err := c.BodyParser(&body)
+	if err != nil {
+		log.Error("Error parsing body. Err=%s\n", err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if body.AccessKeyID == "" || body.SecretAccessKey == "" {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "access_key_id and secret_access_key are required",
+		})
+	}
+	if len(body.Buckets) == 0 {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "buckets are required",
+		})
+	}
+	if body.Region == "" {
+		body.Region = "us-east-1"
+	}
+	bucketNames := make([]string, 0, len(body.Buckets))
+	for _, bucket := range body.Buckets {
+		if bucket.BucketName == "" {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error": "bucket_name is required",
+			})
+		}
+		bucketNames = append(bucketNames, bucket.BucketName)
+	}
+	newBuckets, rejected, err := srv.ResolveImport(context.Background(), &repo.Bucket{
+		AccessKeyID:     body.AccessKeyID,
+		SecretAccessKey: body.SecretAccessKey,
+		Region:          body.Region,
+		Endpoint:        body.Endpoint,
+	}, bucketNames, h.probe)
+	if err != nil {
+		log.Errorf("Failed to list buckets: %s", err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":     "Failed to list buckets",
+			"diagnosis": srv.DiagnoseS3Error(err),
+		})
+	}
+	if len(rejected) > 0 {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":    "Some buckets can not be imported",
+			"rejected": rejected,
+		})
+	}
+	bucketIDs, err := h.bucketsRepo.CreateMany(userID, newBuckets)
+	if err != nil {
+		if errors.Is(err, repo.ErrBucketNameAlreadyExists) {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		log.Error("Error importing buckets. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error importing buckets",
+		})
+	}
+	imported := make([]fiber.Map, 0, len(bucketIDs))
+	for i, bucketID := range bucketIDs {
+		imported = append(imported, fiber.Map{
+			"bucket_id":   bucketID,
+			"bucket_name": newBuckets[i].BucketName,
+		})
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"buckets": imported,
+	})
+}
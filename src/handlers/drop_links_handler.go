@@ -0,0 +1,357 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/blablatdinov/web-s3/src/repo"
+	"github.com/blablatdinov/web-s3/src/srv"
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+func dropLinkJSON(link repo.DropLink) fiber.Map {
+	return fiber.Map{
+		"drop_link_id":       link.DropLinkID,
+		"bucket_id":          link.BucketID,
+		"bucket_name":        link.BucketName,
+		"prefix":             link.Prefix,
+		"token_prefix":       link.TokenPrefix,
+		"expires_at":         link.ExpiresAt,
+		"max_file_size":      link.MaxFileSize,
+		"allowed_extensions": link.AllowedExtensions,
+		"quota_bytes":        link.QuotaBytes,
+		"used_bytes":         link.UsedBytes,
+		"created_at":         link.CreatedAt,
+	}
+}
+
+type DropLinksListHandler struct {
+	dropLinks srv.DropLinks
+}
+
+func DropLinksListHandlerCtor(dropLinks srv.DropLinks) Handler {
+	return DropLinksListHandler{dropLinks}
+}
+
+func (h DropLinksListHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	links, err := h.dropLinks.List(userID)
+	if err != nil {
+		log.Error("Error listing drop links. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error listing drop links",
+		})
+	}
+	result := make([]fiber.Map, 0, len(links))
+	for _, link := range links {
+		if tokenAllowsBucket(c, link.BucketID) {
+			result = append(result, dropLinkJSON(link))
+		}
+	}
+	return c.JSON(fiber.Map{
+		"links": result,
+	})
+}
+
+type DropLinkCreateHandler struct {
+	dropLinks   srv.DropLinks
+	bucketsRepo repo.BucketsRepo
+}
+
+func DropLinkCreateHandlerCtor(dropLinks srv.DropLinks, bucketsRepo repo.BucketsRepo) Handler {
+	return DropLinkCreateHandler{dropLinks, bucketsRepo}
+}
+
+func (h DropLinkCreateHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	body := struct {
+		BucketID          int        `json:"bucket_id"`
+		Prefix            string     `json:"prefix"`
+		ExpiresAt         *time.Time `json:"expires_at"`
+		MaxFileSize       *int64     `json:"max_file_size"`
+		AllowedExtensions []string   `json:"allowed_extensions"`
+		QuotaBytes        *int64     `json:"quota_bytes"`
+	}{}
+	err := c.BodyParser(&body)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if body.ExpiresAt != nil && body.ExpiresAt.Before(time.Now()) {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "expires_at must be in the future",
+		})
+	}
+	if body.MaxFileSize != nil && *body.MaxFileSize < 1 {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "max_file_size must be positive",
+		})
+	}
+	if body.QuotaBytes != nil && *body.QuotaBytes < 1 {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "quota_bytes must be positive",
+		})
+	}
+	bucket, err := scopedBuckets(c, h.bucketsRepo).GetByID(userID, body.BucketID)
+	if err != nil {
+		if errors.Is(err, repo.ErrBucketNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Bucket not found",
+			})
+		}
+		log.Error("Error getting bucket. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error getting bucket",
+		})
+	}
+	link, rawToken, err := h.dropLinks.Create(userID, bucket, srv.NewDropLink{
+		Prefix:            body.Prefix,
+		ExpiresAt:         body.ExpiresAt,
+		MaxFileSize:       body.MaxFileSize,
+		AllowedExtensions: body.AllowedExtensions,
+		QuotaBytes:        body.QuotaBytes,
+	})
+	if err != nil {
+		if errors.Is(err, srv.ErrBucketForbidden) {
+			return bucketAuthzError(c, err)
+		}
+		log.Error("Error creating drop link. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error creating drop link",
+		})
+	}
+	result := dropLinkJSON(link)
+	result["token"] = rawToken
+	result["url"] = c.BaseURL() + strings.TrimSuffix(c.Path(), "/drops") + "/d/" + rawToken
+	return c.Status(fiber.StatusCreated).JSON(result)
+}
+
+type DropLinkDeleteHandler struct {
+	dropLinks srv.DropLinks
+}
+
+func DropLinkDeleteHandlerCtor(dropLinks srv.DropLinks) Handler {
+	return DropLinkDeleteHandler{dropLinks}
+}
+
+func (h DropLinkDeleteHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	dropLinkID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid drop link id",
+		})
+	}
+	err = h.dropLinks.Revoke(userID, dropLinkID)
+	if err != nil {
+		if errors.Is(err, repo.ErrDropLinkNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Drop link not found",
+			})
+		}
+		log.Error("Error revoking drop link. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error revoking drop link",
+		})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// openDropLink resolves the ":token" route param. On failure the error
+// response is already written and ok is false.
+func openDropLink(c *fiber.Ctx, dropLinks srv.DropLinks) (repo.DropLink, bool, error) {
+	link, err := dropLinks.Open(c.Params("token"))
+	if err != nil {
+		if errors.Is(err, srv.ErrInvalidDropLink) {
+			return repo.DropLink{}, false, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Drop link not found",
+			})
+		}
+		log.Error("Error opening drop link. Err=%s\n", err)
+		return repo.DropLink{}, false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error opening drop link",
+		})
+	}
+	return link, true, nil
+}
+
+// DropLinkInfoHandler tells an uploader the limits of the link, nothing
+// about the bucket is shown.
+type DropLinkInfoHandler struct {
+	dropLinks srv.DropLinks
+}
+
+func DropLinkInfoHandlerCtor(dropLinks srv.DropLinks) Handler {
+	return DropLinkInfoHandler{dropLinks}
+}
+
+func (h DropLinkInfoHandler) Handle(c *fiber.Ctx) error {
+	link, ok, err := openDropLink(c, h.dropLinks)
+	if !ok {
+		return err
+	}
+	var remaining *int64
+	if link.QuotaBytes != nil {
+		left := max(*link.QuotaBytes-link.UsedBytes, 0)
+		remaining = &left
+	}
+	return c.JSON(fiber.Map{
+		"expires_at":         link.ExpiresAt,
+		"max_file_size":      link.MaxFileSize,
+		"allowed_extensions": link.AllowedExtensions,
+		"remaining_bytes":    remaining,
+	})
+}
+
+// DropLinkUploadHandler takes the file as the raw request body with its
+// name in the name query parameter, Content-Length is required to check
+// the limits before anything is stored.
+type DropLinkUploadHandler struct {
+	dropLinks   srv.DropLinks
+	bucketsRepo repo.BucketsRepo
+	authz       srv.BucketAuthz
+}
+
+func DropLinkUploadHandlerCtor(dropLinks srv.DropLinks, bucketsRepo repo.BucketsRepo, authz srv.BucketAuthz) Handler {
+	return DropLinkUploadHandler{dropLinks, bucketsRepo, authz}
+}
+
+func (h DropLinkUploadHandler) Handle(c *fiber.Ctx) error {
+	link, ok, err := openDropLink(c, h.dropLinks)
+	if !ok {
+		return err
+	}
+	size := int64(c.Request().Header.ContentLength())
+	if size < 0 {
+		return c.Status(fiber.StatusLengthRequired).JSON(fiber.Map{
+			"error": "Content-Length is required",
+		})
+	}
+	auditTarget(c, link.BucketID)
+	// The link works only while its creator can still write under the prefix.
+	bucket, err := h.bucketsRepo.GetByID(link.UserID, link.BucketID)
+	if err == nil {
+		err = h.authz.Authorize(link.UserID, bucket, srv.BucketActionWrite, link.Prefix)
+	}
+	if err != nil {
+		if errors.Is(err, repo.ErrBucketNotFound) || errors.Is(err, srv.ErrBucketForbidden) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Drop link not found",
+			})
+		}
+		log.Error("Error getting drop link bucket. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error getting bucket",
+		})
+	}
+	key, err := h.dropLinks.Accept(link, c.Query("name"), size)
+	if err != nil {
+		switch {
+		case errors.Is(err, srv.ErrInvalidFileName):
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error": "name is required",
+			})
+		case errors.Is(err, srv.ErrExtensionNotAllowed):
+			return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{
+				"error":              "File extension not allowed",
+				"allowed_extensions": link.AllowedExtensions,
+			})
+		case errors.Is(err, srv.ErrFileTooLarge):
+			return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+				"error":         "File too large",
+				"max_file_size": link.MaxFileSize,
+			})
+		case errors.Is(err, repo.ErrDropLinkQuotaExceeded):
+			return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+				"error": "Upload quota exceeded",
+			})
+		}
+		log.Error("Error accepting drop link upload. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error accepting upload",
+		})
+	}
+	auditTarget(c, link.BucketID, key)
+	ctx := context.Background()
+	s3Client, err := srv.CreateS3ClientFromBucket(ctx, bucket)
+	if err == nil {
+		contentType := "application/octet-stream"
+		if detectedType := mime.TypeByExtension(filepath.Ext(key)); detectedType != "" {
+			contentType = detectedType
+		}
+		stream := c.Context().RequestBodyStream()
+		if stream == nil {
+			stream = bytes.NewReader(c.Body())
+		}
+		_, err = manager.NewUploader(s3Client).Upload(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(bucket.BucketName),
+			Key:         aws.String(key),
+			Body:        io.LimitReader(stream, size),
+			ContentType: aws.String(contentType),
+		})
+	}
+	if err != nil {
+		log.Errorf("Error uploading drop link file to S3: %s", err)
+		if releaseErr := h.dropLinks.Release(link, size); releaseErr != nil {
+			log.Error("Error releasing drop link quota. Err=%s\n", releaseErr)
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to upload file",
+		})
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"name": strings.TrimPrefix(key, link.Prefix),
+		"size": size,
+	})
+}
@@ -0,0 +1,170 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/blablatdinov/web-s3/src/repo"
+	"github.com/blablatdinov/web-s3/src/srv"
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+type FileArchiveHandler struct {
+	bucketsRepo repo.BucketsRepo
+	authz       srv.BucketAuthz
+	maxSize     int64
+}
+
+func FileArchiveHandlerCtor(bucketsRepo repo.BucketsRepo, authz srv.BucketAuthz, maxSize int64) Handler {
+	return FileArchiveHandler{
+		bucketsRepo: bucketsRepo,
+		authz:       authz,
+		maxSize:     maxSize,
+	}
+}
+
+func (h FileArchiveHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	body := struct {
+		BucketID int      `json:"bucket_id"`
+		Prefix   string   `json:"prefix"`
+		Keys     []string `json:"keys"`
+		Format   string   `json:"format"`
+	}{}
+	err := c.BodyParser(&body)
+	if err != nil {
+		log.Error("Error parsing body. Err=%s\n", err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if body.Format == "" {
+		body.Format = "zip"
+	}
+	if body.Format != "zip" && body.Format != "tar.gz" {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "format must be zip or tar.gz",
+		})
+	}
+	prefix := strings.TrimPrefix(body.Prefix, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	if (prefix == "") == (len(body.Keys) == 0) {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Either prefix or keys is required",
+		})
+	}
+
+	bucket, err := scopedBuckets(c, h.bucketsRepo).GetByID(userID, body.BucketID)
+	if err != nil {
+		if errors.Is(err, repo.ErrBucketNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Bucket not found",
+			})
+		}
+		log.Error("Error getting bucket. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error getting bucket",
+		})
+	}
+	keys := make([]string, 0, len(body.Keys))
+	for _, key := range body.Keys {
+		keys = append(keys, strings.TrimPrefix(key, "/"))
+	}
+	if prefix != "" {
+		keys = []string{prefix}
+	}
+	if ok, err := authorizeBucket(c, h.authz, userID, bucket, srv.BucketActionRead, keys...); !ok {
+		return err
+	}
+
+	ctx := context.Background()
+	s3Client, err := srv.CreateS3ClientFromBucket(ctx, bucket)
+	if err != nil {
+		log.Error("Error creating S3 client. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error creating S3 client",
+		})
+	}
+
+	var entries []srv.ArchiveEntry
+	archiveName := bucket.BucketName
+	if prefix != "" {
+		entries, err = srv.ArchiveEntriesForPrefix(ctx, s3Client, bucket.BucketName, prefix)
+		archiveName = path.Base(strings.TrimSuffix(prefix, "/"))
+	} else {
+		entries, err = srv.ArchiveEntriesForKeys(ctx, s3Client, bucket.BucketName, keys)
+	}
+	if err != nil {
+		log.Errorf("Failed to list objects: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list objects",
+		})
+	}
+	if len(entries) == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "No files to archive",
+		})
+	}
+	if size := srv.ArchiveSize(entries); size > h.maxSize {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error": fmt.Sprintf("Archive size %d exceeds limit of %d bytes", size, h.maxSize),
+		})
+	}
+
+	write := srv.WriteZipArchive
+	contentType := "application/zip"
+	if body.Format == "tar.gz" {
+		write = srv.WriteTarGzArchive
+		contentType = "application/gzip"
+	}
+	c.Set("Content-Type", contentType)
+	c.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, archiveName, body.Format))
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		err := write(ctx, w, s3Client, bucket.BucketName, entries)
+		if err != nil {
+			log.Errorf("Error streaming archive: %s", err)
+			return
+		}
+		err = w.Flush()
+		if err != nil {
+			log.Errorf("Error flushing archive: %s", err)
+		}
+	})
+	return nil
+}
@@ -0,0 +1,285 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/blablatdinov/web-s3/src/repo"
+	"github.com/blablatdinov/web-s3/src/srv"
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+// FileCopyHandler copies (or moves, when move is set) an object or a whole
+// prefix. Recursive operations stream their progress as JSON lines.
+type FileCopyHandler struct {
+	bucketsRepo repo.BucketsRepo
+	authz       srv.BucketAuthz
+	move        bool
+}
+
+func FileCopyHandlerCtor(bucketsRepo repo.BucketsRepo, authz srv.BucketAuthz) Handler {
+	return FileCopyHandler{bucketsRepo: bucketsRepo, authz: authz, move: false}
+}
+
+func FileMoveHandlerCtor(bucketsRepo repo.BucketsRepo, authz srv.BucketAuthz) Handler {
+	return FileCopyHandler{bucketsRepo: bucketsRepo, authz: authz, move: true}
+}
+
+type copyProgress struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+	Done        int    `json:"done"`
+	Total       int    `json:"total"`
+}
+
+type copySummary struct {
+	Finished bool `json:"finished"`
+	Copied   int  `json:"copied"`
+	Failed   int  `json:"failed"`
+	Total    int  `json:"total"`
+}
+
+func (h FileCopyHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	body := struct {
+		SourceBucketID      int    `json:"source_bucket_id"`
+		SourcePath          string `json:"source_path"`
+		DestinationBucketID int    `json:"destination_bucket_id"`
+		DestinationPath     string `json:"destination_path"`
+		Recursive           bool   `json:"recursive"`
+	}{}
+	err := c.BodyParser(&body)
+	if err != nil {
+		log.Error("Error parsing body. Err=%s\n", err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if body.DestinationBucketID == 0 {
+		body.DestinationBucketID = body.SourceBucketID
+	}
+	srcPath := strings.TrimPrefix(body.SourcePath, "/")
+	dstPath := strings.TrimPrefix(body.DestinationPath, "/")
+	if srcPath == "" {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "source_path is required",
+		})
+	}
+	if body.Recursive {
+		if !strings.HasSuffix(srcPath, "/") {
+			srcPath += "/"
+		}
+		if dstPath != "" && !strings.HasSuffix(dstPath, "/") {
+			dstPath += "/"
+		}
+	} else if dstPath == "" || strings.HasSuffix(dstPath, "/") {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "destination_path is required",
+		})
+	}
+	srcBucket, err := scopedBuckets(c, h.bucketsRepo).GetByID(userID, body.SourceBucketID)
+	if err != nil {
+		if errors.Is(err, repo.ErrBucketNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Source bucket not found",
+			})
+		}
+		log.Error("Error getting bucket. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error getting bucket",
+		})
+	}
+	dstBucket, err := scopedBuckets(c, h.bucketsRepo).GetByID(userID, body.DestinationBucketID)
+	if err != nil {
+		if errors.Is(err, repo.ErrBucketNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Destination bucket not found",
+			})
+		}
+		log.Error("Error getting bucket. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error getting bucket",
+		})
+	}
+	// Two registrations may point to the same physical bucket, a move between
+	// them onto the same key would delete the object it has just copied.
+	if srv.SamePhysicalBucket(srcBucket, dstBucket) {
+		if srcPath == dstPath {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error": "Source and destination are the same",
+			})
+		}
+		if body.Recursive && strings.HasPrefix(dstPath, srcPath) {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error": "Destination is inside the source directory",
+			})
+		}
+	}
+	srcAction := srv.BucketActionRead
+	if h.move {
+		srcAction = srv.BucketActionDelete
+	}
+	if ok, err := authorizeBucket(c, h.authz, userID, srcBucket, srcAction, srcPath); !ok {
+		return err
+	}
+	if ok, err := authorizeBucket(c, h.authz, userID, dstBucket, srv.BucketActionWrite, dstPath); !ok {
+		return err
+	}
+	dstPermissions, err := h.authz.Permissions(userID, dstBucket)
+	if err != nil {
+		return bucketAuthzError(c, err)
+	}
+
+	ctx := context.Background()
+	srcClient, err := srv.CreateS3ClientFromBucket(ctx, srcBucket)
+	if err != nil {
+		log.Error("Error creating S3 client. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error creating S3 client",
+		})
+	}
+	dstClient, err := srv.CreateS3ClientFromBucket(ctx, dstBucket)
+	if err != nil {
+		log.Error("Error creating S3 client. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error creating S3 client",
+		})
+	}
+	copier := srv.S3ObjectCopierCtor(srcClient, srcBucket, dstClient, dstBucket)
+	// Roles that can not overwrite, like uploaders, only copy to free keys.
+	guard := func(dstKey string) error {
+		if dstPermissions.Allows(srv.BucketActionOverwrite, dstKey) {
+			return nil
+		}
+		exists, err := srv.ObjectExists(ctx, dstClient, dstBucket.BucketName, dstKey)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("%w: %s", srv.ErrObjectExists, dstKey)
+		}
+		return nil
+	}
+
+	if !body.Recursive {
+		err = guard(dstPath)
+		if err == nil {
+			err = h.transfer(ctx, copier, srcClient, srcBucket.BucketName, srcPath, dstPath)
+		}
+		if errors.Is(err, srv.ErrObjectExists) {
+			return objectExistsError(c)
+		}
+		if err != nil {
+			log.Errorf("Error copying object: %s", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to copy file",
+			})
+		}
+		return c.JSON(fiber.Map{
+			"source":      srcPath,
+			"destination": dstPath,
+		})
+	}
+
+	keys, err := srv.ListKeys(ctx, srcClient, srcBucket.BucketName, srcPath)
+	if err != nil {
+		log.Errorf("Failed to list objects: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list objects",
+		})
+	}
+	c.Set("Content-Type", "application/x-ndjson")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		encoder := json.NewEncoder(w)
+		summary := copySummary{Finished: true, Total: len(keys)}
+		for i, key := range keys {
+			progress := copyProgress{
+				Source:      key,
+				Destination: dstPath + strings.TrimPrefix(key, srcPath),
+				Status:      "copied",
+				Done:        i + 1,
+				Total:       len(keys),
+			}
+			err := guard(progress.Destination)
+			if err == nil {
+				err = h.transfer(ctx, copier, srcClient, srcBucket.BucketName, progress.Source, progress.Destination)
+			}
+			if err != nil {
+				log.Errorf("Error copying object: %s", err)
+				progress.Status = "failed"
+				progress.Error = "Failed to copy file"
+				if errors.Is(err, srv.ErrObjectExists) {
+					progress.Error = "File already exists, your role can not overwrite it"
+				}
+				summary.Failed++
+			} else {
+				summary.Copied++
+			}
+			if encoder.Encode(progress) != nil || w.Flush() != nil {
+				return
+			}
+		}
+		if encoder.Encode(summary) == nil {
+			_ = w.Flush()
+		}
+	})
+	return nil
+}
+
+func (h FileCopyHandler) transfer(
+	ctx context.Context,
+	copier srv.ObjectCopier,
+	srcClient *s3.Client,
+	srcBucketName, srcKey, dstKey string,
+) error {
+	err := copier.Copy(ctx, srcKey, dstKey)
+	if err != nil {
+		return err
+	}
+	if !h.move {
+		return nil
+	}
+	_, err = srcClient.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(srcBucketName),
+		Key:    aws.String(srcKey),
+	})
+	return err
+}
@@ -0,0 +1,152 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/blablatdinov/web-s3/src/repo"
+	"github.com/blablatdinov/web-s3/src/srv"
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+type FileDeleteHandler struct {
+	bucketsRepo repo.BucketsRepo
+	authz       srv.BucketAuthz
+}
+
+func FileDeleteHandlerCtor(bucketsRepo repo.BucketsRepo, authz srv.BucketAuthz) Handler {
+	return FileDeleteHandler{
+		bucketsRepo: bucketsRepo,
+		authz:       authz,
+	}
+}
+
+func (h FileDeleteHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+
+	filePath := c.Params("path")
+	if filePath == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "File path is required",
+		})
+	}
+	filePath = strings.TrimPrefix(filePath, "/")
+
+	queries := c.Queries()
+	bucketIDStr, exist := queries["bucket_id"]
+	if !exist {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "bucket_id is required",
+		})
+	}
+
+	bucketID, err := strconv.Atoi(bucketIDStr)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid bucket_id",
+		})
+	}
+
+	recursive := c.QueryBool("recursive", false)
+	if !recursive && strings.HasSuffix(filePath, "/") {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Use recursive=true to delete a directory",
+		})
+	}
+
+	bucket, err := scopedBuckets(c, h.bucketsRepo).GetByID(userID, bucketID)
+	if err != nil {
+		if errors.Is(err, repo.ErrBucketNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Bucket not found",
+			})
+		}
+		log.Error("Error getting bucket. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error getting bucket",
+		})
+	}
+	if ok, err := authorizeBucket(c, h.authz, userID, bucket, srv.BucketActionDelete, filePath); !ok {
+		return err
+	}
+
+	ctx := context.Background()
+	s3Client, err := srv.CreateS3ClientFromBucket(ctx, bucket)
+	if err != nil {
+		log.Error("Error creating S3 client. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error creating S3 client",
+		})
+	}
+
+	if !recursive {
+		_, err = s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(bucket.BucketName),
+			Key:    aws.String(filePath),
+		})
+		if err != nil {
+			log.Errorf("Error deleting object from S3: %s", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to delete file",
+			})
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+
+	prefix := filePath
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	deleted, failures, err := srv.DeletePrefix(ctx, s3Client, bucket.BucketName, prefix)
+	if err != nil {
+		log.Errorf("Error deleting prefix from S3: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to delete directory",
+			"deleted": deleted,
+			"errors":  failures,
+		})
+	}
+	status := fiber.StatusOK
+	if len(failures) > 0 {
+		status = fiber.StatusMultiStatus
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"prefix":  prefix,
+		"deleted": deleted,
+		"errors":  failures,
+	})
+}
@@ -6,11 +6,13 @@ import (
 	"fmt"
 	"io"
 	"mime"
+	"net/http"
 	"path/filepath"
 	"strconv"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/blablatdinov/web-s3/src/repo"
 	"github.com/blablatdinov/web-s3/src/srv"
@@ -20,11 +22,13 @@ import (
 
 type FileDownloadHandler struct {
 	bucketsRepo repo.BucketsRepo
+	authz       srv.BucketAuthz
 }
 
-func FileDownloadHandlerCtor(bucketsRepo repo.BucketsRepo) Handler {
+func FileDownloadHandlerCtor(bucketsRepo repo.BucketsRepo, authz srv.BucketAuthz) Handler {
 	return FileDownloadHandler{
 		bucketsRepo: bucketsRepo,
+		authz:       authz,
 	}
 }
 
@@ -59,7 +63,7 @@ func (h FileDownloadHandler) Handle(c *fiber.Ctx) error {
 		})
 	}
 
-	bucket, err := h.bucketsRepo.GetByID(userID, bucketID)
+	bucket, err := scopedBuckets(c, h.bucketsRepo).GetByID(userID, bucketID)
 	if err != nil {
 		if errors.Is(err, repo.ErrBucketNotFound) {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
@@ -71,6 +75,9 @@ func (h FileDownloadHandler) Handle(c *fiber.Ctx) error {
 			"error": "Error getting bucket",
 		})
 	}
+	if ok, err := authorizeBucket(c, h.authz, userID, bucket, srv.BucketActionRead, filePath); !ok {
+		return err
+	}
 
 	ctx := context.Background()
 	s3Client, err := srv.CreateS3ClientFromBucket(ctx, bucket)
@@ -81,11 +88,59 @@ func (h FileDownloadHandler) Handle(c *fiber.Ctx) error {
 		})
 	}
 
-	result, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(bucket.BucketName),
+	return sendS3Object(c, ctx, s3Client, bucket.BucketName, filePath, nil)
+}
+
+// sendS3Object streams the object as an attachment, honouring range and
+// conditional request headers.
+// sendS3Object streams the object honouring range and conditional headers.
+// beforeBody, when set, runs once S3 has answered with content and may
+// refuse the response by writing its own.
+func sendS3Object(
+	c *fiber.Ctx,
+	ctx context.Context,
+	s3Client *s3.Client,
+	bucketName, filePath string,
+	beforeBody func(result *s3.GetObjectOutput) (bool, error),
+) error {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
 		Key:    aws.String(filePath),
-	})
+	}
+	if rangeHeader := c.Get(fiber.HeaderRange); rangeHeader != "" {
+		input.Range = aws.String(rangeHeader)
+	}
+	if ifNoneMatch := c.Get(fiber.HeaderIfNoneMatch); ifNoneMatch != "" {
+		input.IfNoneMatch = aws.String(ifNoneMatch)
+	}
+	if ifMatch := c.Get(fiber.HeaderIfMatch); ifMatch != "" {
+		input.IfMatch = aws.String(ifMatch)
+	}
+	if ifModifiedSince, err := http.ParseTime(c.Get(fiber.HeaderIfModifiedSince)); err == nil {
+		input.IfModifiedSince = aws.Time(ifModifiedSince)
+	}
+	if ifUnmodifiedSince, err := http.ParseTime(c.Get(fiber.HeaderIfUnmodifiedSince)); err == nil {
+		input.IfUnmodifiedSince = aws.Time(ifUnmodifiedSince)
+	}
+	result, err := s3Client.GetObject(ctx, input)
 	if err != nil {
+		var respErr *awshttp.ResponseError
+		if errors.As(err, &respErr) {
+			switch respErr.HTTPStatusCode() {
+			case fiber.StatusNotModified:
+				c.Set(fiber.HeaderETag, respErr.Response.Header.Get(fiber.HeaderETag))
+				c.Set(fiber.HeaderLastModified, respErr.Response.Header.Get(fiber.HeaderLastModified))
+				return c.SendStatus(fiber.StatusNotModified)
+			case fiber.StatusPreconditionFailed:
+				return c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{
+					"error": "Precondition failed",
+				})
+			case fiber.StatusRequestedRangeNotSatisfiable:
+				return c.Status(fiber.StatusRequestedRangeNotSatisfiable).JSON(fiber.Map{
+					"error": "Requested range not satisfiable",
+				})
+			}
+		}
 		log.Errorf("Error getting object from S3: %s", err)
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": "File not found",
@@ -96,6 +151,11 @@ func (h FileDownloadHandler) Handle(c *fiber.Ctx) error {
 			log.Errorf("Error closing S3 object body: %s", closeErr)
 		}
 	}()
+	if beforeBody != nil {
+		if ok, err := beforeBody(result); !ok {
+			return err
+		}
+	}
 	fileName := filepath.Base(filePath)
 	if fileName == "." || fileName == "/" {
 		fileName = "file"
@@ -111,9 +171,20 @@ func (h FileDownloadHandler) Handle(c *fiber.Ctx) error {
 	}
 	c.Set("Content-Type", contentType)
 	c.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName))
+	c.Set(fiber.HeaderAcceptRanges, "bytes")
+	if result.ETag != nil {
+		c.Set(fiber.HeaderETag, *result.ETag)
+	}
+	if result.LastModified != nil {
+		c.Set(fiber.HeaderLastModified, result.LastModified.UTC().Format(http.TimeFormat))
+	}
 	if result.ContentLength != nil {
 		c.Set("Content-Length", fmt.Sprintf("%d", *result.ContentLength))
 	}
+	if result.ContentRange != nil {
+		c.Set(fiber.HeaderContentRange, *result.ContentRange)
+		c.Status(fiber.StatusPartialContent)
+	}
 	_, err = io.Copy(c.Response().BodyWriter(), result.Body)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -0,0 +1,206 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/blablatdinov/web-s3/src/repo"
+	"github.com/blablatdinov/web-s3/src/srv"
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+const (
+	defaultPresignExpiry = 15 * time.Minute
+	maxPresignExpiry     = 7 * 24 * time.Hour
+)
+
+type FilePresignHandler struct {
+	bucketsRepo repo.BucketsRepo
+	authz       srv.BucketAuthz
+}
+
+func FilePresignHandlerCtor(bucketsRepo repo.BucketsRepo, authz srv.BucketAuthz) Handler {
+	return FilePresignHandler{
+		bucketsRepo: bucketsRepo,
+		authz:       authz,
+	}
+}
+
+func (h FilePresignHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+
+	filePath := c.Params("path")
+	if filePath == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "File path is required",
+		})
+	}
+	filePath = strings.TrimPrefix(filePath, "/")
+
+	queries := c.Queries()
+	bucketIDStr, exist := queries["bucket_id"]
+	if !exist {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "bucket_id is required",
+		})
+	}
+
+	bucketID, err := strconv.Atoi(bucketIDStr)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid bucket_id",
+		})
+	}
+
+	method := strings.ToUpper(c.Query("method", fiber.MethodGet))
+	if method != fiber.MethodGet && method != fiber.MethodPut {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "method must be GET or PUT",
+		})
+	}
+	if apiToken, ok := GetApiToken(c); ok && method == fiber.MethodPut && apiToken.Scope == repo.ApiTokenScopeRead {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Token is read-only",
+		})
+	}
+
+	expiry := defaultPresignExpiry
+	if expiresIn, exist := queries["expires_in"]; exist {
+		seconds, err := strconv.Atoi(expiresIn)
+		if err != nil || seconds <= 0 || time.Duration(seconds)*time.Second > maxPresignExpiry {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("expires_in must be between 1 and %d seconds", int(maxPresignExpiry.Seconds())),
+			})
+		}
+		expiry = time.Duration(seconds) * time.Second
+	}
+
+	bucket, err := scopedBuckets(c, h.bucketsRepo).GetByID(userID, bucketID)
+	if err != nil {
+		if errors.Is(err, repo.ErrBucketNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Bucket not found",
+			})
+		}
+		log.Error("Error getting bucket. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error getting bucket",
+		})
+	}
+	action := srv.BucketActionRead
+	if method == fiber.MethodPut {
+		action = srv.BucketActionWrite
+	}
+	if ok, err := authorizeBucket(c, h.authz, userID, bucket, action, filePath); !ok {
+		return err
+	}
+
+	ctx := context.Background()
+	s3Client, err := srv.CreateS3ClientFromBucket(ctx, bucket)
+	if err != nil {
+		log.Error("Error creating S3 client. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error creating S3 client",
+		})
+	}
+	presignClient := s3.NewPresignClient(s3Client, s3.WithPresignExpires(expiry))
+
+	contentType := queries["content_type"]
+	contentDisposition := queries["content_disposition"]
+	expiresAt := time.Now().Add(expiry)
+	if method == fiber.MethodGet {
+		if contentDisposition == "" {
+			fileName := filepath.Base(filePath)
+			contentDisposition = fmt.Sprintf(`attachment; filename="%s"`, fileName)
+		}
+		input := &s3.GetObjectInput{
+			Bucket:                     aws.String(bucket.BucketName),
+			Key:                        aws.String(filePath),
+			ResponseContentDisposition: aws.String(contentDisposition),
+		}
+		if contentType != "" {
+			input.ResponseContentType = aws.String(contentType)
+		}
+		request, err := presignClient.PresignGetObject(ctx, input)
+		if err != nil {
+			log.Errorf("Error presigning GetObject: %s", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to presign URL",
+			})
+		}
+		return c.JSON(fiber.Map{
+			"url":        request.URL,
+			"method":     request.Method,
+			"headers":    request.SignedHeader,
+			"expires_at": expiresAt,
+		})
+	}
+
+	// Roles that can not overwrite get a URL signed with If-None-Match, S3
+	// refuses it once the key holds an object.
+	ifNoneMatch, ok, err := guardOverwrite(c, ctx, h.authz, userID, bucket, s3Client, filePath)
+	if !ok {
+		return err
+	}
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(bucket.BucketName),
+		Key:         aws.String(filePath),
+		IfNoneMatch: ifNoneMatch,
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	if contentDisposition != "" {
+		input.ContentDisposition = aws.String(contentDisposition)
+	}
+	request, err := presignClient.PresignPutObject(ctx, input)
+	if err != nil {
+		log.Errorf("Error presigning PutObject: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to presign URL",
+		})
+	}
+	return c.JSON(fiber.Map{
+		"url":        request.URL,
+		"method":     request.Method,
+		"headers":    request.SignedHeader,
+		"expires_at": expiresAt,
+	})
+}
@@ -0,0 +1,162 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/blablatdinov/web-s3/src/repo"
+	"github.com/blablatdinov/web-s3/src/srv"
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+type FileUploadHandler struct {
+	bucketsRepo repo.BucketsRepo
+	authz       srv.BucketAuthz
+}
+
+func FileUploadHandlerCtor(bucketsRepo repo.BucketsRepo, authz srv.BucketAuthz) Handler {
+	return FileUploadHandler{
+		bucketsRepo: bucketsRepo,
+		authz:       authz,
+	}
+}
+
+type countingReader struct {
+	reader io.Reader
+	size   int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (h FileUploadHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+
+	queries := c.Queries()
+	bucketIDStr, exist := queries["bucket_id"]
+	if !exist {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "bucket_id is required",
+		})
+	}
+
+	bucketID, err := strconv.Atoi(bucketIDStr)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid bucket_id",
+		})
+	}
+
+	filePath := strings.TrimPrefix(queries["path"], "/")
+	if filePath == "" || strings.HasSuffix(filePath, "/") {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "File path is required",
+		})
+	}
+
+	bucket, err := scopedBuckets(c, h.bucketsRepo).GetByID(userID, bucketID)
+	if err != nil {
+		if errors.Is(err, repo.ErrBucketNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Bucket not found",
+			})
+		}
+		log.Error("Error getting bucket. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error getting bucket",
+		})
+	}
+	if ok, err := authorizeBucket(c, h.authz, userID, bucket, srv.BucketActionWrite, filePath); !ok {
+		return err
+	}
+
+	ctx := context.Background()
+	s3Client, err := srv.CreateS3ClientFromBucket(ctx, bucket)
+	if err != nil {
+		log.Error("Error creating S3 client. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error creating S3 client",
+		})
+	}
+
+	ifNoneMatch, ok, err := guardOverwrite(c, ctx, h.authz, userID, bucket, s3Client, filePath)
+	if !ok {
+		return err
+	}
+
+	contentType := c.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+		if detectedType := mime.TypeByExtension(filepath.Ext(filePath)); detectedType != "" {
+			contentType = detectedType
+		}
+	}
+	stream := c.Context().RequestBodyStream()
+	if stream == nil {
+		stream = bytes.NewReader(c.Body())
+	}
+	body := &countingReader{reader: stream}
+	result, err := manager.NewUploader(s3Client).Upload(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket.BucketName),
+		Key:         aws.String(filePath),
+		Body:        body,
+		ContentType: aws.String(contentType),
+		IfNoneMatch: ifNoneMatch,
+	})
+	if err != nil {
+		if srv.IsPreconditionFailed(err) {
+			return objectExistsError(c)
+		}
+		log.Errorf("Error uploading object to S3: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to upload file",
+		})
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"key":  filePath,
+		"size": body.size,
+		"etag": aws.ToString(result.ETag),
+	})
+}
@@ -38,13 +38,16 @@ import (
 	"github.com/jmoiron/sqlx"
 )
 
+const maxListPageSize = 1000
+
 type FilesHandler struct {
 	pgsql       *sqlx.DB
 	bucketsRepo repo.BucketsRepo
+	authz       srv.BucketAuthz
 }
 
-func FilesCtor(pgsql *sqlx.DB, bucketsRepo repo.BucketsRepo) Handler {
-	return FilesHandler{pgsql: pgsql, bucketsRepo: bucketsRepo}
+func FilesCtor(pgsql *sqlx.DB, bucketsRepo repo.BucketsRepo, authz srv.BucketAuthz) Handler {
+	return FilesHandler{pgsql: pgsql, bucketsRepo: bucketsRepo, authz: authz}
 }
 
 func (h FilesHandler) Handle(c *fiber.Ctx) error {
@@ -67,7 +70,7 @@ func (h FilesHandler) Handle(c *fiber.Ctx) error {
 			"error": "Invalid bucket_id",
 		})
 	}
-	bucket, err := h.bucketsRepo.GetByID(userID, bucketID)
+	bucket, err := scopedBuckets(c, h.bucketsRepo).GetByID(userID, bucketID)
 	if err != nil {
 		if errors.Is(err, repo.ErrBucketNotFound) {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
@@ -79,6 +82,18 @@ func (h FilesHandler) Handle(c *fiber.Ctx) error {
 			"error": "Error getting bucket",
 		})
 	}
+	path, exist := queries["path"]
+	if !exist {
+		path = ""
+	}
+	auditTarget(c, bucketID, path)
+	permissions, err := h.authz.Permissions(userID, bucket)
+	if err != nil {
+		return bucketAuthzError(c, err)
+	}
+	if !permissions.Browsable(path) {
+		return bucketAuthzError(c, srv.ErrBucketForbidden)
+	}
 	ctx := context.Background()
 	s3Client, err := srv.CreateS3ClientFromBucket(ctx, bucket)
 	if err != nil {
@@ -87,17 +102,23 @@ func (h FilesHandler) Handle(c *fiber.Ctx) error {
 			"error": "Error creating S3 client",
 		})
 	}
-	path, exist := queries["path"]
-	if !exist {
-		path = ""
+	pageSize := c.QueryInt("page_size", maxListPageSize)
+	if pageSize < 1 || pageSize > maxListPageSize {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid page_size",
+		})
 	}
-	var files []string
-	var dirs []string
-	resp, err := s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+	details := c.QueryBool("details", false)
+	input := &s3.ListObjectsV2Input{
 		Bucket:    aws.String(bucket.BucketName),
 		Prefix:    aws.String(path),
 		Delimiter: aws.String("/"),
-	})
+		MaxKeys:   aws.Int32(int32(pageSize)),
+	}
+	if pageToken := queries["page_token"]; pageToken != "" {
+		input.ContinuationToken = aws.String(pageToken)
+	}
+	resp, err := s3Client.ListObjectsV2(ctx, input)
 	if err != nil {
 		log.Errorf("Failed to list objects: %s", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -105,14 +126,34 @@ func (h FilesHandler) Handle(c *fiber.Ctx) error {
 		})
 	}
 
+	var files []any
+	var dirs []string
 	for _, item := range resp.Contents {
-		files = append(files, *item.Key)
+		if !permissions.Allows(srv.BucketActionList, *item.Key) {
+			continue
+		}
+		if !details {
+			files = append(files, *item.Key)
+			continue
+		}
+		files = append(files, fiber.Map{
+			"key":           *item.Key,
+			"size":          aws.ToInt64(item.Size),
+			"last_modified": item.LastModified,
+			"etag":          aws.ToString(item.ETag),
+			"storage_class": item.StorageClass,
+		})
 	}
 	for _, item := range resp.CommonPrefixes {
+		if !permissions.Browsable(*item.Prefix) {
+			continue
+		}
 		dirs = append(dirs, *item.Prefix)
 	}
 	return c.JSON(fiber.Map{
-		"files":       files,
-		"directories": dirs,
+		"files":           files,
+		"directories":     dirs,
+		"is_truncated":    aws.ToBool(resp.IsTruncated),
+		"next_page_token": aws.ToString(resp.NextContinuationToken),
 	})
 }
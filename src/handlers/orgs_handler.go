@@ -0,0 +1,433 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package handlers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/blablatdinov/web-s3/src/repo"
+	"github.com/blablatdinov/web-s3/src/srv"
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+// handleOrgError maps organization errors to responses, action names the
+// operation in the log and in the 500 response.
+func handleOrgError(c *fiber.Ctx, err error, action string) error {
+	switch {
+	case errors.Is(err, repo.ErrOrgNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Organization not found",
+		})
+	case errors.Is(err, repo.ErrOrgInviteNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Invite not found",
+		})
+	case errors.Is(err, repo.ErrUserNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	case errors.Is(err, srv.ErrOrgForbidden):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Organization owner role required",
+		})
+	case errors.Is(err, repo.ErrOrgMemberExists):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "User is already a member",
+		})
+	case errors.Is(err, srv.ErrLastOrgOwner), errors.Is(err, srv.ErrOrgSelfDemotion):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Organization must keep at least one owner",
+		})
+	case errors.Is(err, srv.ErrInvalidOrgName):
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "name is required and must be at most 128 characters",
+		})
+	case errors.Is(err, srv.ErrInvalidOrgRole):
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "role must be owner or member",
+		})
+	}
+	log.Error("Error %s. Err=%s\n", action, err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Error " + action,
+	})
+}
+
+func orgInviteJSON(invite repo.OrgInvite) fiber.Map {
+	return fiber.Map{
+		"org_invite_id": invite.OrgInviteID,
+		"org_id":        invite.OrgID,
+		"org_name":      invite.OrgName,
+		"username":      invite.Username,
+		"invited_by":    invite.InvitedBy,
+		"created_at":    invite.CreatedAt,
+	}
+}
+
+func orgJSON(org repo.Org) fiber.Map {
+	return fiber.Map{
+		"org_id":     org.OrgID,
+		"name":       org.Name,
+		"role":       org.Role,
+		"created_at": org.CreatedAt,
+	}
+}
+
+func orgMemberJSON(member repo.OrgMember) fiber.Map {
+	return fiber.Map{
+		"user_id":    member.UserID,
+		"username":   member.Username,
+		"role":       member.Role,
+		"created_at": member.CreatedAt,
+	}
+}
+
+type OrgsListHandler struct {
+	orgs srv.Orgs
+}
+
+func OrgsListHandlerCtor(orgs srv.Orgs) Handler {
+	return OrgsListHandler{orgs}
+}
+
+func (h OrgsListHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	orgs, err := h.orgs.List(userID)
+	if err != nil {
+		return handleOrgError(c, err, "listing organizations")
+	}
+	result := make([]fiber.Map, 0, len(orgs))
+	for _, org := range orgs {
+		result = append(result, orgJSON(org))
+	}
+	return c.JSON(fiber.Map{
+		"orgs": result,
+	})
+}
+
+type OrgCreateHandler struct {
+	orgs srv.Orgs
+}
+
+func OrgCreateHandlerCtor(orgs srv.Orgs) Handler {
+	return OrgCreateHandler{orgs}
+}
+
+func (h OrgCreateHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	body := struct {
+		Name string `json:"name"`
+	}{}
+	err := c.BodyParser(&body)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	org, err := h.orgs.Create(userID, body.Name)
+	if err != nil {
+		return handleOrgError(c, err, "creating organization")
+	}
+	return c.Status(fiber.StatusCreated).JSON(orgJSON(org))
+}
+
+type OrgDeleteHandler struct {
+	orgs srv.Orgs
+}
+
+func OrgDeleteHandlerCtor(orgs srv.Orgs) Handler {
+	return OrgDeleteHandler{orgs}
+}
+
+func (h OrgDeleteHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	orgID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid organization id",
+		})
+	}
+	err = h.orgs.Delete(userID, orgID)
+	if err != nil {
+		return handleOrgError(c, err, "deleting organization")
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+type OrgMembersListHandler struct {
+	orgs srv.Orgs
+}
+
+func OrgMembersListHandlerCtor(orgs srv.Orgs) Handler {
+	return OrgMembersListHandler{orgs}
+}
+
+func (h OrgMembersListHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	orgID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid organization id",
+		})
+	}
+	members, err := h.orgs.Members(userID, orgID)
+	if err != nil {
+		return handleOrgError(c, err, "listing organization members")
+	}
+	result := make([]fiber.Map, 0, len(members))
+	for _, member := range members {
+		result = append(result, orgMemberJSON(member))
+	}
+	return c.JSON(fiber.Map{
+		"members": result,
+	})
+}
+
+type OrgMemberUpdateHandler struct {
+	orgs srv.Orgs
+}
+
+func OrgMemberUpdateHandlerCtor(orgs srv.Orgs) Handler {
+	return OrgMemberUpdateHandler{orgs}
+}
+
+func (h OrgMemberUpdateHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	orgID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid organization id",
+		})
+	}
+	memberID, err := strconv.Atoi(c.Params("user_id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user id",
+		})
+	}
+	body := struct {
+		Role string `json:"role"`
+	}{}
+	err = c.BodyParser(&body)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	err = h.orgs.SetRole(userID, orgID, memberID, body.Role)
+	if err != nil {
+		return handleOrgError(c, err, "updating organization member")
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+type OrgMemberDeleteHandler struct {
+	orgs srv.Orgs
+}
+
+func OrgMemberDeleteHandlerCtor(orgs srv.Orgs) Handler {
+	return OrgMemberDeleteHandler{orgs}
+}
+
+func (h OrgMemberDeleteHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	orgID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid organization id",
+		})
+	}
+	memberID, err := strconv.Atoi(c.Params("user_id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user id",
+		})
+	}
+	err = h.orgs.RemoveMember(userID, orgID, memberID)
+	if err != nil {
+		return handleOrgError(c, err, "removing organization member")
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+type OrgInviteCreateHandler struct {
+	orgs srv.Orgs
+}
+
+func OrgInviteCreateHandlerCtor(orgs srv.Orgs) Handler {
+	return OrgInviteCreateHandler{orgs}
+}
+
+func (h OrgInviteCreateHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	orgID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid organization id",
+		})
+	}
+	body := struct {
+		Username string `json:"username"`
+	}{}
+	err = c.BodyParser(&body)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if body.Username == "" {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "username is required",
+		})
+	}
+	invite, err := h.orgs.Invite(userID, orgID, body.Username)
+	if err != nil {
+		return handleOrgError(c, err, "inviting organization member")
+	}
+	return c.Status(fiber.StatusCreated).JSON(orgInviteJSON(invite))
+}
+
+type OrgInvitesListHandler struct {
+	orgs srv.Orgs
+}
+
+func OrgInvitesListHandlerCtor(orgs srv.Orgs) Handler {
+	return OrgInvitesListHandler{orgs}
+}
+
+func (h OrgInvitesListHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	invites, err := h.orgs.Invites(userID)
+	if err != nil {
+		return handleOrgError(c, err, "listing organization invites")
+	}
+	result := make([]fiber.Map, 0, len(invites))
+	for _, invite := range invites {
+		result = append(result, orgInviteJSON(invite))
+	}
+	return c.JSON(fiber.Map{
+		"invites": result,
+	})
+}
+
+type OrgInviteAcceptHandler struct {
+	orgs srv.Orgs
+}
+
+func OrgInviteAcceptHandlerCtor(orgs srv.Orgs) Handler {
+	return OrgInviteAcceptHandler{orgs}
+}
+
+func (h OrgInviteAcceptHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	orgInviteID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid invite id",
+		})
+	}
+	orgID, err := h.orgs.Accept(userID, orgInviteID)
+	if err != nil {
+		return handleOrgError(c, err, "accepting organization invite")
+	}
+	return c.JSON(fiber.Map{
+		"org_id": orgID,
+	})
+}
+
+type OrgInviteDeclineHandler struct {
+	orgs srv.Orgs
+}
+
+func OrgInviteDeclineHandlerCtor(orgs srv.Orgs) Handler {
+	return OrgInviteDeclineHandler{orgs}
+}
+
+func (h OrgInviteDeclineHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	orgInviteID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid invite id",
+		})
+	}
+	err = h.orgs.Decline(userID, orgInviteID)
+	if err != nil {
+		return handleOrgError(c, err, "declining organization invite")
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
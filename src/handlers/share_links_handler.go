@@ -0,0 +1,374 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/blablatdinov/web-s3/src/repo"
+	"github.com/blablatdinov/web-s3/src/srv"
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+func shareLinkJSON(link repo.ShareLink) fiber.Map {
+	return fiber.Map{
+		"share_link_id": link.ShareLinkID,
+		"bucket_id":     link.BucketID,
+		"bucket_name":   link.BucketName,
+		"key":           link.Key,
+		"prefix":        link.Prefix,
+		"has_password":  link.PasswordHash != nil,
+		"expires_at":    link.ExpiresAt,
+		"max_downloads": link.MaxDownloads,
+		"downloads":     link.Downloads,
+		"created_at":    link.CreatedAt,
+	}
+}
+
+type ShareLinksListHandler struct {
+	shareLinks srv.ShareLinks
+}
+
+func ShareLinksListHandlerCtor(shareLinks srv.ShareLinks) Handler {
+	return ShareLinksListHandler{shareLinks}
+}
+
+func (h ShareLinksListHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	links, err := h.shareLinks.List(userID)
+	if err != nil {
+		log.Error("Error listing share links. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error listing share links",
+		})
+	}
+	result := make([]fiber.Map, 0, len(links))
+	for _, link := range links {
+		if tokenAllowsBucket(c, link.BucketID) {
+			result = append(result, shareLinkJSON(link))
+		}
+	}
+	return c.JSON(fiber.Map{
+		"links": result,
+	})
+}
+
+type ShareLinkCreateHandler struct {
+	shareLinks  srv.ShareLinks
+	bucketsRepo repo.BucketsRepo
+}
+
+func ShareLinkCreateHandlerCtor(shareLinks srv.ShareLinks, bucketsRepo repo.BucketsRepo) Handler {
+	return ShareLinkCreateHandler{shareLinks, bucketsRepo}
+}
+
+func (h ShareLinkCreateHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	body := struct {
+		BucketID     int        `json:"bucket_id"`
+		Key          string     `json:"key"`
+		Password     string     `json:"password"`
+		ExpiresAt    *time.Time `json:"expires_at"`
+		MaxDownloads *int       `json:"max_downloads"`
+	}{}
+	err := c.BodyParser(&body)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if body.ExpiresAt != nil && body.ExpiresAt.Before(time.Now()) {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "expires_at must be in the future",
+		})
+	}
+	if body.MaxDownloads != nil && *body.MaxDownloads < 1 {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "max_downloads must be positive",
+		})
+	}
+	bucket, err := scopedBuckets(c, h.bucketsRepo).GetByID(userID, body.BucketID)
+	if err != nil {
+		if errors.Is(err, repo.ErrBucketNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Bucket not found",
+			})
+		}
+		log.Error("Error getting bucket. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error getting bucket",
+		})
+	}
+	link, rawToken, err := h.shareLinks.Create(userID, bucket, srv.NewShareLink{
+		Key:          body.Key,
+		Password:     body.Password,
+		ExpiresAt:    body.ExpiresAt,
+		MaxDownloads: body.MaxDownloads,
+	})
+	if err != nil {
+		if errors.Is(err, srv.ErrBucketForbidden) {
+			return bucketAuthzError(c, err)
+		}
+		log.Error("Error creating share link. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error creating share link",
+		})
+	}
+	result := shareLinkJSON(link)
+	result["token"] = rawToken
+	result["url"] = c.BaseURL() + strings.TrimSuffix(c.Path(), "/shares") + "/s/" + rawToken
+	return c.Status(fiber.StatusCreated).JSON(result)
+}
+
+type ShareLinkDeleteHandler struct {
+	shareLinks srv.ShareLinks
+}
+
+func ShareLinkDeleteHandlerCtor(shareLinks srv.ShareLinks) Handler {
+	return ShareLinkDeleteHandler{shareLinks}
+}
+
+func (h ShareLinkDeleteHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	shareLinkID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid share link id",
+		})
+	}
+	err = h.shareLinks.Revoke(userID, shareLinkID)
+	if err != nil {
+		if errors.Is(err, repo.ErrShareLinkNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Share link not found",
+			})
+		}
+		log.Error("Error revoking share link. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error revoking share link",
+		})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ShareLinkServeHandler is the public side of share links: it sends the
+// shared file, or for a folder link lists it and sends files picked with
+// the key query parameter. The password comes in the X-Share-Password
+// header or a password form field.
+type ShareLinkServeHandler struct {
+	shareLinks  srv.ShareLinks
+	bucketsRepo repo.BucketsRepo
+	authz       srv.BucketAuthz
+	limiter     srv.AuthLimiter
+}
+
+func ShareLinkServeHandlerCtor(
+	shareLinks srv.ShareLinks,
+	bucketsRepo repo.BucketsRepo,
+	authz srv.BucketAuthz,
+	limiter srv.AuthLimiter,
+) Handler {
+	return ShareLinkServeHandler{shareLinks, bucketsRepo, authz, limiter}
+}
+
+func (h ShareLinkServeHandler) Handle(c *fiber.Ctx) error {
+	rawToken := c.Params("token")
+	password := c.Get("X-Share-Password")
+	if password == "" {
+		password = c.FormValue("password")
+	}
+	// Password guesses are throttled per link like logins per username.
+	linkPrefix := rawToken[:min(len(rawToken), 8)]
+	if password != "" {
+		err := h.limiter.AllowSharePassword(linkPrefix, requestClient(c))
+		if err != nil {
+			if errors.Is(err, srv.ErrTooManyAttempts) {
+				return tooManyAttempts(c, err)
+			}
+			log.Error("Error checking share link attempts. Err=%s\n", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Internal server error",
+			})
+		}
+	}
+	link, err := h.shareLinks.Open(rawToken, password)
+	if err != nil {
+		switch {
+		case errors.Is(err, srv.ErrInvalidShareLink):
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Share link not found",
+			})
+		case errors.Is(err, srv.ErrSharePasswordRequired):
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Password required",
+			})
+		case errors.Is(err, srv.ErrInvalidSharePassword):
+			if failedErr := h.limiter.SharePasswordFailed(linkPrefix); failedErr != nil {
+				log.Error("Error counting share link attempt. Err=%s\n", failedErr)
+			}
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid password",
+			})
+		}
+		log.Error("Error opening share link. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error opening share link",
+		})
+	}
+	if password != "" {
+		if succeededErr := h.limiter.SharePasswordSucceeded(linkPrefix); succeededErr != nil {
+			log.Error("Error resetting share link attempts. Err=%s\n", succeededErr)
+		}
+	}
+	key := link.Key
+	folder := srv.IsShareFolder(link)
+	if folder && c.Query("key") != "" {
+		key += strings.TrimPrefix(c.Query("key"), "/")
+		folder = false
+	}
+	auditTarget(c, link.BucketID, key)
+	// The link works only while its creator can still read what it shares.
+	bucket, err := h.bucketsRepo.GetByID(link.UserID, link.BucketID)
+	if err == nil {
+		err = h.authz.Authorize(link.UserID, bucket, srv.BucketActionRead, key)
+	}
+	if err != nil {
+		if errors.Is(err, repo.ErrBucketNotFound) || errors.Is(err, srv.ErrBucketForbidden) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Share link not found",
+			})
+		}
+		log.Error("Error getting shared bucket. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error getting bucket",
+		})
+	}
+	ctx := context.Background()
+	s3Client, err := srv.CreateS3ClientFromBucket(ctx, bucket)
+	if err != nil {
+		log.Error("Error creating S3 client. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error creating S3 client",
+		})
+	}
+	if folder {
+		return h.list(c, ctx, s3Client, bucket.BucketName, link)
+	}
+	if srv.IsShareLinkExhausted(link) {
+		return downloadLimitReached(c)
+	}
+	return sendS3Object(c, ctx, s3Client, bucket.BucketName, key, func(result *s3.GetObjectOutput) (bool, error) {
+		if !srv.CountsAsShareDownload(result.ContentRange) {
+			return true, nil
+		}
+		err := h.shareLinks.Download(link)
+		if err != nil {
+			if errors.Is(err, repo.ErrShareLinkExhausted) {
+				return false, downloadLimitReached(c)
+			}
+			log.Error("Error counting share link download. Err=%s\n", err)
+			return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Error counting download",
+			})
+		}
+		return true, nil
+	})
+}
+
+func downloadLimitReached(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusGone).JSON(fiber.Map{
+		"error": "Download limit reached",
+	})
+}
+
+// list shows one level of a shared folder with keys relative to the link.
+func (h ShareLinkServeHandler) list(
+	c *fiber.Ctx,
+	ctx context.Context,
+	s3Client *s3.Client,
+	bucketName string,
+	link repo.ShareLink,
+) error {
+	path := strings.TrimPrefix(c.Query("path"), "/")
+	if path != "" && !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	input := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucketName),
+		Prefix:    aws.String(link.Key + path),
+		Delimiter: aws.String("/"),
+		MaxKeys:   aws.Int32(maxListPageSize),
+	}
+	if pageToken := c.Query("page_token"); pageToken != "" {
+		input.ContinuationToken = aws.String(pageToken)
+	}
+	resp, err := s3Client.ListObjectsV2(ctx, input)
+	if err != nil {
+		log.Errorf("Failed to list objects: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list objects",
+		})
+	}
+	files := make([]fiber.Map, 0, len(resp.Contents))
+	for _, item := range resp.Contents {
+		files = append(files, fiber.Map{
+			"key":           strings.TrimPrefix(*item.Key, link.Key),
+			"size":          aws.ToInt64(item.Size),
+			"last_modified": item.LastModified,
+		})
+	}
+	dirs := make([]string, 0, len(resp.CommonPrefixes))
+	for _, item := range resp.CommonPrefixes {
+		dirs = append(dirs, strings.TrimPrefix(*item.Prefix, link.Key))
+	}
+	return c.JSON(fiber.Map{
+		"files":           files,
+		"directories":     dirs,
+		"is_truncated":    aws.ToBool(resp.IsTruncated),
+		"next_page_token": aws.ToString(resp.NextContinuationToken),
+	})
+}
@@ -0,0 +1,471 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"mime"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/blablatdinov/web-s3/src/repo"
+	"github.com/blablatdinov/web-s3/src/srv"
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+const maxUploadPartNumber = 10000
+
+type uploadSessionTarget struct {
+	session  *repo.UploadSession
+	bucket   *repo.Bucket
+	s3Client *s3.Client
+}
+
+// loadUploadSession resolves the session from the ":id" route param together
+// with its bucket and S3 client. On failure the error response is already
+// written and a nil target is returned.
+func loadUploadSession(
+	c *fiber.Ctx,
+	ctx context.Context,
+	sessionsRepo repo.UploadSessionsRepo,
+	bucketsRepo repo.BucketsRepo,
+	authz srv.BucketAuthz,
+) (*uploadSessionTarget, error) {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return nil, c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	sessionID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid session id",
+		})
+	}
+	session, err := sessionsRepo.GetByID(userID, sessionID)
+	if err != nil {
+		if errors.Is(err, repo.ErrUploadSessionNotFound) {
+			return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Upload session not found",
+			})
+		}
+		log.Error("Error getting upload session. Err=%s\n", err)
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error getting upload session",
+		})
+	}
+	bucket, err := scopedBuckets(c, bucketsRepo).GetByID(userID, session.BucketID)
+	if err != nil {
+		if errors.Is(err, repo.ErrBucketNotFound) {
+			return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Bucket not found",
+			})
+		}
+		log.Error("Error getting bucket. Err=%s\n", err)
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error getting bucket",
+		})
+	}
+	if ok, err := authorizeBucket(c, authz, userID, bucket, srv.BucketActionWrite, session.ObjectKey); !ok {
+		return nil, err
+	}
+	s3Client, err := srv.CreateS3ClientFromBucket(ctx, bucket)
+	if err != nil {
+		log.Error("Error creating S3 client. Err=%s\n", err)
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error creating S3 client",
+		})
+	}
+	return &uploadSessionTarget{session: session, bucket: bucket, s3Client: s3Client}, nil
+}
+
+func listUploadedParts(ctx context.Context, target *uploadSessionTarget) ([]types.Part, error) {
+	var parts []types.Part
+	paginator := s3.NewListPartsPaginator(target.s3Client, &s3.ListPartsInput{
+		Bucket:   aws.String(target.bucket.BucketName),
+		Key:      aws.String(target.session.ObjectKey),
+		UploadId: aws.String(target.session.UploadID),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, page.Parts...)
+	}
+	return parts, nil
+}
+
+func uploadSessionJSON(session repo.UploadSession) fiber.Map {
+	return fiber.Map{
+		"session_id":   session.SessionID,
+		"bucket_id":    session.BucketID,
+		"key":          session.ObjectKey,
+		"content_type": session.ContentType,
+		"created_at":   session.CreatedAt,
+	}
+}
+
+type UploadSessionCreateHandler struct {
+	sessionsRepo repo.UploadSessionsRepo
+	bucketsRepo  repo.BucketsRepo
+	authz        srv.BucketAuthz
+}
+
+func UploadSessionCreateHandlerCtor(sessionsRepo repo.UploadSessionsRepo, bucketsRepo repo.BucketsRepo, authz srv.BucketAuthz) Handler {
+	return UploadSessionCreateHandler{sessionsRepo: sessionsRepo, bucketsRepo: bucketsRepo, authz: authz}
+}
+
+func (h UploadSessionCreateHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	body := struct {
+		BucketID    int    `json:"bucket_id"`
+		Path        string `json:"path"`
+		ContentType string `json:"content_type"`
+	}{}
+	err := c.BodyParser(&body)
+	if err != nil {
+		log.Error("Error parsing body. Err=%s\n", err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	filePath := strings.TrimPrefix(body.Path, "/")
+	if filePath == "" || strings.HasSuffix(filePath, "/") {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "path is required",
+		})
+	}
+	if body.ContentType == "" {
+		body.ContentType = "application/octet-stream"
+		if detectedType := mime.TypeByExtension(filepath.Ext(filePath)); detectedType != "" {
+			body.ContentType = detectedType
+		}
+	}
+	bucket, err := scopedBuckets(c, h.bucketsRepo).GetByID(userID, body.BucketID)
+	if err != nil {
+		if errors.Is(err, repo.ErrBucketNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Bucket not found",
+			})
+		}
+		log.Error("Error getting bucket. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error getting bucket",
+		})
+	}
+	if ok, err := authorizeBucket(c, h.authz, userID, bucket, srv.BucketActionWrite, filePath); !ok {
+		return err
+	}
+	ctx := context.Background()
+	s3Client, err := srv.CreateS3ClientFromBucket(ctx, bucket)
+	if err != nil {
+		log.Error("Error creating S3 client. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error creating S3 client",
+		})
+	}
+	if _, ok, err := guardOverwrite(c, ctx, h.authz, userID, bucket, s3Client, filePath); !ok {
+		return err
+	}
+	upload, err := s3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(bucket.BucketName),
+		Key:         aws.String(filePath),
+		ContentType: aws.String(body.ContentType),
+	})
+	if err != nil {
+		log.Errorf("Error creating multipart upload: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create multipart upload",
+		})
+	}
+	sessionID, err := h.sessionsRepo.Create(userID, bucket.BucketID, filePath, aws.ToString(upload.UploadId), body.ContentType)
+	if err != nil {
+		log.Error("Error creating upload session. Err=%s\n", err)
+		_, abortErr := s3Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(bucket.BucketName),
+			Key:      aws.String(filePath),
+			UploadId: upload.UploadId,
+		})
+		if abortErr != nil {
+			log.Errorf("Error aborting multipart upload: %s", abortErr)
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error creating upload session",
+		})
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"session_id":   sessionID,
+		"bucket_id":    bucket.BucketID,
+		"key":          filePath,
+		"content_type": body.ContentType,
+	})
+}
+
+type UploadSessionsListHandler struct {
+	sessionsRepo repo.UploadSessionsRepo
+}
+
+func UploadSessionsListHandlerCtor(sessionsRepo repo.UploadSessionsRepo) Handler {
+	return UploadSessionsListHandler{sessionsRepo: sessionsRepo}
+}
+
+func (h UploadSessionsListHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	sessions, err := h.sessionsRepo.List(userID)
+	if err != nil {
+		log.Error("Error listing upload sessions. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error listing upload sessions",
+		})
+	}
+	result := make([]fiber.Map, 0, len(sessions))
+	for _, session := range sessions {
+		if tokenAllowsBucket(c, session.BucketID) {
+			result = append(result, uploadSessionJSON(session))
+		}
+	}
+	return c.JSON(fiber.Map{
+		"sessions": result,
+	})
+}
+
+type UploadSessionDetailHandler struct {
+	sessionsRepo repo.UploadSessionsRepo
+	bucketsRepo  repo.BucketsRepo
+	authz        srv.BucketAuthz
+}
+
+func UploadSessionDetailHandlerCtor(sessionsRepo repo.UploadSessionsRepo, bucketsRepo repo.BucketsRepo, authz srv.BucketAuthz) Handler {
+	return UploadSessionDetailHandler{sessionsRepo: sessionsRepo, bucketsRepo: bucketsRepo, authz: authz}
+}
+
+func (h UploadSessionDetailHandler) Handle(c *fiber.Ctx) error {
+	ctx := context.Background()
+	target, err := loadUploadSession(c, ctx, h.sessionsRepo, h.bucketsRepo, h.authz)
+	if target == nil {
+		return err
+	}
+	parts, err := listUploadedParts(ctx, target)
+	if err != nil {
+		log.Errorf("Error listing uploaded parts: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list uploaded parts",
+		})
+	}
+	uploadedParts := make([]fiber.Map, 0, len(parts))
+	for _, part := range parts {
+		uploadedParts = append(uploadedParts, fiber.Map{
+			"part_number":   aws.ToInt32(part.PartNumber),
+			"etag":          aws.ToString(part.ETag),
+			"size":          aws.ToInt64(part.Size),
+			"last_modified": part.LastModified,
+		})
+	}
+	result := uploadSessionJSON(*target.session)
+	result["parts"] = uploadedParts
+	return c.JSON(result)
+}
+
+type UploadPartHandler struct {
+	sessionsRepo repo.UploadSessionsRepo
+	bucketsRepo  repo.BucketsRepo
+	authz        srv.BucketAuthz
+}
+
+func UploadPartHandlerCtor(sessionsRepo repo.UploadSessionsRepo, bucketsRepo repo.BucketsRepo, authz srv.BucketAuthz) Handler {
+	return UploadPartHandler{sessionsRepo: sessionsRepo, bucketsRepo: bucketsRepo, authz: authz}
+}
+
+func (h UploadPartHandler) Handle(c *fiber.Ctx) error {
+	partNumber, err := strconv.Atoi(c.Params("number"))
+	if err != nil || partNumber < 1 || partNumber > maxUploadPartNumber {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid part number",
+		})
+	}
+	contentLength := c.Request().Header.ContentLength()
+	if contentLength < 0 {
+		return c.Status(fiber.StatusLengthRequired).JSON(fiber.Map{
+			"error": "Content-Length is required",
+		})
+	}
+	ctx := context.Background()
+	target, err := loadUploadSession(c, ctx, h.sessionsRepo, h.bucketsRepo, h.authz)
+	if target == nil {
+		return err
+	}
+	stream := c.Context().RequestBodyStream()
+	if stream == nil {
+		stream = bytes.NewReader(c.Body())
+	}
+	result, err := target.s3Client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(target.bucket.BucketName),
+		Key:           aws.String(target.session.ObjectKey),
+		UploadId:      aws.String(target.session.UploadID),
+		PartNumber:    aws.Int32(int32(partNumber)),
+		ContentLength: aws.Int64(int64(contentLength)),
+		Body:          stream,
+	})
+	if err != nil {
+		log.Errorf("Error uploading part: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to upload part",
+		})
+	}
+	return c.JSON(fiber.Map{
+		"part_number": partNumber,
+		"etag":        aws.ToString(result.ETag),
+		"size":        contentLength,
+	})
+}
+
+type UploadSessionCompleteHandler struct {
+	sessionsRepo repo.UploadSessionsRepo
+	bucketsRepo  repo.BucketsRepo
+	authz        srv.BucketAuthz
+}
+
+func UploadSessionCompleteHandlerCtor(sessionsRepo repo.UploadSessionsRepo, bucketsRepo repo.BucketsRepo, authz srv.BucketAuthz) Handler {
+	return UploadSessionCompleteHandler{sessionsRepo: sessionsRepo, bucketsRepo: bucketsRepo, authz: authz}
+}
+
+func (h UploadSessionCompleteHandler) Handle(c *fiber.Ctx) error {
+	ctx := context.Background()
+	target, err := loadUploadSession(c, ctx, h.sessionsRepo, h.bucketsRepo, h.authz)
+	if target == nil {
+		return err
+	}
+	parts, err := listUploadedParts(ctx, target)
+	if err != nil {
+		log.Errorf("Error listing uploaded parts: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list uploaded parts",
+		})
+	}
+	if len(parts) == 0 {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "No parts uploaded",
+		})
+	}
+	ifNoneMatch, ok, err := guardOverwrite(
+		c, ctx, h.authz, target.session.UserID, target.bucket, target.s3Client, target.session.ObjectKey,
+	)
+	if !ok {
+		return err
+	}
+	var size int64
+	completedParts := make([]types.CompletedPart, 0, len(parts))
+	for _, part := range parts {
+		size += aws.ToInt64(part.Size)
+		completedParts = append(completedParts, types.CompletedPart{
+			ETag:       part.ETag,
+			PartNumber: part.PartNumber,
+		})
+	}
+	result, err := target.s3Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(target.bucket.BucketName),
+		Key:             aws.String(target.session.ObjectKey),
+		UploadId:        aws.String(target.session.UploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completedParts},
+		IfNoneMatch:     ifNoneMatch,
+	})
+	if err != nil {
+		if srv.IsPreconditionFailed(err) {
+			return objectExistsError(c)
+		}
+		log.Errorf("Error completing multipart upload: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to complete multipart upload",
+		})
+	}
+	err = h.sessionsRepo.Delete(target.session.UserID, target.session.SessionID)
+	if err != nil {
+		log.Error("Error deleting upload session. Err=%s\n", err)
+	}
+	return c.JSON(fiber.Map{
+		"key":  target.session.ObjectKey,
+		"size": size,
+		"etag": aws.ToString(result.ETag),
+	})
+}
+
+type UploadSessionAbortHandler struct {
+	sessionsRepo repo.UploadSessionsRepo
+	bucketsRepo  repo.BucketsRepo
+	authz        srv.BucketAuthz
+}
+
+func UploadSessionAbortHandlerCtor(sessionsRepo repo.UploadSessionsRepo, bucketsRepo repo.BucketsRepo, authz srv.BucketAuthz) Handler {
+	return UploadSessionAbortHandler{sessionsRepo: sessionsRepo, bucketsRepo: bucketsRepo, authz: authz}
+}
+
+func (h UploadSessionAbortHandler) Handle(c *fiber.Ctx) error {
+	ctx := context.Background()
+	target, err := loadUploadSession(c, ctx, h.sessionsRepo, h.bucketsRepo, h.authz)
+	if target == nil {
+		return err
+	}
+	_, err = target.s3Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(target.bucket.BucketName),
+		Key:      aws.String(target.session.ObjectKey),
+		UploadId: aws.String(target.session.UploadID),
+	})
+	if err != nil {
+		var noSuchUpload *types.NoSuchUpload
+		if !errors.As(err, &noSuchUpload) {
+			log.Errorf("Error aborting multipart upload: %s", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to abort multipart upload",
+			})
+		}
+	}
+	err = h.sessionsRepo.Delete(target.session.UserID, target.session.SessionID)
+	if err != nil {
+		log.Error("Error deleting upload session. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error deleting upload session",
+		})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
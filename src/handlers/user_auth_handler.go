@@ -27,19 +27,22 @@ package handlers
 import (
 	"errors"
 	"fmt"
-	"strings"
+	"math"
+	"strconv"
 
 	"github.com/blablatdinov/web-s3/src/repo"
 	"github.com/blablatdinov/web-s3/src/srv"
 	fiber "github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
 )
 
 type UserAuthHandler struct {
 	userAuthSrv srv.UserAuth
+	limiter     srv.AuthLimiter
 }
 
-func UserAuthCtor(u srv.UserAuth) Handler {
-	return UserAuthHandler{u}
+func UserAuthCtor(u srv.UserAuth, limiter srv.AuthLimiter) Handler {
+	return UserAuthHandler{u, limiter}
 }
 
 func (userAuth UserAuthHandler) Handle(fiberContext *fiber.Ctx) error {
@@ -51,26 +54,92 @@ func (userAuth UserAuthHandler) Handle(fiberContext *fiber.Ctx) error {
 	if err != nil {
 		fmt.Printf("Error parsing body. Err=%s\n", err)
 	}
-	t, err := userAuth.userAuthSrv.Jwt(body.Username, body.Password)
+	auditUsername(fiberContext, body.Username)
+	client := requestClient(fiberContext)
+	err = userAuth.limiter.AllowLogin(body.Username, client)
 	if err != nil {
 		return handleAuthError(fiberContext, err)
 	}
+	tokens, err := userAuth.userAuthSrv.Jwt(body.Username, body.Password, client)
+	if err != nil {
+		if errors.Is(err, repo.ErrUserNotFound) || errors.Is(err, srv.ErrInvalidPassword) {
+			limiterErr := userAuth.limiter.LoginFailed(body.Username)
+			if limiterErr != nil {
+				log.Errorf("Error recording failed login: %s", limiterErr)
+			}
+		}
+		return handleAuthError(fiberContext, err)
+	}
+	// Failures are only forgotten after the second factor is passed too.
+	if tokens.MfaToken == "" {
+		err = userAuth.limiter.LoginSucceeded(body.Username)
+		if err != nil {
+			log.Errorf("Error resetting failed logins: %s", err)
+		}
+	}
+	return tokensResponse(fiberContext, tokens)
+}
+
+func tokensResponse(fiberContext *fiber.Ctx, tokens srv.Tokens) error {
+	if tokens.MfaToken != "" {
+		return fiberContext.JSON(fiber.Map{
+			"mfa_required": true,
+			"mfa_token":    tokens.MfaToken,
+		})
+	}
 	return fiberContext.JSON(fiber.Map{
-		"access": t,
+		"access":  tokens.Access,
+		"refresh": tokens.Refresh,
+	})
+}
+
+func requestClient(fiberContext *fiber.Ctx) srv.Client {
+	return srv.Client{
+		IP:        fiberContext.IP(),
+		UserAgent: fiberContext.Get(fiber.HeaderUserAgent),
+	}
+}
+
+func tooManyAttempts(fiberContext *fiber.Ctx, err error) error {
+	var throttled srv.TooManyAttemptsError
+	if errors.As(err, &throttled) {
+		retryAfter := int(math.Ceil(throttled.RetryAfter.Seconds()))
+		fiberContext.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+	}
+	return fiberContext.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"error": "Too many attempts, try again later",
 	})
 }
 
 func handleAuthError(fiberContext *fiber.Ctx, err error) error {
+	if errors.Is(err, srv.ErrTooManyAttempts) {
+		return tooManyAttempts(fiberContext, err)
+	}
 	if errors.Is(err, repo.ErrUserNotFound) {
 		return fiberContext.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"error": "Invalid username or password",
 		})
 	}
-	if strings.Contains(err.Error(), "Invalid password") {
+	if errors.Is(err, srv.ErrInvalidPassword) {
 		return fiberContext.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"error": "Invalid username or password",
 		})
 	}
+	if errors.Is(err, repo.ErrUsernameAlreadyExist) {
+		return fiberContext.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Username is already taken by another account",
+		})
+	}
+	if errors.Is(err, srv.ErrInvalidMfaCode) {
+		return fiberContext.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid code",
+		})
+	}
+	if errors.Is(err, srv.ErrInvalidToken) || errors.Is(err, srv.ErrTokenRevoked) || errors.Is(err, repo.ErrSessionNotFound) {
+		return fiberContext.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired token",
+		})
+	}
 	if errors.Is(err, repo.ErrSQL) {
 		fmt.Printf("Database error: %s\n", err)
 		return fiberContext.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
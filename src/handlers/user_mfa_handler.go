@@ -0,0 +1,300 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package handlers
+
+import (
+	"errors"
+
+	"github.com/blablatdinov/web-s3/src/srv"
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+type UserMfaVerifyHandler struct {
+	userAuthSrv srv.UserAuth
+	limiter     srv.AuthLimiter
+}
+
+func UserMfaVerifyCtor(u srv.UserAuth, limiter srv.AuthLimiter) Handler {
+	return UserMfaVerifyHandler{u, limiter}
+}
+
+func (h UserMfaVerifyHandler) Handle(fiberContext *fiber.Ctx) error {
+	body := struct {
+		MfaToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}{}
+	err := fiberContext.BodyParser(&body)
+	if err != nil {
+		log.Error("Error parsing body. Err=%s\n", err)
+	}
+	username, err := h.userAuthSrv.MfaUsername(body.MfaToken)
+	if err != nil {
+		return handleAuthError(fiberContext, err)
+	}
+	auditUsername(fiberContext, username)
+	err = h.limiter.AllowLogin(username, requestClient(fiberContext))
+	if err != nil {
+		return handleAuthError(fiberContext, err)
+	}
+	tokens, err := h.userAuthSrv.VerifyMfa(body.MfaToken, body.Code, requestClient(fiberContext))
+	if err != nil {
+		if errors.Is(err, srv.ErrInvalidMfaCode) {
+			limiterErr := h.limiter.LoginFailed(username)
+			if limiterErr != nil {
+				log.Errorf("Error recording failed login: %s", limiterErr)
+			}
+		}
+		return handleAuthError(fiberContext, err)
+	}
+	err = h.limiter.LoginSucceeded(username)
+	if err != nil {
+		log.Errorf("Error resetting failed logins: %s", err)
+	}
+	return tokensResponse(fiberContext, tokens)
+}
+
+type mfaCodeBody struct {
+	Code string `json:"code"`
+}
+
+// limitCredentialCheck runs a check of a password or a second factor code
+// under the login limiter, so a stolen session can not brute-force it.
+func limitCredentialCheck(fiberContext *fiber.Ctx, limiter srv.AuthLimiter, username string, check func() error) error {
+	err := limiter.AllowLogin(username, requestClient(fiberContext))
+	if err != nil {
+		return err
+	}
+	err = check()
+	if errors.Is(err, srv.ErrInvalidMfaCode) || errors.Is(err, srv.ErrInvalidPassword) {
+		limiterErr := limiter.LoginFailed(username)
+		if limiterErr != nil {
+			log.Errorf("Error recording failed login: %s", limiterErr)
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	err = limiter.LoginSucceeded(username)
+	if err != nil {
+		log.Errorf("Error resetting failed logins: %s", err)
+	}
+	return nil
+}
+
+func handleMfaError(fiberContext *fiber.Ctx, err error) error {
+	if errors.Is(err, srv.ErrTooManyAttempts) {
+		return tooManyAttempts(fiberContext, err)
+	}
+	if errors.Is(err, srv.ErrInvalidPassword) {
+		return fiberContext.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Invalid password",
+		})
+	}
+	if errors.Is(err, srv.ErrInvalidMfaCode) {
+		return fiberContext.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Invalid code",
+		})
+	}
+	if errors.Is(err, srv.ErrMfaAlreadyEnabled) {
+		return fiberContext.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Two-factor authentication is already enabled",
+		})
+	}
+	if errors.Is(err, srv.ErrMfaNotEnabled) {
+		return fiberContext.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Two-factor authentication is not enabled",
+		})
+	}
+	log.Error("Error managing two-factor authentication. Err=%s\n", err)
+	return fiberContext.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Internal server error",
+	})
+}
+
+type UserMfaStatusHandler struct {
+	mfa srv.Mfa
+}
+
+func UserMfaStatusCtor(mfa srv.Mfa) Handler {
+	return UserMfaStatusHandler{mfa}
+}
+
+func (h UserMfaStatusHandler) Handle(fiberContext *fiber.Ctx) error {
+	userID, ok := GetUserID(fiberContext)
+	if !ok {
+		return fiberContext.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	enabled, err := h.mfa.Enabled(userID)
+	if err != nil {
+		return handleMfaError(fiberContext, err)
+	}
+	return fiberContext.JSON(fiber.Map{
+		"totp_enabled": enabled,
+	})
+}
+
+type UserTotpEnrollHandler struct {
+	mfa srv.Mfa
+}
+
+func UserTotpEnrollCtor(mfa srv.Mfa) Handler {
+	return UserTotpEnrollHandler{mfa}
+}
+
+// Handle returns the secret for manual entry and the otpauth URI the
+// frontend renders as a QR code.
+func (h UserTotpEnrollHandler) Handle(fiberContext *fiber.Ctx) error {
+	userID, ok := GetUserID(fiberContext)
+	if !ok {
+		return fiberContext.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	username, _ := fiberContext.Locals(UsernameKey).(string)
+	setup, err := h.mfa.Enroll(userID, username)
+	if err != nil {
+		return handleMfaError(fiberContext, err)
+	}
+	return fiberContext.JSON(fiber.Map{
+		"secret":      setup.Secret,
+		"otpauth_uri": setup.URI,
+	})
+}
+
+type UserTotpConfirmHandler struct {
+	mfa srv.Mfa
+}
+
+func UserTotpConfirmCtor(mfa srv.Mfa) Handler {
+	return UserTotpConfirmHandler{mfa}
+}
+
+func (h UserTotpConfirmHandler) Handle(fiberContext *fiber.Ctx) error {
+	userID, ok := GetUserID(fiberContext)
+	if !ok {
+		return fiberContext.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	var body mfaCodeBody
+	err := fiberContext.BodyParser(&body)
+	if err != nil {
+		return fiberContext.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	recoveryCodes, err := h.mfa.Confirm(userID, body.Code)
+	if err != nil {
+		return handleMfaError(fiberContext, err)
+	}
+	return fiberContext.JSON(fiber.Map{
+		"recovery_codes": recoveryCodes,
+	})
+}
+
+type UserRecoveryCodesHandler struct {
+	mfa     srv.Mfa
+	limiter srv.AuthLimiter
+}
+
+func UserRecoveryCodesCtor(mfa srv.Mfa, limiter srv.AuthLimiter) Handler {
+	return UserRecoveryCodesHandler{mfa, limiter}
+}
+
+func (h UserRecoveryCodesHandler) Handle(fiberContext *fiber.Ctx) error {
+	userID, ok := GetUserID(fiberContext)
+	if !ok {
+		return fiberContext.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	var body mfaCodeBody
+	err := fiberContext.BodyParser(&body)
+	if err != nil {
+		return fiberContext.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	username, _ := fiberContext.Locals(UsernameKey).(string)
+	var recoveryCodes []string
+	err = limitCredentialCheck(fiberContext, h.limiter, username, func() error {
+		recoveryCodes, err = h.mfa.RegenerateRecoveryCodes(userID, body.Code)
+		return err
+	})
+	if err != nil {
+		return handleMfaError(fiberContext, err)
+	}
+	return fiberContext.JSON(fiber.Map{
+		"recovery_codes": recoveryCodes,
+	})
+}
+
+type UserTotpDisableHandler struct {
+	mfa         srv.Mfa
+	userAuthSrv srv.UserAuth
+	limiter     srv.AuthLimiter
+}
+
+func UserTotpDisableCtor(mfa srv.Mfa, userAuthSrv srv.UserAuth, limiter srv.AuthLimiter) Handler {
+	return UserTotpDisableHandler{mfa, userAuthSrv, limiter}
+}
+
+// Handle turns the second factor off only with both the current password
+// and a code, a stolen session alone is not enough.
+
+func (h UserTotpDisableHandler) Handle(fiberContext *fiber.Ctx) error {
+	userID, ok := GetUserID(fiberContext)
+	if !ok {
+		return fiberContext.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	body := struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}{}
+	err := fiberContext.BodyParser(&body)
+	if err != nil {
+		return fiberContext.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	username, _ := fiberContext.Locals(UsernameKey).(string)
+	err = limitCredentialCheck(fiberContext, h.limiter, username, func() error {
+		err := h.userAuthSrv.CheckPassword(userID, username, body.Password)
+		if err != nil {
+			return err
+		}
+		return h.mfa.Disable(userID, body.Code)
+	})
+	if err != nil {
+		return handleMfaError(fiberContext, err)
+	}
+	return fiberContext.SendStatus(fiber.StatusNoContent)
+}
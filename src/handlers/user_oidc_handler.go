@@ -0,0 +1,174 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package handlers
+
+import (
+	"errors"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/blablatdinov/web-s3/src/repo"
+	"github.com/blablatdinov/web-s3/src/srv"
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcStateMaxAge = 10 * time.Minute
+)
+
+// setOidcStateCookie binds the login state to the browser starting it, so
+// nobody can make a victim finish a login the attacker started. The cookie
+// covers the directory of the current route, which holds the callback.
+// A negative maxAge removes the cookie.
+func setOidcStateCookie(c *fiber.Ctx, state string, maxAge time.Duration) {
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     path.Dir(c.Path()),
+		MaxAge:   int(maxAge.Seconds()),
+		Secure:   c.Protocol() == "https",
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
+
+type OidcLoginHandler struct {
+	oidcLogin srv.OidcLogin
+}
+
+func OidcLoginCtor(oidcLogin srv.OidcLogin) Handler {
+	return OidcLoginHandler{oidcLogin}
+}
+
+func (h OidcLoginHandler) Handle(c *fiber.Ctx) error {
+	authURL, state, err := h.oidcLogin.AuthURL()
+	if err != nil {
+		log.Error("Error starting oidc login. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error starting single sign-on",
+		})
+	}
+	setOidcStateCookie(c, state, oidcStateMaxAge)
+	return c.Redirect(authURL, fiber.StatusFound)
+}
+
+type OidcLinkHandler struct {
+	oidcLogin srv.OidcLogin
+}
+
+// OidcLinkCtor starts linking an identity to the logged in account. The
+// browser has to open the returned url, the callback then links and logs in.
+func OidcLinkCtor(oidcLogin srv.OidcLogin) Handler {
+	return OidcLinkHandler{oidcLogin}
+}
+
+func (h OidcLinkHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	authURL, state, err := h.oidcLogin.LinkURL(userID)
+	if err != nil {
+		log.Error("Error starting oidc link. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error starting single sign-on",
+		})
+	}
+	setOidcStateCookie(c, state, oidcStateMaxAge)
+	return c.JSON(fiber.Map{
+		"url": authURL,
+	})
+}
+
+type OidcCallbackHandler struct {
+	oidcLogin   srv.OidcLogin
+	frontendURL string
+}
+
+// OidcCallbackCtor builds the redirect endpoint of the identity provider.
+// With frontendURL set the tokens, or the mfa token when the second factor
+// is required, are passed to it in the URL fragment, otherwise they are
+// returned as JSON.
+func OidcCallbackCtor(oidcLogin srv.OidcLogin, frontendURL string) Handler {
+	return OidcCallbackHandler{oidcLogin, frontendURL}
+}
+
+func (h OidcCallbackHandler) Handle(c *fiber.Ctx) error {
+	if c.Query("error") != "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error":             "Single sign-on failed",
+			"provider_error":    c.Query("error"),
+			"error_description": c.Query("error_description"),
+		})
+	}
+	browserState := c.Cookies(oidcStateCookie)
+	setOidcStateCookie(c, "", -time.Second)
+	tokens, err := h.oidcLogin.Callback(c.Query("code"), c.Query("state"), browserState, requestClient(c))
+	if err != nil {
+		if errors.Is(err, srv.ErrOidcInvalidState) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid or expired login state",
+			})
+		}
+		if errors.Is(err, srv.ErrOidcInvalidToken) {
+			log.Warnf("Rejected oidc login: %s", err)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid identity token",
+			})
+		}
+		if errors.Is(err, srv.ErrOidcUserNotAllowed) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "No web-s3 account is linked to this identity",
+			})
+		}
+		if errors.Is(err, repo.ErrIdentityAlreadyLinked) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "This identity is already linked to an account",
+			})
+		}
+		if errors.Is(err, repo.ErrUsernameAlreadyExist) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Username is already taken by another account",
+			})
+		}
+		return handleAuthError(c, err)
+	}
+	if h.frontendURL == "" {
+		return tokensResponse(c, tokens)
+	}
+	fragment := url.Values{}
+	if tokens.MfaToken != "" {
+		fragment.Set("mfa_token", tokens.MfaToken)
+	} else {
+		fragment.Set("access", tokens.Access)
+		fragment.Set("refresh", tokens.Refresh)
+	}
+	return c.Redirect(h.frontendURL+"#"+fragment.Encode(), fiber.StatusFound)
+}
@@ -0,0 +1,107 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package handlers
+
+import (
+	"errors"
+
+	"github.com/blablatdinov/web-s3/src/repo"
+	"github.com/blablatdinov/web-s3/src/srv"
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+type UserPasswordHandler struct {
+	passwordChange srv.PasswordChange
+	userAuthSrv    srv.UserAuth
+	limiter        srv.AuthLimiter
+}
+
+func UserPasswordCtor(passwordChange srv.PasswordChange, userAuthSrv srv.UserAuth, limiter srv.AuthLimiter) Handler {
+	return UserPasswordHandler{passwordChange, userAuthSrv, limiter}
+}
+
+func (h UserPasswordHandler) Handle(fiberContext *fiber.Ctx) error {
+	userID, ok := GetUserID(fiberContext)
+	if !ok {
+		return fiberContext.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	username, _ := fiberContext.Locals(UsernameKey).(string)
+	body := struct {
+		OldPassword string `json:"old_password"`
+		NewPassword string `json:"new_password"`
+	}{}
+	err := fiberContext.BodyParser(&body)
+	if err != nil {
+		return fiberContext.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	// A wrong old password counts as a failed login, otherwise an access
+	// token would be an unthrottled password guessing oracle.
+	err = limitCredentialCheck(fiberContext, h.limiter, username, func() error {
+		return h.passwordChange.Change(userID, username, body.OldPassword, body.NewPassword)
+	})
+	if err != nil {
+		if errors.Is(err, srv.ErrTooManyAttempts) {
+			return tooManyAttempts(fiberContext, err)
+		}
+		if errors.Is(err, srv.ErrInvalidPassword) {
+			return fiberContext.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Invalid old password",
+			})
+		}
+		if errors.Is(err, srv.ErrNoLocalPassword) {
+			return fiberContext.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Password is managed by an external identity provider",
+			})
+		}
+		if errors.Is(err, srv.ErrSamePassword) {
+			return fiberContext.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error": "New password must differ from the old one",
+			})
+		}
+		if errors.Is(err, srv.ErrWeakPassword) {
+			return weakPassword(fiberContext, err)
+		}
+		if errors.Is(err, repo.ErrUserNotFound) {
+			return fiberContext.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+		log.Error("Error changing password. Err=%s\n", err)
+		return fiberContext.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error changing password",
+		})
+	}
+	// All sessions are gone now, log the caller back in with the new password.
+	tokens, err := h.userAuthSrv.Jwt(username, body.NewPassword, requestClient(fiberContext))
+	if err != nil {
+		return handleAuthError(fiberContext, err)
+	}
+	return tokensResponse(fiberContext, tokens)
+}
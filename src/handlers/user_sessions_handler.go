@@ -0,0 +1,107 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package handlers
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/blablatdinov/web-s3/src/repo"
+	"github.com/blablatdinov/web-s3/src/srv"
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+type UserSessionsListHandler struct {
+	userAuthSrv srv.UserAuth
+}
+
+func UserSessionsListCtor(u srv.UserAuth) Handler {
+	return UserSessionsListHandler{u}
+}
+
+func (h UserSessionsListHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	currentSessionID, _ := GetSessionID(c)
+	sessions, err := h.userAuthSrv.Sessions(userID)
+	if err != nil {
+		log.Error("Error listing sessions. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error listing sessions",
+		})
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+	result := make([]fiber.Map, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, fiber.Map{
+			"session_id":   session.SessionID,
+			"created_at":   session.CreatedAt,
+			"last_used_at": session.LastUsedAt,
+			"ip":           session.IP,
+			"user_agent":   session.UserAgent,
+			"current":      session.SessionID == currentSessionID,
+		})
+	}
+	return c.JSON(fiber.Map{
+		"sessions": result,
+	})
+}
+
+type UserSessionDeleteHandler struct {
+	userAuthSrv srv.UserAuth
+}
+
+func UserSessionDeleteCtor(u srv.UserAuth) Handler {
+	return UserSessionDeleteHandler{u}
+}
+
+func (h UserSessionDeleteHandler) Handle(c *fiber.Ctx) error {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	err := h.userAuthSrv.RevokeSession(userID, c.Params("id"))
+	if err != nil {
+		if errors.Is(err, repo.ErrSessionNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Session not found",
+			})
+		}
+		log.Error("Error revoking session. Err=%s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error revoking session",
+		})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
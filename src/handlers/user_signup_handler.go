@@ -10,11 +10,12 @@ import (
 )
 
 type UserSingUpHandler struct {
-	srv srv.UserSignupSrv
+	srv     srv.UserSignupSrv
+	limiter srv.AuthLimiter
 }
 
-func UserSingUpCtor(srv srv.UserSignupSrv) Handler {
-	return UserSingUpHandler{srv}
+func UserSingUpCtor(srv srv.UserSignupSrv, limiter srv.AuthLimiter) Handler {
+	return UserSingUpHandler{srv, limiter}
 }
 
 func (h UserSingUpHandler) Handle(fiberContext *fiber.Ctx) error {
@@ -27,6 +28,14 @@ func (h UserSingUpHandler) Handle(fiberContext *fiber.Ctx) error {
 		log.Error("Error parsing body. Err=%s\n", err)
 		return fiberContext.Status(422).JSON(fiber.Map{"details": "Invalid request body"})
 	}
+	err = h.limiter.AllowSignup(requestClient(fiberContext))
+	if err != nil {
+		if errors.Is(err, srv.ErrTooManyAttempts) {
+			return tooManyAttempts(fiberContext, err)
+		}
+		log.Error("Error checking sign-up rate limit. Err=%s\n", err)
+		return fiberContext.Status(500).JSON(fiber.Map{"details": "Error checking rate limit"})
+	}
 	if body.Username == "" {
 		return fiberContext.Status(422).JSON(fiber.Map{"details": "Invalid username"})
 	}
@@ -35,6 +44,8 @@ func (h UserSingUpHandler) Handle(fiberContext *fiber.Ctx) error {
 		if errors.Is(err, srv.ErrorHashingPassword) {
 			log.Errorf("Error hashing password %s", err.Error())
 			return fiberContext.Status(500).JSON(fiber.Map{"details": "Error hashing password"})
+		} else if errors.Is(err, srv.ErrWeakPassword) {
+			return weakPassword(fiberContext, err)
 		} else if errors.Is(err, repo.ErrUsernameAlreadyExist) {
 			return fiberContext.Status(422).JSON(fiber.Map{"details": "Username already exists"})
 		} else if errors.Is(err, repo.ErrSQL) {
@@ -47,3 +58,15 @@ func (h UserSingUpHandler) Handle(fiberContext *fiber.Ctx) error {
 		"username": body.Username,
 	})
 }
+
+func weakPassword(fiberContext *fiber.Ctx, err error) error {
+	violations := []string{}
+	var policyErr srv.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		violations = policyErr.Violations
+	}
+	return fiberContext.Status(422).JSON(fiber.Map{
+		"details":    "Password does not satisfy policy",
+		"violations": violations,
+	})
+}
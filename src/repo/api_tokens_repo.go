@@ -0,0 +1,213 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package repo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var ErrApiTokenNotFound = errors.New("api token not found")
+
+const (
+	ApiTokenScopeRead      = "read"
+	ApiTokenScopeReadWrite = "read-write"
+)
+
+type ApiToken struct {
+	ApiTokenID int
+	UserID     int
+	Username   string
+	Name       string
+	// Prefix is the beginning of the token shown to tell tokens apart.
+	Prefix string
+	Scope  string
+	// BucketIDs limits the token to these buckets, empty means all.
+	BucketIDs  []int
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+	ExpiresAt  *time.Time
+}
+
+type NewApiToken struct {
+	Name      string
+	TokenHash string
+	Prefix    string
+	Scope     string
+	BucketIDs []int
+	ExpiresAt *time.Time
+}
+
+type ApiTokensRepo interface {
+	List(userID int) ([]ApiToken, error)
+	Create(userID int, token NewApiToken) (ApiToken, error)
+	Delete(userID, apiTokenID int) error
+	ByHash(tokenHash string) (ApiToken, error)
+	Touch(apiTokenID int, lastUsedAt time.Time) error
+}
+
+type PgApiTokensRepo struct {
+	pgsql *sqlx.DB
+}
+
+func PgApiTokensRepoCtor(pgsql *sqlx.DB) ApiTokensRepo {
+	return PgApiTokensRepo{pgsql}
+}
+
+type apiTokenRow struct {
+	ApiTokenID int           `db:"api_token_id"`
+	UserID     int           `db:"user_id"`
+	Username   string        `db:"username"`
+	Name       string        `db:"name"`
+	Prefix     string        `db:"token_prefix"`
+	Scope      string        `db:"scope"`
+	BucketIDs  pq.Int64Array `db:"bucket_ids"`
+	CreatedAt  time.Time     `db:"created_at"`
+	LastUsedAt sql.NullTime  `db:"last_used_at"`
+	ExpiresAt  sql.NullTime  `db:"expires_at"`
+}
+
+func (row apiTokenRow) apiToken() ApiToken {
+	token := ApiToken{
+		ApiTokenID: row.ApiTokenID,
+		UserID:     row.UserID,
+		Username:   row.Username,
+		Name:       row.Name,
+		Prefix:     row.Prefix,
+		Scope:      row.Scope,
+		BucketIDs:  make([]int, 0, len(row.BucketIDs)),
+		CreatedAt:  row.CreatedAt,
+	}
+	for _, bucketID := range row.BucketIDs {
+		token.BucketIDs = append(token.BucketIDs, int(bucketID))
+	}
+	if row.LastUsedAt.Valid {
+		token.LastUsedAt = &row.LastUsedAt.Time
+	}
+	if row.ExpiresAt.Valid {
+		token.ExpiresAt = &row.ExpiresAt.Time
+	}
+	return token
+}
+
+var apiTokenColumns = strings.Join([]string{
+	"SELECT t.api_token_id, t.user_id, u.username, t.name, t.token_prefix, t.scope,",
+	"  t.bucket_ids, t.created_at, t.last_used_at, t.expires_at",
+	"FROM api_tokens t",
+	"JOIN users u ON u.user_id = t.user_id",
+}, "\n")
+
+func (r PgApiTokensRepo) List(userID int) ([]ApiToken, error) {
+	var rows []apiTokenRow
+	err := r.pgsql.Select(
+		&rows,
+		strings.Join([]string{
+			apiTokenColumns,
+			"WHERE t.user_id = $1",
+			"ORDER BY t.created_at DESC",
+		}, "\n"),
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	tokens := make([]ApiToken, 0, len(rows))
+	for _, row := range rows {
+		tokens = append(tokens, row.apiToken())
+	}
+	return tokens, nil
+}
+
+func (r PgApiTokensRepo) Create(userID int, token NewApiToken) (ApiToken, error) {
+	bucketIDs := make(pq.Int64Array, 0, len(token.BucketIDs))
+	for _, bucketID := range token.BucketIDs {
+		bucketIDs = append(bucketIDs, int64(bucketID))
+	}
+	var apiTokenID int
+	err := r.pgsql.QueryRow(
+		strings.Join([]string{
+			"INSERT INTO api_tokens (user_id, name, token_hash, token_prefix, scope, bucket_ids, expires_at)",
+			"VALUES ($1, $2, $3, $4, $5, $6, $7)",
+			"RETURNING api_token_id",
+		}, "\n"),
+		userID, token.Name, token.TokenHash, token.Prefix, token.Scope, bucketIDs, token.ExpiresAt,
+	).Scan(&apiTokenID)
+	if err != nil {
+		return ApiToken{}, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	var row apiTokenRow
+	err = r.pgsql.Get(&row, apiTokenColumns+"\nWHERE t.api_token_id = $1", apiTokenID)
+	if err != nil {
+		return ApiToken{}, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return row.apiToken(), nil
+}
+
+func (r PgApiTokensRepo) Delete(userID, apiTokenID int) error {
+	result, err := r.pgsql.Exec(
+		"DELETE FROM api_tokens WHERE api_token_id = $1 AND user_id = $2",
+		apiTokenID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w: %d", ErrApiTokenNotFound, apiTokenID)
+	}
+	return nil
+}
+
+func (r PgApiTokensRepo) ByHash(tokenHash string) (ApiToken, error) {
+	var row apiTokenRow
+	err := r.pgsql.Get(&row, apiTokenColumns+"\nWHERE t.token_hash = $1", tokenHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ApiToken{}, ErrApiTokenNotFound
+		}
+		return ApiToken{}, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return row.apiToken(), nil
+}
+
+func (r PgApiTokensRepo) Touch(apiTokenID int, lastUsedAt time.Time) error {
+	_, err := r.pgsql.Exec(
+		"UPDATE api_tokens SET last_used_at = $2 WHERE api_token_id = $1",
+		apiTokenID, lastUsedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return nil
+}
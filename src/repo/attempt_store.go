@@ -0,0 +1,146 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package repo
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// AttemptStore keeps counters used to throttle authentication attempts.
+type AttemptStore interface {
+	// Hit records an attempt in a sliding window unless the window already
+	// holds limit attempts. It returns how long the caller has to wait
+	// before the next attempt is accepted, zero when this one was recorded.
+	Hit(key string, limit int, window time.Duration) (time.Duration, error)
+	AddFailure(key string, ttl time.Duration) (int, error)
+	Lock(key string, duration, ttl time.Duration) error
+	LockedFor(key string) (time.Duration, error)
+	Reset(key string) error
+}
+
+type RedisAttemptStore struct {
+	rdb *redis.Client
+}
+
+func RedisAttemptStoreCtor(rdb *redis.Client) AttemptStore {
+	return RedisAttemptStore{rdb}
+}
+
+// Sorted set with one member per attempt scored by its time in
+// milliseconds. Returns 0 if the attempt was recorded or the number of
+// milliseconds until the oldest attempt leaves the window.
+var slidingWindowScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+if redis.call("ZCARD", KEYS[1]) >= limit then
+	local oldest = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+	return tonumber(oldest[2]) + window - now
+end
+redis.call("ZADD", KEYS[1], now, ARGV[4])
+redis.call("PEXPIRE", KEYS[1], window)
+return 0
+`)
+
+func attemptsKey(key string) string {
+	return "auth:attempts:" + key
+}
+
+func failuresKey(key string) string {
+	return "auth:failures:" + key
+}
+
+func lockKey(key string) string {
+	return "auth:lock:" + key
+}
+
+func (s RedisAttemptStore) Hit(key string, limit int, window time.Duration) (time.Duration, error) {
+	now := time.Now()
+	wait, err := slidingWindowScript.Run(
+		context.Background(),
+		s.rdb,
+		[]string{attemptsKey(key)},
+		now.UnixMilli(),
+		window.Milliseconds(),
+		limit,
+		strconv.FormatInt(now.UnixNano(), 10),
+	).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("record attempt: %w", err)
+	}
+	return time.Duration(wait) * time.Millisecond, nil
+}
+
+func (s RedisAttemptStore) AddFailure(key string, ttl time.Duration) (int, error) {
+	ctx := context.Background()
+	var incr *redis.IntCmd
+	_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, failuresKey(key))
+		pipe.Expire(ctx, failuresKey(key), ttl)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("record failure: %w", err)
+	}
+	return int(incr.Val()), nil
+}
+
+func (s RedisAttemptStore) Lock(key string, duration, ttl time.Duration) error {
+	ctx := context.Background()
+	_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, lockKey(key), 1, duration)
+		pipe.Expire(ctx, failuresKey(key), ttl)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("lock: %w", err)
+	}
+	return nil
+}
+
+func (s RedisAttemptStore) LockedFor(key string) (time.Duration, error) {
+	ttl, err := s.rdb.PTTL(context.Background(), lockKey(key)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("check lock: %w", err)
+	}
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+func (s RedisAttemptStore) Reset(key string) error {
+	err := s.rdb.Del(context.Background(), failuresKey(key), lockKey(key)).Err()
+	if err != nil {
+		return fmt.Errorf("reset failures: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+package repo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// AuditEntry is one recorded request. User, bucket and key are nil when
+// the request did not carry them, e.g. a failed login of an unknown user.
+type AuditEntry struct {
+	AuditID   int64     `db:"audit_id"`
+	CreatedAt time.Time `db:"created_at"`
+	UserID    *int      `db:"user_id"`
+	Username  *string   `db:"username"`
+	Action    string    `db:"action"`
+	BucketID  *int      `db:"bucket_id"`
+	Key       *string   `db:"object_key"`
+	IP        string    `db:"ip"`
+	UserAgent string    `db:"user_agent"`
+	Status    int       `db:"status"`
+	Result    string    `db:"result"`
+}
+
+// AuditVisibility limits a search to the entries made by UserID or about
+// one of BucketIDs.
+type AuditVisibility struct {
+	UserID    int
+	BucketIDs []int
+}
+
+type AuditFilter struct {
+	UserID    *int
+	Username  string
+	Action    string
+	BucketID  *int
+	KeyPrefix string
+	Result    string
+	From      *time.Time
+	To        *time.Time
+	// Visible is nil for users allowed to see every entry.
+	Visible *AuditVisibility
+	// BeforeID continues a previous page, zero starts from the newest entry.
+	BeforeID int64
+	Limit    int
+}
+
+// AuditRepo is append-only, entries are never changed and only removed
+// once they are older than the retention period.
+type AuditRepo interface {
+	Append(entry AuditEntry) error
+	// Search returns matching entries newest first.
+	Search(filter AuditFilter) ([]AuditEntry, error)
+	// Prune deletes entries older than retention and returns their count.
+	Prune(retention time.Duration) (int64, error)
+}
+
+type PgAuditRepo struct {
+	pgsql *sqlx.DB
+}
+
+func PgAuditRepoCtor(pgsql *sqlx.DB) AuditRepo {
+	return PgAuditRepo{pgsql}
+}
+
+func (r PgAuditRepo) Append(entry AuditEntry) error {
+	_, err := r.pgsql.Exec(
+		strings.Join([]string{
+			"INSERT INTO audit_log",
+			"  (user_id, username, action, bucket_id, object_key, ip, user_agent, status, result)",
+			"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		}, "\n"),
+		entry.UserID, entry.Username, entry.Action, entry.BucketID, entry.Key,
+		entry.IP, entry.UserAgent, entry.Status, entry.Result,
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return nil
+}
+
+func (r PgAuditRepo) Search(filter AuditFilter) ([]AuditEntry, error) {
+	conditions := []string{"TRUE"}
+	args := []any{}
+	where := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.UserID != nil {
+		where("user_id = $%d", *filter.UserID)
+	}
+	if filter.Username != "" {
+		where("username = $%d", filter.Username)
+	}
+	if filter.Action != "" {
+		where("action = $%d", filter.Action)
+	}
+	if filter.BucketID != nil {
+		where("bucket_id = $%d", *filter.BucketID)
+	}
+	if filter.KeyPrefix != "" {
+		where("starts_with(object_key, $%d)", filter.KeyPrefix)
+	}
+	if filter.Result != "" {
+		where("result = $%d", filter.Result)
+	}
+	if filter.From != nil {
+		where("created_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		where("created_at < $%d", *filter.To)
+	}
+	if filter.BeforeID > 0 {
+		where("audit_id < $%d", filter.BeforeID)
+	}
+	if filter.Visible != nil {
+		args = append(args, filter.Visible.UserID, pq.Array(filter.Visible.BucketIDs))
+		conditions = append(conditions, fmt.Sprintf(
+			"(user_id = $%d OR bucket_id = ANY($%d))", len(args)-1, len(args),
+		))
+	}
+	args = append(args, filter.Limit)
+	entries := []AuditEntry{}
+	err := r.pgsql.Select(
+		&entries,
+		strings.Join([]string{
+			"SELECT audit_id, created_at, user_id, username, action, bucket_id, object_key,",
+			"  ip, user_agent, status, result",
+			"FROM audit_log",
+			"WHERE " + strings.Join(conditions, "\n  AND "),
+			"ORDER BY audit_id DESC",
+			fmt.Sprintf("LIMIT $%d", len(args)),
+		}, "\n"),
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return entries, nil
+}
+
+func (r PgAuditRepo) Prune(retention time.Duration) (int64, error) {
+	tx, err := r.pgsql.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil && !errors.Is(rollbackErr, sql.ErrTxDone) {
+			log.Error("Error rolling back transaction. Err=%s\n", rollbackErr)
+		}
+	}()
+	interval := fmt.Sprintf("%d seconds", int64(retention.Seconds()))
+	// The append-only trigger lets through deletes of entries older than
+	// this setting only.
+	_, err = tx.Exec("SELECT set_config('audit_log.retention', $1, true)", interval)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	result, err := tx.Exec("DELETE FROM audit_log WHERE created_at < now() - $1::interval", interval)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,175 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package repo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var ErrBucketGrantNotFound = errors.New("bucket grant not found")
+
+const (
+	BucketRoleViewer   = "viewer"
+	BucketRoleUploader = "uploader"
+	BucketRoleEditor   = "editor"
+	BucketRoleAdmin    = "admin"
+)
+
+// BucketGrant gives a role on the bucket to a user or to every member of an
+// organization, limited to keys starting with Prefix.
+type BucketGrant struct {
+	BucketGrantID int       `db:"bucket_grant_id"`
+	BucketID      int       `db:"bucket_id"`
+	UserID        *int      `db:"user_id"`
+	Username      *string   `db:"username"`
+	OrgID         *int      `db:"org_id"`
+	OrgName       *string   `db:"org_name"`
+	Role          string    `db:"role"`
+	Prefix        string    `db:"prefix"`
+	CreatedAt     time.Time `db:"created_at"`
+}
+
+// NewBucketGrant names either the user or the organization to grant to.
+type NewBucketGrant struct {
+	Username string
+	OrgID    *int
+	Role     string
+	Prefix   string
+}
+
+type BucketGrantsRepo interface {
+	List(bucketID int) ([]BucketGrant, error)
+	// ForUser lists grants on the bucket given to the user directly or to
+	// organizations the user belongs to.
+	ForUser(bucketID, userID int) ([]BucketGrant, error)
+	Create(bucketID int, grant NewBucketGrant) (BucketGrant, error)
+	Delete(bucketID, bucketGrantID int) error
+}
+
+type PgBucketGrantsRepo struct {
+	pgsql *sqlx.DB
+}
+
+func PgBucketGrantsRepoCtor(pgsql *sqlx.DB) BucketGrantsRepo {
+	return PgBucketGrantsRepo{pgsql}
+}
+
+var bucketGrantColumns = strings.Join([]string{
+	"SELECT g.bucket_grant_id, g.bucket_id, g.user_id, u.username, g.org_id, o.name AS org_name,",
+	"  g.role, g.prefix, g.created_at",
+	"FROM bucket_grants g",
+	"LEFT JOIN users u ON u.user_id = g.user_id",
+	"LEFT JOIN orgs o ON o.org_id = g.org_id",
+}, "\n")
+
+func (r PgBucketGrantsRepo) List(bucketID int) ([]BucketGrant, error) {
+	grants := []BucketGrant{}
+	err := r.pgsql.Select(
+		&grants,
+		bucketGrantColumns+"\nWHERE g.bucket_id = $1\nORDER BY g.prefix, g.bucket_grant_id",
+		bucketID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return grants, nil
+}
+
+func (r PgBucketGrantsRepo) ForUser(bucketID, userID int) ([]BucketGrant, error) {
+	grants := []BucketGrant{}
+	err := r.pgsql.Select(
+		&grants,
+		strings.Join([]string{
+			bucketGrantColumns,
+			"WHERE g.bucket_id = $1",
+			"  AND (g.user_id = $2 OR g.org_id IN (SELECT org_id FROM org_members WHERE user_id = $2))",
+		}, "\n"),
+		bucketID, userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return grants, nil
+}
+
+func (r PgBucketGrantsRepo) Create(bucketID int, grant NewBucketGrant) (BucketGrant, error) {
+	var userID *int
+	if grant.OrgID == nil {
+		var id int
+		err := r.pgsql.Get(&id, "SELECT user_id FROM users WHERE username = $1", grant.Username)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return BucketGrant{}, fmt.Errorf("%w: %s", ErrUserNotFound, grant.Username)
+			}
+			return BucketGrant{}, fmt.Errorf("%w: %s", ErrSQL, err)
+		}
+		userID = &id
+	}
+	var bucketGrantID int
+	err := r.pgsql.QueryRow(
+		strings.Join([]string{
+			"INSERT INTO bucket_grants (bucket_id, user_id, org_id, role, prefix)",
+			"VALUES ($1, $2, $3, $4, $5)",
+			"RETURNING bucket_grant_id",
+		}, "\n"),
+		bucketID, userID, grant.OrgID, grant.Role, grant.Prefix,
+	).Scan(&bucketGrantID)
+	if err != nil {
+		if err.Error() == "pq: insert or update on table \"bucket_grants\" violates foreign key constraint \"bucket_grants_org_id_fkey\"" {
+			return BucketGrant{}, fmt.Errorf("%w: %d", ErrOrgNotFound, *grant.OrgID)
+		}
+		return BucketGrant{}, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	var created BucketGrant
+	err = r.pgsql.Get(&created, bucketGrantColumns+"\nWHERE g.bucket_grant_id = $1", bucketGrantID)
+	if err != nil {
+		return BucketGrant{}, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return created, nil
+}
+
+func (r PgBucketGrantsRepo) Delete(bucketID, bucketGrantID int) error {
+	result, err := r.pgsql.Exec(
+		"DELETE FROM bucket_grants WHERE bucket_grant_id = $1 AND bucket_id = $2",
+		bucketGrantID, bucketID,
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w: %d", ErrBucketGrantNotFound, bucketGrantID)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package repo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/jmoiron/sqlx"
+)
+
+// BucketSecretsRepo maintains encryption of stored bucket secrets.
+type BucketSecretsRepo interface {
+	EncryptPlaintext() (int, error)
+	Rewrap() (int, error)
+	// DecryptAll stores every secret in plain text again, it is needed only
+	// to roll the encryption migration back.
+	DecryptAll() (int, error)
+}
+
+type PgBucketSecretsRepo struct {
+	pgsql  *sqlx.DB
+	cipher SecretCipher
+}
+
+func PgBucketSecretsRepoCtor(pgsql *sqlx.DB, cipher SecretCipher) BucketSecretsRepo {
+	return PgBucketSecretsRepo{pgsql, cipher}
+}
+
+type bucketSecretRow struct {
+	BucketID        int            `db:"bucket_id"`
+	SecretAccessKey string         `db:"secret_access_key"`
+	SecretDataKey   sql.NullString `db:"secret_data_key"`
+	SecretKeyID     sql.NullString `db:"secret_key_id"`
+}
+
+// EncryptPlaintext encrypts secrets stored before encryption was introduced.
+func (r PgBucketSecretsRepo) EncryptPlaintext() (int, error) {
+	return r.transform(
+		"WHERE secret_key_id IS NULL",
+		func(row bucketSecretRow) (EncryptedSecret, error) {
+			return r.cipher.Encrypt(row.SecretAccessKey)
+		},
+	)
+}
+
+// Rewrap seals every data key not owned by the active master key with it.
+func (r PgBucketSecretsRepo) Rewrap() (int, error) {
+	return r.transform(
+		"WHERE secret_key_id IS NOT NULL AND secret_key_id <> $1",
+		func(row bucketSecretRow) (EncryptedSecret, error) {
+			return r.cipher.Rewrap(EncryptedSecret{
+				Ciphertext: row.SecretAccessKey,
+				DataKey:    row.SecretDataKey.String,
+				KeyID:      row.SecretKeyID.String,
+			})
+		},
+		r.cipher.ActiveKeyID(),
+	)
+}
+
+func (r PgBucketSecretsRepo) DecryptAll() (int, error) {
+	return r.transform(
+		"WHERE secret_key_id IS NOT NULL",
+		func(row bucketSecretRow) (EncryptedSecret, error) {
+			plaintext, err := r.cipher.Decrypt(EncryptedSecret{
+				Ciphertext: row.SecretAccessKey,
+				DataKey:    row.SecretDataKey.String,
+				KeyID:      row.SecretKeyID.String,
+			})
+			return EncryptedSecret{Ciphertext: plaintext}, err
+		},
+	)
+}
+
+// transform rewrites the secrets of the selected rows, a result without
+// KeyID is stored as plain text.
+func (r PgBucketSecretsRepo) transform(
+	where string,
+	fn func(row bucketSecretRow) (EncryptedSecret, error),
+	args ...any,
+) (int, error) {
+	tx, err := r.pgsql.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil && !errors.Is(rollbackErr, sql.ErrTxDone) {
+			log.Error("Error rolling back transaction. Err=%s\n", rollbackErr)
+		}
+	}()
+	var rows []bucketSecretRow
+	err = tx.Select(
+		&rows,
+		strings.Join([]string{
+			"SELECT bucket_id, secret_access_key, secret_data_key, secret_key_id",
+			"FROM buckets",
+			where,
+			"FOR UPDATE",
+		}, "\n"),
+		args...,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	for _, row := range rows {
+		secret, err := fn(row)
+		if err != nil {
+			return 0, fmt.Errorf("bucket %d: %w", row.BucketID, err)
+		}
+		_, err = tx.Exec(
+			strings.Join([]string{
+				"UPDATE buckets SET",
+				"  secret_access_key = $1,",
+				"  secret_data_key = NULLIF($2, ''),",
+				"  secret_key_id = NULLIF($3, '')",
+				"WHERE bucket_id = $4",
+			}, "\n"),
+			secret.Ciphertext, secret.DataKey, secret.KeyID, row.BucketID,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s", ErrSQL, err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return len(rows), nil
+}
@@ -41,8 +41,11 @@ var (
 )
 
 type Bucket struct {
-	BucketID        int       `db:"bucket_id"`
-	UserID          int       `db:"user_id"`
+	BucketID int `db:"bucket_id"`
+	UserID   int `db:"user_id"`
+	// OrgID is set for buckets shared with an organization, all of its
+	// members may use them.
+	OrgID           *int      `db:"org_id"`
 	BucketName      string    `db:"bucket_name"`
 	AccessKeyID     string    `db:"access_key_id"`
 	SecretAccessKey string    `db:"secret_access_key"`
@@ -52,18 +55,80 @@ type Bucket struct {
 	UpdatedAt       time.Time `db:"updated_at"`
 }
 
+type NewBucket struct {
+	BucketName      string
+	AccessKeyID     string
+	SecretAccessKey string
+	Region          string
+	Endpoint        *string
+}
+
 type BucketsRepo interface {
 	List(userID int) ([]Bucket, error)
 	GetByID(userID, bucketID int) (*Bucket, error)
+	// GetManageable returns the bucket only when the user may change its
+	// connection settings, the same scope Update and Delete act on.
+	GetManageable(userID, bucketID int) (*Bucket, error)
 	Create(userID int, bucketName, accessKeyID, secretAccessKey, region string, endpoint *string) (int, error)
+	CreateMany(userID int, buckets []NewBucket) ([]int, error)
+	Update(userID, bucketID int, bucketName, accessKeyID, secretAccessKey, region string, endpoint *string) error
+	Delete(userID, bucketID int) error
+	// Transfer hands the bucket over to an organization the user belongs
+	// to, a nil orgID turns it back into a personal bucket of the user.
+	Transfer(userID, bucketID int, orgID *int) error
+}
+
+// bucketReadable limits a query with the user in $N to personal buckets,
+// buckets of organizations the user belongs to and buckets granted to the
+// user or to one of these organizations.
+func bucketReadable(param string) string {
+	memberOf := "(SELECT org_id FROM org_members WHERE user_id = " + param + ")"
+	return strings.Join([]string{
+		"((org_id IS NULL AND user_id = " + param + ")",
+		"  OR org_id IN " + memberOf,
+		"  OR bucket_id IN (SELECT bucket_id FROM bucket_grants WHERE user_id = " + param + " OR org_id IN " + memberOf + "))",
+	}, "\n")
+}
+
+// bucketManageable is bucketReadable narrowed to organizations where the
+// user is an owner.
+func bucketManageable(param string) string {
+	return "((org_id IS NULL AND user_id = " + param + ") OR org_id IN (SELECT org_id FROM org_members WHERE user_id = " + param + " AND role = 'owner'))"
+}
+
+// bucketRow is a buckets table row with the envelope encrypted secret.
+// Rows without secret_key_id predate encryption and hold a plain secret,
+// they are encrypted on server start and refused afterwards.
+type bucketRow struct {
+	Bucket
+	SecretDataKey sql.NullString `db:"secret_data_key"`
+	SecretKeyID   sql.NullString `db:"secret_key_id"`
 }
 
 type PgBucketsRepo struct {
-	pgsql *sqlx.DB
+	pgsql  *sqlx.DB
+	cipher SecretCipher
+}
+
+func PgBucketsRepoCtor(pgsql *sqlx.DB, cipher SecretCipher) BucketsRepo {
+	return PgBucketsRepo{pgsql, cipher}
 }
 
-func PgBucketsRepoCtor(pgsql *sqlx.DB) BucketsRepo {
-	return PgBucketsRepo{pgsql}
+func (r PgBucketsRepo) decrypt(row bucketRow) (*Bucket, error) {
+	bucket := row.Bucket
+	if !row.SecretKeyID.Valid {
+		return nil, fmt.Errorf("%w: bucket %d", ErrPlaintextSecret, bucket.BucketID)
+	}
+	secretAccessKey, err := r.cipher.Decrypt(EncryptedSecret{
+		Ciphertext: row.SecretAccessKey,
+		DataKey:    row.SecretDataKey.String,
+		KeyID:      row.SecretKeyID.String,
+	})
+	if err != nil {
+		return nil, err
+	}
+	bucket.SecretAccessKey = secretAccessKey
+	return &bucket, nil
 }
 
 func (r PgBucketsRepo) List(userID int) ([]Bucket, error) {
@@ -74,15 +139,15 @@ func (r PgBucketsRepo) List(userID int) ([]Bucket, error) {
 			"SELECT",
 			"  bucket_id,",
 			"  user_id,",
+			"  org_id,",
 			"  bucket_name,",
 			"  access_key_id,",
-			"  secret_access_key,",
 			"  region,",
 			"  endpoint,",
 			"  created_at,",
 			"  updated_at",
 			"FROM buckets",
-			"WHERE user_id = $1",
+			"WHERE " + bucketReadable("$1"),
 			"ORDER BY created_at DESC",
 		}, "\n"),
 		userID,
@@ -98,22 +163,33 @@ func (r PgBucketsRepo) List(userID int) ([]Bucket, error) {
 }
 
 func (r PgBucketsRepo) GetByID(userID, bucketID int) (*Bucket, error) {
-	var bucket Bucket
+	return r.get(bucketID, bucketReadable("$2"), userID)
+}
+
+func (r PgBucketsRepo) GetManageable(userID, bucketID int) (*Bucket, error) {
+	return r.get(bucketID, bucketManageable("$2"), userID)
+}
+
+func (r PgBucketsRepo) get(bucketID int, scope string, userID int) (*Bucket, error) {
+	var row bucketRow
 	err := r.pgsql.Get(
-		&bucket,
+		&row,
 		strings.Join([]string{
 			"SELECT",
 			"  bucket_id,",
 			"  user_id,",
+			"  org_id,",
 			"  bucket_name,",
 			"  access_key_id,",
 			"  secret_access_key,",
+			"  secret_data_key,",
+			"  secret_key_id,",
 			"  region,",
 			"  endpoint,",
 			"  created_at,",
 			"  updated_at",
 			"FROM buckets",
-			"WHERE bucket_id = $1 AND user_id = $2",
+			"WHERE bucket_id = $1 AND " + scope,
 		}, "\n"),
 		bucketID, userID,
 	)
@@ -124,18 +200,58 @@ func (r PgBucketsRepo) GetByID(userID, bucketID int) (*Bucket, error) {
 		log.Error("Error getting bucket. Err=%s\n", err)
 		return nil, fmt.Errorf("%w: %s", ErrSQL, err)
 	}
-	return &bucket, nil
+	return r.decrypt(row)
 }
 
 func (r PgBucketsRepo) Create(userID int, bucketName, accessKeyID, secretAccessKey, region string, endpoint *string) (int, error) {
+	return r.insert(r.pgsql, userID, NewBucket{
+		BucketName:      bucketName,
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+		Region:          region,
+		Endpoint:        endpoint,
+	})
+}
+
+func (r PgBucketsRepo) CreateMany(userID int, buckets []NewBucket) ([]int, error) {
+	tx, err := r.pgsql.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil && !errors.Is(rollbackErr, sql.ErrTxDone) {
+			log.Error("Error rolling back transaction. Err=%s\n", rollbackErr)
+		}
+	}()
+	bucketIDs := make([]int, 0, len(buckets))
+	for _, bucket := range buckets {
+		bucketID, err := r.insert(tx, userID, bucket)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", err, bucket.BucketName)
+		}
+		bucketIDs = append(bucketIDs, bucketID)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return bucketIDs, nil
+}
+
+func (r PgBucketsRepo) insert(queryer sqlx.Queryer, userID int, bucket NewBucket) (int, error) {
+	secret, err := r.cipher.Encrypt(bucket.SecretAccessKey)
+	if err != nil {
+		return 0, err
+	}
 	var bucketID int
-	err := r.pgsql.QueryRow(
+	err = queryer.QueryRowx(
 		strings.Join([]string{
-			"INSERT INTO buckets (user_id, bucket_name, access_key_id, secret_access_key, region, endpoint)",
-			"VALUES ($1, $2, $3, $4, $5, $6)",
+			"INSERT INTO buckets",
+			"  (user_id, bucket_name, access_key_id, secret_access_key, secret_data_key, secret_key_id, region, endpoint)",
+			"VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
 			"RETURNING bucket_id",
 		}, "\n"),
-		userID, bucketName, accessKeyID, secretAccessKey, region, endpoint,
+		userID, bucket.BucketName, bucket.AccessKeyID, secret.Ciphertext, secret.DataKey, secret.KeyID, bucket.Region, bucket.Endpoint,
 	).Scan(&bucketID)
 	if err != nil {
 		if err.Error() == "pq: duplicate key value violates unique constraint \"buckets_user_id_bucket_name_key\"" {
@@ -146,3 +262,91 @@ func (r PgBucketsRepo) Create(userID int, bucketName, accessKeyID, secretAccessK
 	}
 	return bucketID, nil
 }
+
+func (r PgBucketsRepo) Update(userID, bucketID int, bucketName, accessKeyID, secretAccessKey, region string, endpoint *string) error {
+	secret, err := r.cipher.Encrypt(secretAccessKey)
+	if err != nil {
+		return err
+	}
+	result, err := r.pgsql.Exec(
+		strings.Join([]string{
+			"UPDATE buckets SET",
+			"  bucket_name = $1,",
+			"  access_key_id = $2,",
+			"  secret_access_key = $3,",
+			"  secret_data_key = $4,",
+			"  secret_key_id = $5,",
+			"  region = $6,",
+			"  endpoint = $7,",
+			"  updated_at = CURRENT_TIMESTAMP",
+			"WHERE bucket_id = $8 AND " + bucketManageable("$9"),
+		}, "\n"),
+		bucketName, accessKeyID, secret.Ciphertext, secret.DataKey, secret.KeyID, region, endpoint, bucketID, userID,
+	)
+	if err != nil {
+		if err.Error() == "pq: duplicate key value violates unique constraint \"buckets_user_id_bucket_name_key\"" {
+			return ErrBucketNameAlreadyExists
+		}
+		log.Error("Error updating bucket. Err=%s\n", err)
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	if affected == 0 {
+		return ErrBucketNotFound
+	}
+	return nil
+}
+
+func (r PgBucketsRepo) Delete(userID, bucketID int) error {
+	result, err := r.pgsql.Exec(
+		strings.Join([]string{
+			"DELETE FROM buckets",
+			"WHERE bucket_id = $1 AND " + bucketManageable("$2"),
+		}, "\n"),
+		bucketID, userID,
+	)
+	if err != nil {
+		log.Error("Error deleting bucket. Err=%s\n", err)
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	if affected == 0 {
+		return ErrBucketNotFound
+	}
+	return nil
+}
+
+func (r PgBucketsRepo) Transfer(userID, bucketID int, orgID *int) error {
+	result, err := r.pgsql.Exec(
+		strings.Join([]string{
+			"UPDATE buckets SET",
+			"  org_id = $1,",
+			"  user_id = $2,",
+			"  updated_at = CURRENT_TIMESTAMP",
+			"WHERE bucket_id = $3 AND " + bucketManageable("$2"),
+			"  AND ($1::integer IS NULL OR $1 IN (SELECT org_id FROM org_members WHERE user_id = $2))",
+		}, "\n"),
+		orgID, userID, bucketID,
+	)
+	if err != nil {
+		if err.Error() == "pq: duplicate key value violates unique constraint \"buckets_user_id_bucket_name_key\"" {
+			return ErrBucketNameAlreadyExists
+		}
+		log.Error("Error transferring bucket. Err=%s\n", err)
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	if affected == 0 {
+		return ErrBucketNotFound
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package repo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDropLinkNotFound      = errors.New("drop link not found")
+	ErrDropLinkQuotaExceeded = errors.New("drop link quota exceeded")
+)
+
+// DropLink lets anyone holding the token upload files under Prefix
+// without seeing the bucket.
+type DropLink struct {
+	DropLinkID  int
+	UserID      int
+	BucketID    int
+	BucketName  string
+	Prefix      string
+	TokenPrefix string
+	ExpiresAt   *time.Time
+	MaxFileSize *int64
+	// AllowedExtensions are lower case with the leading dot, empty allows
+	// any file.
+	AllowedExtensions []string
+	QuotaBytes        *int64
+	UsedBytes         int64
+	CreatedAt         time.Time
+}
+
+type NewDropLink struct {
+	BucketID          int
+	Prefix            string
+	TokenHash         string
+	TokenPrefix       string
+	ExpiresAt         *time.Time
+	MaxFileSize       *int64
+	AllowedExtensions []string
+	QuotaBytes        *int64
+}
+
+type DropLinksRepo interface {
+	List(userID int) ([]DropLink, error)
+	Create(userID int, link NewDropLink) (DropLink, error)
+	Delete(userID, dropLinkID int) error
+	ByHash(tokenHash string) (DropLink, error)
+	// Reserve adds size to the used bytes or fails with
+	// ErrDropLinkQuotaExceeded when that would go over the quota.
+	Reserve(dropLinkID int, size int64) error
+	// Release gives back bytes reserved for an upload that failed.
+	Release(dropLinkID int, size int64) error
+}
+
+type PgDropLinksRepo struct {
+	pgsql *sqlx.DB
+}
+
+func PgDropLinksRepoCtor(pgsql *sqlx.DB) DropLinksRepo {
+	return PgDropLinksRepo{pgsql}
+}
+
+type dropLinkRow struct {
+	DropLinkID        int            `db:"drop_link_id"`
+	UserID            int            `db:"user_id"`
+	BucketID          int            `db:"bucket_id"`
+	BucketName        string         `db:"bucket_name"`
+	Prefix            string         `db:"prefix"`
+	TokenPrefix       string         `db:"token_prefix"`
+	ExpiresAt         sql.NullTime   `db:"expires_at"`
+	MaxFileSize       sql.NullInt64  `db:"max_file_size"`
+	AllowedExtensions pq.StringArray `db:"allowed_extensions"`
+	QuotaBytes        sql.NullInt64  `db:"quota_bytes"`
+	UsedBytes         int64          `db:"used_bytes"`
+	CreatedAt         time.Time      `db:"created_at"`
+}
+
+func (row dropLinkRow) dropLink() DropLink {
+	link := DropLink{
+		DropLinkID:        row.DropLinkID,
+		UserID:            row.UserID,
+		BucketID:          row.BucketID,
+		BucketName:        row.BucketName,
+		Prefix:            row.Prefix,
+		TokenPrefix:       row.TokenPrefix,
+		AllowedExtensions: []string(row.AllowedExtensions),
+		UsedBytes:         row.UsedBytes,
+		CreatedAt:         row.CreatedAt,
+	}
+	if link.AllowedExtensions == nil {
+		link.AllowedExtensions = []string{}
+	}
+	if row.ExpiresAt.Valid {
+		link.ExpiresAt = &row.ExpiresAt.Time
+	}
+	if row.MaxFileSize.Valid {
+		link.MaxFileSize = &row.MaxFileSize.Int64
+	}
+	if row.QuotaBytes.Valid {
+		link.QuotaBytes = &row.QuotaBytes.Int64
+	}
+	return link
+}
+
+var dropLinkColumns = strings.Join([]string{
+	"SELECT l.drop_link_id, l.user_id, l.bucket_id, b.bucket_name, l.prefix, l.token_prefix,",
+	"  l.expires_at, l.max_file_size, l.allowed_extensions, l.quota_bytes, l.used_bytes, l.created_at",
+	"FROM drop_links l",
+	"JOIN buckets b ON b.bucket_id = l.bucket_id",
+}, "\n")
+
+func (r PgDropLinksRepo) List(userID int) ([]DropLink, error) {
+	var rows []dropLinkRow
+	err := r.pgsql.Select(
+		&rows,
+		dropLinkColumns+"\nWHERE l.user_id = $1\nORDER BY l.created_at DESC",
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	links := make([]DropLink, 0, len(rows))
+	for _, row := range rows {
+		links = append(links, row.dropLink())
+	}
+	return links, nil
+}
+
+func (r PgDropLinksRepo) Create(userID int, link NewDropLink) (DropLink, error) {
+	var dropLinkID int
+	err := r.pgsql.QueryRow(
+		strings.Join([]string{
+			"INSERT INTO drop_links",
+			"  (user_id, bucket_id, prefix, token_hash, token_prefix, expires_at, max_file_size, allowed_extensions, quota_bytes)",
+			"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+			"RETURNING drop_link_id",
+		}, "\n"),
+		userID, link.BucketID, link.Prefix, link.TokenHash, link.TokenPrefix, link.ExpiresAt,
+		link.MaxFileSize, pq.StringArray(link.AllowedExtensions), link.QuotaBytes,
+	).Scan(&dropLinkID)
+	if err != nil {
+		return DropLink{}, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	var row dropLinkRow
+	err = r.pgsql.Get(&row, dropLinkColumns+"\nWHERE l.drop_link_id = $1", dropLinkID)
+	if err != nil {
+		return DropLink{}, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return row.dropLink(), nil
+}
+
+func (r PgDropLinksRepo) Delete(userID, dropLinkID int) error {
+	result, err := r.pgsql.Exec(
+		"DELETE FROM drop_links WHERE drop_link_id = $1 AND user_id = $2",
+		dropLinkID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w: %d", ErrDropLinkNotFound, dropLinkID)
+	}
+	return nil
+}
+
+func (r PgDropLinksRepo) ByHash(tokenHash string) (DropLink, error) {
+	var row dropLinkRow
+	err := r.pgsql.Get(&row, dropLinkColumns+"\nWHERE l.token_hash = $1", tokenHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DropLink{}, ErrDropLinkNotFound
+		}
+		return DropLink{}, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return row.dropLink(), nil
+}
+
+func (r PgDropLinksRepo) Reserve(dropLinkID int, size int64) error {
+	result, err := r.pgsql.Exec(
+		strings.Join([]string{
+			"UPDATE drop_links SET used_bytes = used_bytes + $2",
+			"WHERE drop_link_id = $1 AND (quota_bytes IS NULL OR used_bytes + $2 <= quota_bytes)",
+		}, "\n"),
+		dropLinkID, size,
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w: %d", ErrDropLinkQuotaExceeded, dropLinkID)
+	}
+	return nil
+}
+
+func (r PgDropLinksRepo) Release(dropLinkID int, size int64) error {
+	_, err := r.pgsql.Exec(
+		"UPDATE drop_links SET used_bytes = GREATEST(used_bytes - $2, 0) WHERE drop_link_id = $1",
+		dropLinkID, size,
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return nil
+}
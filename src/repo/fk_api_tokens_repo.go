@@ -0,0 +1,94 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package repo
+
+import (
+	"fmt"
+	"time"
+)
+
+type FkApiTokensRepo struct {
+	tokens map[string]*ApiToken
+	nextID *int
+}
+
+func FkApiTokensRepoCtor() ApiTokensRepo {
+	nextID := 1
+	return FkApiTokensRepo{map[string]*ApiToken{}, &nextID}
+}
+
+func (r FkApiTokensRepo) List(userID int) ([]ApiToken, error) {
+	tokens := []ApiToken{}
+	for _, token := range r.tokens {
+		if token.UserID == userID {
+			tokens = append(tokens, *token)
+		}
+	}
+	return tokens, nil
+}
+
+func (r FkApiTokensRepo) Create(userID int, token NewApiToken) (ApiToken, error) {
+	created := ApiToken{
+		ApiTokenID: *r.nextID,
+		UserID:     userID,
+		Username:   fmt.Sprintf("user%d", userID),
+		Name:       token.Name,
+		Prefix:     token.Prefix,
+		Scope:      token.Scope,
+		BucketIDs:  token.BucketIDs,
+		CreatedAt:  time.Now(),
+		ExpiresAt:  token.ExpiresAt,
+	}
+	*r.nextID++
+	r.tokens[token.TokenHash] = &created
+	return created, nil
+}
+
+func (r FkApiTokensRepo) Delete(userID, apiTokenID int) error {
+	for tokenHash, token := range r.tokens {
+		if token.ApiTokenID == apiTokenID && token.UserID == userID {
+			delete(r.tokens, tokenHash)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %d", ErrApiTokenNotFound, apiTokenID)
+}
+
+func (r FkApiTokensRepo) ByHash(tokenHash string) (ApiToken, error) {
+	token, ok := r.tokens[tokenHash]
+	if !ok {
+		return ApiToken{}, ErrApiTokenNotFound
+	}
+	return *token, nil
+}
+
+func (r FkApiTokensRepo) Touch(apiTokenID int, lastUsedAt time.Time) error {
+	for _, token := range r.tokens {
+		if token.ApiTokenID == apiTokenID {
+			token.LastUsedAt = &lastUsedAt
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package repo
+
+import "time"
+
+type FkAttemptStore struct {
+	attempts map[string][]time.Time
+	failures map[string]int
+	locks    map[string]time.Time
+}
+
+func FkAttemptStoreCtor() AttemptStore {
+	return FkAttemptStore{
+		map[string][]time.Time{},
+		map[string]int{},
+		map[string]time.Time{},
+	}
+}
+
+func (s FkAttemptStore) Hit(key string, limit int, window time.Duration) (time.Duration, error) {
+	now := time.Now()
+	attempts := []time.Time{}
+	for _, attempt := range s.attempts[key] {
+		if now.Sub(attempt) < window {
+			attempts = append(attempts, attempt)
+		}
+	}
+	s.attempts[key] = attempts
+	if len(attempts) >= limit {
+		return attempts[0].Add(window).Sub(now), nil
+	}
+	s.attempts[key] = append(attempts, now)
+	return 0, nil
+}
+
+func (s FkAttemptStore) AddFailure(key string, ttl time.Duration) (int, error) {
+	s.failures[key]++
+	return s.failures[key], nil
+}
+
+func (s FkAttemptStore) Lock(key string, duration, ttl time.Duration) error {
+	s.locks[key] = time.Now().Add(duration)
+	return nil
+}
+
+func (s FkAttemptStore) LockedFor(key string) (time.Duration, error) {
+	until, ok := s.locks[key]
+	if !ok || time.Now().After(until) {
+		return 0, nil
+	}
+	return time.Until(until), nil
+}
+
+func (s FkAttemptStore) Reset(key string) error {
+	delete(s.failures, key)
+	delete(s.locks, key)
+	return nil
+}
@@ -0,0 +1,97 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+package repo
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+type FkAuditRepo struct {
+	entries *[]AuditEntry
+	nextID  *int64
+}
+
+func FkAuditRepoCtor() AuditRepo {
+	nextID := int64(1)
+	return FkAuditRepo{&[]AuditEntry{}, &nextID}
+}
+
+func (r FkAuditRepo) Append(entry AuditEntry) error {
+	entry.AuditID = *r.nextID
+	*r.nextID++
+	entry.CreatedAt = time.Now()
+	*r.entries = append(*r.entries, entry)
+	return nil
+}
+
+func (r FkAuditRepo) Search(filter AuditFilter) ([]AuditEntry, error) {
+	entries := []AuditEntry{}
+	for i := len(*r.entries) - 1; i >= 0 && len(entries) < filter.Limit; i-- {
+		entry := (*r.entries)[i]
+		if fkAuditMatches(entry, filter) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func fkAuditMatches(entry AuditEntry, filter AuditFilter) bool {
+	switch {
+	case filter.UserID != nil && (entry.UserID == nil || *entry.UserID != *filter.UserID):
+		return false
+	case filter.Username != "" && (entry.Username == nil || *entry.Username != filter.Username):
+		return false
+	case filter.Action != "" && entry.Action != filter.Action:
+		return false
+	case filter.BucketID != nil && (entry.BucketID == nil || *entry.BucketID != *filter.BucketID):
+		return false
+	case filter.KeyPrefix != "" && (entry.Key == nil || !strings.HasPrefix(*entry.Key, filter.KeyPrefix)):
+		return false
+	case filter.Result != "" && entry.Result != filter.Result:
+		return false
+	case filter.From != nil && entry.CreatedAt.Before(*filter.From):
+		return false
+	case filter.To != nil && !entry.CreatedAt.Before(*filter.To):
+		return false
+	case filter.BeforeID > 0 && entry.AuditID >= filter.BeforeID:
+		return false
+	}
+	if filter.Visible == nil {
+		return true
+	}
+	if entry.UserID != nil && *entry.UserID == filter.Visible.UserID {
+		return true
+	}
+	return entry.BucketID != nil && slices.Contains(filter.Visible.BucketIDs, *entry.BucketID)
+}
+
+func (r FkAuditRepo) Prune(retention time.Duration) (int64, error) {
+	before := len(*r.entries)
+	*r.entries = slices.DeleteFunc(*r.entries, func(entry AuditEntry) bool {
+		return time.Since(entry.CreatedAt) > retention
+	})
+	return int64(before - len(*r.entries)), nil
+}
@@ -0,0 +1,105 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package repo
+
+import (
+	"fmt"
+	"time"
+)
+
+type FkBucketGrantsRepo struct {
+	users  map[string]int
+	orgs   OrgsRepo
+	grants map[int]BucketGrant
+	nextID *int
+}
+
+// FkBucketGrantsRepoCtor keeps grants in memory, users maps the usernames
+// grants can be given to and orgs resolves organization memberships.
+func FkBucketGrantsRepoCtor(users map[string]int, orgs OrgsRepo) BucketGrantsRepo {
+	nextID := 1
+	return FkBucketGrantsRepo{users, orgs, map[int]BucketGrant{}, &nextID}
+}
+
+func (r FkBucketGrantsRepo) List(bucketID int) ([]BucketGrant, error) {
+	grants := []BucketGrant{}
+	for _, grant := range r.grants {
+		if grant.BucketID == bucketID {
+			grants = append(grants, grant)
+		}
+	}
+	return grants, nil
+}
+
+func (r FkBucketGrantsRepo) ForUser(bucketID, userID int) ([]BucketGrant, error) {
+	grants := []BucketGrant{}
+	for _, grant := range r.grants {
+		if grant.BucketID != bucketID {
+			continue
+		}
+		if grant.UserID != nil && *grant.UserID == userID {
+			grants = append(grants, grant)
+			continue
+		}
+		if grant.OrgID != nil {
+			if _, err := r.orgs.Role(*grant.OrgID, userID); err == nil {
+				grants = append(grants, grant)
+			}
+		}
+	}
+	return grants, nil
+}
+
+func (r FkBucketGrantsRepo) Create(bucketID int, grant NewBucketGrant) (BucketGrant, error) {
+	created := BucketGrant{
+		BucketGrantID: *r.nextID,
+		BucketID:      bucketID,
+		OrgID:         grant.OrgID,
+		Role:          grant.Role,
+		Prefix:        grant.Prefix,
+		CreatedAt:     time.Now(),
+	}
+	if grant.OrgID == nil {
+		userID, ok := r.users[grant.Username]
+		if !ok {
+			return BucketGrant{}, fmt.Errorf("%w: %s", ErrUserNotFound, grant.Username)
+		}
+		username := grant.Username
+		created.UserID = &userID
+		created.Username = &username
+	}
+	*r.nextID++
+	r.grants[created.BucketGrantID] = created
+	return created, nil
+}
+
+func (r FkBucketGrantsRepo) Delete(bucketID, bucketGrantID int) error {
+	grant, ok := r.grants[bucketGrantID]
+	if !ok || grant.BucketID != bucketID {
+		return fmt.Errorf("%w: %d", ErrBucketGrantNotFound, bucketGrantID)
+	}
+	delete(r.grants, bucketGrantID)
+	return nil
+}
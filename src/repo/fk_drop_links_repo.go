@@ -0,0 +1,117 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package repo
+
+import (
+	"fmt"
+	"time"
+)
+
+type FkDropLinksRepo struct {
+	links  map[string]*DropLink
+	nextID *int
+}
+
+func FkDropLinksRepoCtor() DropLinksRepo {
+	nextID := 1
+	return FkDropLinksRepo{map[string]*DropLink{}, &nextID}
+}
+
+func (r FkDropLinksRepo) byID(dropLinkID int) (*DropLink, error) {
+	for _, link := range r.links {
+		if link.DropLinkID == dropLinkID {
+			return link, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %d", ErrDropLinkNotFound, dropLinkID)
+}
+
+func (r FkDropLinksRepo) List(userID int) ([]DropLink, error) {
+	links := []DropLink{}
+	for _, link := range r.links {
+		if link.UserID == userID {
+			links = append(links, *link)
+		}
+	}
+	return links, nil
+}
+
+func (r FkDropLinksRepo) Create(userID int, link NewDropLink) (DropLink, error) {
+	created := DropLink{
+		DropLinkID:        *r.nextID,
+		UserID:            userID,
+		BucketID:          link.BucketID,
+		BucketName:        fmt.Sprintf("bucket%d", link.BucketID),
+		Prefix:            link.Prefix,
+		TokenPrefix:       link.TokenPrefix,
+		ExpiresAt:         link.ExpiresAt,
+		MaxFileSize:       link.MaxFileSize,
+		AllowedExtensions: link.AllowedExtensions,
+		QuotaBytes:        link.QuotaBytes,
+		CreatedAt:         time.Now(),
+	}
+	*r.nextID++
+	r.links[link.TokenHash] = &created
+	return created, nil
+}
+
+func (r FkDropLinksRepo) Delete(userID, dropLinkID int) error {
+	for tokenHash, link := range r.links {
+		if link.DropLinkID == dropLinkID && link.UserID == userID {
+			delete(r.links, tokenHash)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %d", ErrDropLinkNotFound, dropLinkID)
+}
+
+func (r FkDropLinksRepo) ByHash(tokenHash string) (DropLink, error) {
+	link, ok := r.links[tokenHash]
+	if !ok {
+		return DropLink{}, ErrDropLinkNotFound
+	}
+	return *link, nil
+}
+
+func (r FkDropLinksRepo) Reserve(dropLinkID int, size int64) error {
+	link, err := r.byID(dropLinkID)
+	if err != nil {
+		return err
+	}
+	if link.QuotaBytes != nil && link.UsedBytes+size > *link.QuotaBytes {
+		return fmt.Errorf("%w: %d", ErrDropLinkQuotaExceeded, dropLinkID)
+	}
+	link.UsedBytes += size
+	return nil
+}
+
+func (r FkDropLinksRepo) Release(dropLinkID int, size int64) error {
+	link, err := r.byID(dropLinkID)
+	if err != nil {
+		return err
+	}
+	link.UsedBytes = max(link.UsedBytes-size, 0)
+	return nil
+}
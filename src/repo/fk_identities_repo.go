@@ -0,0 +1,70 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package repo
+
+import "fmt"
+
+type fkIdentity struct {
+	userID   int
+	username string
+}
+
+type FkIdentitiesRepo struct {
+	identities map[string]fkIdentity
+	nextUserID *int
+}
+
+func FkIdentitiesRepoCtor() IdentitiesRepo {
+	nextUserID := 1
+	return FkIdentitiesRepo{map[string]fkIdentity{}, &nextUserID}
+}
+
+func (r FkIdentitiesRepo) User(issuer, subject string) (int, string, error) {
+	identity, ok := r.identities[issuer+" "+subject]
+	if !ok {
+		return 0, "", fmt.Errorf("%w: %s", ErrIdentityNotFound, subject)
+	}
+	return identity.userID, identity.username, nil
+}
+
+func (r FkIdentitiesRepo) Link(userID int, issuer, subject string) error {
+	if _, ok := r.identities[issuer+" "+subject]; ok {
+		return fmt.Errorf("%w: %s", ErrIdentityAlreadyLinked, subject)
+	}
+	r.identities[issuer+" "+subject] = fkIdentity{userID, fmt.Sprintf("user%d", userID)}
+	return nil
+}
+
+func (r FkIdentitiesRepo) Provision(issuer, subject, username string) (int, error) {
+	for _, identity := range r.identities {
+		if identity.username == username {
+			return 0, ErrUsernameAlreadyExist
+		}
+	}
+	userID := *r.nextUserID
+	*r.nextUserID++
+	r.identities[issuer+" "+subject] = fkIdentity{userID, username}
+	return userID, nil
+}
@@ -0,0 +1,98 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package repo
+
+import "fmt"
+
+type FkMfaRepo struct {
+	enrollments   map[int]*TotpEnrollment
+	recoveryCodes map[int]map[string]bool
+}
+
+func FkMfaRepoCtor() MfaRepo {
+	return FkMfaRepo{map[int]*TotpEnrollment{}, map[int]map[string]bool{}}
+}
+
+func (r FkMfaRepo) Totp(userID int) (TotpEnrollment, error) {
+	enrollment, ok := r.enrollments[userID]
+	if !ok {
+		return TotpEnrollment{}, fmt.Errorf("%w: %d", ErrTotpNotEnrolled, userID)
+	}
+	return *enrollment, nil
+}
+
+func (r FkMfaRepo) SaveTotp(userID int, secret string) error {
+	enrollment, ok := r.enrollments[userID]
+	if ok && enrollment.Confirmed {
+		return nil
+	}
+	r.enrollments[userID] = &TotpEnrollment{UserID: userID, Secret: secret}
+	return nil
+}
+
+func (r FkMfaRepo) ConfirmTotp(userID int, recoveryCodeHashes []string) error {
+	enrollment, ok := r.enrollments[userID]
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrTotpNotEnrolled, userID)
+	}
+	enrollment.Confirmed = true
+	return r.ReplaceRecoveryCodes(userID, recoveryCodeHashes)
+}
+
+func (r FkMfaRepo) UseTotpStep(userID int, step int64) (bool, error) {
+	enrollment, ok := r.enrollments[userID]
+	if !ok || enrollment.LastUsedStep >= step {
+		return false, nil
+	}
+	enrollment.LastUsedStep = step
+	return true, nil
+}
+
+func (r FkMfaRepo) ReplaceRecoveryCodes(userID int, recoveryCodeHashes []string) error {
+	codes := map[string]bool{}
+	for _, codeHash := range recoveryCodeHashes {
+		codes[codeHash] = true
+	}
+	r.recoveryCodes[userID] = codes
+	return nil
+}
+
+func (r FkMfaRepo) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	if !r.recoveryCodes[userID][codeHash] {
+		return false, nil
+	}
+	delete(r.recoveryCodes[userID], codeHash)
+	return true, nil
+}
+
+func (r FkMfaRepo) DeleteTotp(userID int) error {
+	delete(r.enrollments, userID)
+	delete(r.recoveryCodes, userID)
+	return nil
+}
+
+func (r FkMfaRepo) RewrapSecrets() (int, error) {
+	return 0, nil
+}
@@ -0,0 +1,188 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package repo
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+type FkOrgsRepo struct {
+	users   map[string]int
+	orgs    map[int]string
+	members map[int]map[int]string
+	invites map[int]OrgInvite
+	nextID  *int
+}
+
+// FkOrgsRepoCtor keeps organizations in memory, users maps the usernames
+// that can be invited to their ids.
+func FkOrgsRepoCtor(users map[string]int) OrgsRepo {
+	nextID := 1
+	return FkOrgsRepo{users, map[int]string{}, map[int]map[int]string{}, map[int]OrgInvite{}, &nextID}
+}
+
+func (r FkOrgsRepo) id() int {
+	id := *r.nextID
+	*r.nextID++
+	return id
+}
+
+func (r FkOrgsRepo) username(userID int) string {
+	for username, id := range r.users {
+		if id == userID {
+			return username
+		}
+	}
+	return fmt.Sprintf("user%d", userID)
+}
+
+func (r FkOrgsRepo) Create(userID int, name string) (int, error) {
+	orgID := r.id()
+	r.orgs[orgID] = name
+	r.members[orgID] = map[int]string{userID: OrgRoleOwner}
+	return orgID, nil
+}
+
+func (r FkOrgsRepo) List(userID int) ([]Org, error) {
+	orgs := []Org{}
+	for orgID, name := range r.orgs {
+		if role, ok := r.members[orgID][userID]; ok {
+			orgs = append(orgs, Org{OrgID: orgID, Name: name, Role: role, CreatedAt: time.Now()})
+		}
+	}
+	sort.Slice(orgs, func(i, j int) bool { return orgs[i].Name < orgs[j].Name })
+	return orgs, nil
+}
+
+func (r FkOrgsRepo) Role(orgID, userID int) (string, error) {
+	role, ok := r.members[orgID][userID]
+	if !ok {
+		return "", fmt.Errorf("%w: %d", ErrOrgNotFound, orgID)
+	}
+	return role, nil
+}
+
+func (r FkOrgsRepo) Members(orgID int) ([]OrgMember, error) {
+	members := []OrgMember{}
+	for userID, role := range r.members[orgID] {
+		members = append(members, OrgMember{UserID: userID, Username: r.username(userID), Role: role, CreatedAt: time.Now()})
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Username < members[j].Username })
+	return members, nil
+}
+
+func (r FkOrgsRepo) SetRole(orgID, userID int, role string) error {
+	if _, ok := r.members[orgID][userID]; !ok {
+		return fmt.Errorf("%w: %d", ErrUserNotFound, userID)
+	}
+	r.members[orgID][userID] = role
+	return nil
+}
+
+func (r FkOrgsRepo) RemoveMember(orgID, userID int) error {
+	role, ok := r.members[orgID][userID]
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrUserNotFound, userID)
+	}
+	if role == OrgRoleOwner {
+		owners := 0
+		for _, memberRole := range r.members[orgID] {
+			if memberRole == OrgRoleOwner {
+				owners++
+			}
+		}
+		if owners <= 1 {
+			return ErrLastOrgOwner
+		}
+	}
+	delete(r.members[orgID], userID)
+	return nil
+}
+
+func (r FkOrgsRepo) Delete(orgID int) error {
+	delete(r.orgs, orgID)
+	delete(r.members, orgID)
+	for orgInviteID, invite := range r.invites {
+		if invite.OrgID == orgID {
+			delete(r.invites, orgInviteID)
+		}
+	}
+	return nil
+}
+
+func (r FkOrgsRepo) Invite(orgID, invitedBy int, username string) (OrgInvite, error) {
+	userID, ok := r.users[username]
+	if !ok {
+		return OrgInvite{}, fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+	if _, ok := r.members[orgID][userID]; ok {
+		return OrgInvite{}, fmt.Errorf("%w: %s", ErrOrgMemberExists, username)
+	}
+	inviter := r.username(invitedBy)
+	invite := OrgInvite{
+		OrgInviteID: r.id(),
+		OrgID:       orgID,
+		OrgName:     r.orgs[orgID],
+		UserID:      userID,
+		Username:    username,
+		InvitedBy:   &inviter,
+		CreatedAt:   time.Now(),
+	}
+	r.invites[invite.OrgInviteID] = invite
+	return invite, nil
+}
+
+func (r FkOrgsRepo) Invites(userID int) ([]OrgInvite, error) {
+	invites := []OrgInvite{}
+	for _, invite := range r.invites {
+		if invite.UserID == userID {
+			invites = append(invites, invite)
+		}
+	}
+	return invites, nil
+}
+
+func (r FkOrgsRepo) AcceptInvite(userID, orgInviteID int) (int, error) {
+	invite, ok := r.invites[orgInviteID]
+	if !ok || invite.UserID != userID {
+		return 0, fmt.Errorf("%w: %d", ErrOrgInviteNotFound, orgInviteID)
+	}
+	delete(r.invites, orgInviteID)
+	if _, ok := r.members[invite.OrgID][userID]; !ok {
+		r.members[invite.OrgID][userID] = OrgRoleMember
+	}
+	return invite.OrgID, nil
+}
+
+func (r FkOrgsRepo) DeleteInvite(userID, orgInviteID int) error {
+	invite, ok := r.invites[orgInviteID]
+	if !ok || invite.UserID != userID {
+		return fmt.Errorf("%w: %d", ErrOrgInviteNotFound, orgInviteID)
+	}
+	delete(r.invites, orgInviteID)
+	return nil
+}
@@ -0,0 +1,100 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package repo
+
+import (
+	"fmt"
+	"time"
+)
+
+type FkShareLinksRepo struct {
+	links  map[string]*ShareLink
+	nextID *int
+}
+
+func FkShareLinksRepoCtor() ShareLinksRepo {
+	nextID := 1
+	return FkShareLinksRepo{map[string]*ShareLink{}, &nextID}
+}
+
+func (r FkShareLinksRepo) List(userID int) ([]ShareLink, error) {
+	links := []ShareLink{}
+	for _, link := range r.links {
+		if link.UserID == userID {
+			links = append(links, *link)
+		}
+	}
+	return links, nil
+}
+
+func (r FkShareLinksRepo) Create(userID int, link NewShareLink) (ShareLink, error) {
+	created := ShareLink{
+		ShareLinkID:  *r.nextID,
+		UserID:       userID,
+		BucketID:     link.BucketID,
+		BucketName:   fmt.Sprintf("bucket%d", link.BucketID),
+		Key:          link.Key,
+		Prefix:       link.Prefix,
+		PasswordHash: link.PasswordHash,
+		ExpiresAt:    link.ExpiresAt,
+		MaxDownloads: link.MaxDownloads,
+		CreatedAt:    time.Now(),
+	}
+	*r.nextID++
+	r.links[link.TokenHash] = &created
+	return created, nil
+}
+
+func (r FkShareLinksRepo) Delete(userID, shareLinkID int) error {
+	for tokenHash, link := range r.links {
+		if link.ShareLinkID == shareLinkID && link.UserID == userID {
+			delete(r.links, tokenHash)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %d", ErrShareLinkNotFound, shareLinkID)
+}
+
+func (r FkShareLinksRepo) ByHash(tokenHash string) (ShareLink, error) {
+	link, ok := r.links[tokenHash]
+	if !ok {
+		return ShareLink{}, ErrShareLinkNotFound
+	}
+	return *link, nil
+}
+
+func (r FkShareLinksRepo) CountDownload(shareLinkID int) error {
+	for _, link := range r.links {
+		if link.ShareLinkID != shareLinkID {
+			continue
+		}
+		if link.MaxDownloads != nil && link.Downloads >= *link.MaxDownloads {
+			return fmt.Errorf("%w: %d", ErrShareLinkExhausted, shareLinkID)
+		}
+		link.Downloads++
+		return nil
+	}
+	return fmt.Errorf("%w: %d", ErrShareLinkNotFound, shareLinkID)
+}
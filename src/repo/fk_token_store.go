@@ -0,0 +1,101 @@
+package repo
+
+import "time"
+
+type fkSession struct {
+	session    Session
+	refreshJti string
+}
+
+type FkTokenStore struct {
+	denied   map[string]bool
+	sessions map[string]*fkSession
+}
+
+func FkTokenStoreCtor() TokenStore {
+	return FkTokenStore{
+		denied:   map[string]bool{},
+		sessions: map[string]*fkSession{},
+	}
+}
+
+func (s FkTokenStore) Deny(jti string, ttl time.Duration) error {
+	s.denied[jti] = true
+	return nil
+}
+
+func (s FkTokenStore) IsDenied(jti string) (bool, error) {
+	return s.denied[jti], nil
+}
+
+func (s FkTokenStore) Consume(jti string, ttl time.Duration) (bool, error) {
+	if s.denied[jti] {
+		return false, nil
+	}
+	s.denied[jti] = true
+	return true, nil
+}
+
+func (s FkTokenStore) CreateSession(session Session, refreshJti string, ttl time.Duration) error {
+	s.sessions[session.SessionID] = &fkSession{session, refreshJti}
+	return nil
+}
+
+func (s FkTokenStore) RotateSession(userID int, sessionID, currentJti, refreshJti string, ttl time.Duration) error {
+	session, ok := s.sessions[sessionID]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	if session.refreshJti != currentJti {
+		return ErrRefreshReused
+	}
+	session.refreshJti = refreshJti
+	return nil
+}
+
+func (s FkTokenStore) TouchSession(sessionID string, lastUsedAt time.Time, ip, userAgent string) error {
+	session, ok := s.sessions[sessionID]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	session.session.LastUsedAt = lastUsedAt
+	session.session.IP = ip
+	session.session.UserAgent = userAgent
+	return nil
+}
+
+func (s FkTokenStore) RefreshJti(sessionID string) (string, error) {
+	session, ok := s.sessions[sessionID]
+	if !ok {
+		return "", ErrSessionNotFound
+	}
+	return session.refreshJti, nil
+}
+
+func (s FkTokenStore) Sessions(userID int) ([]Session, error) {
+	sessions := []Session{}
+	for _, session := range s.sessions {
+		if session.session.UserID == userID {
+			sessions = append(sessions, session.session)
+		}
+	}
+	return sessions, nil
+}
+
+func (s FkTokenStore) DeleteSession(userID int, sessionID string) error {
+	session, ok := s.sessions[sessionID]
+	if !ok || session.session.UserID != userID {
+		return ErrSessionNotFound
+	}
+	delete(s.sessions, sessionID)
+	return nil
+}
+
+func (s FkTokenStore) DeleteUserSessions(userID int) error {
+	for sessionID, session := range s.sessions {
+		if session.session.UserID == userID {
+			delete(s.sessions, sessionID)
+		}
+	}
+	return nil
+}
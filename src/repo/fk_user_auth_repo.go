@@ -6,13 +6,18 @@ type FkUserAuthRepo struct {
 }
 
 func FkUserAuthRepoCtor(UserId int, PasswordHash string) UserAuthRepo {
-	return FkUserAuthRepo{UserId, PasswordHash}
+	return &FkUserAuthRepo{UserId, PasswordHash}
 }
 
-func (repo FkUserAuthRepo) UserId(username string) (int, error) {
+func (repo *FkUserAuthRepo) UserId(username string) (int, error) {
 	return repo.userId, nil
 }
 
-func (repo FkUserAuthRepo) PasswordHash(username string) (string, error) {
+func (repo *FkUserAuthRepo) PasswordHash(username string) (string, error) {
 	return repo.passwordHash, nil
 }
+
+func (repo *FkUserAuthRepo) UpdatePasswordHash(userID int, passwordHash string) error {
+	repo.passwordHash = passwordHash
+	return nil
+}
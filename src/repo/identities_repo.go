@@ -0,0 +1,138 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package repo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrIdentityNotFound      = errors.New("identity not found")
+	ErrIdentityAlreadyLinked = errors.New("identity already linked")
+)
+
+// UnusablePasswordHash is the password hash of users created by single
+// sign-on or LDAP, bcrypt never matches it.
+const UnusablePasswordHash = "!"
+
+// IdentitiesRepo links accounts of external identity providers to users.
+type IdentitiesRepo interface {
+	User(issuer, subject string) (int, string, error)
+	Provision(issuer, subject, username string) (int, error)
+	// Link attaches the identity to an existing user.
+	Link(userID int, issuer, subject string) error
+}
+
+type PgIdentitiesRepo struct {
+	pgsql *sqlx.DB
+}
+
+func PgIdentitiesRepoCtor(pgsql *sqlx.DB) IdentitiesRepo {
+	return PgIdentitiesRepo{pgsql}
+}
+
+func (r PgIdentitiesRepo) User(issuer, subject string) (int, string, error) {
+	user := struct {
+		UserID   int    `db:"user_id"`
+		Username string `db:"username"`
+	}{}
+	err := r.pgsql.Get(
+		&user,
+		strings.Join([]string{
+			"SELECT u.user_id, u.username",
+			"FROM user_identities i",
+			"JOIN users u ON u.user_id = i.user_id",
+			"WHERE i.issuer = $1 AND i.subject = $2",
+		}, "\n"),
+		issuer, subject,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, "", fmt.Errorf("%w: %s", ErrIdentityNotFound, subject)
+		}
+		return 0, "", fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return user.UserID, user.Username, nil
+}
+
+func (r PgIdentitiesRepo) Link(userID int, issuer, subject string) error {
+	_, err := r.pgsql.Exec(
+		"INSERT INTO user_identities (user_id, issuer, subject) VALUES ($1, $2, $3)",
+		userID, issuer, subject,
+	)
+	if err != nil {
+		if err.Error() == "pq: duplicate key value violates unique constraint \"user_identities_issuer_subject_key\"" {
+			return fmt.Errorf("%w: %s", ErrIdentityAlreadyLinked, subject)
+		}
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return nil
+}
+
+// Provision creates a user without a password and links the identity to it.
+func (r PgIdentitiesRepo) Provision(issuer, subject, username string) (int, error) {
+	tx, err := r.pgsql.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil && !errors.Is(rollbackErr, sql.ErrTxDone) {
+			log.Error("Error rolling back transaction. Err=%s\n", rollbackErr)
+		}
+	}()
+	var userID int
+	err = tx.QueryRow(
+		strings.Join([]string{
+			"INSERT INTO users (username, password_hash)",
+			"VALUES ($1, $2)",
+			"RETURNING user_id",
+		}, "\n"),
+		username, UnusablePasswordHash,
+	).Scan(&userID)
+	if err != nil {
+		if err.Error() == "pq: duplicate key value violates unique constraint \"users_username_key\"" {
+			return 0, ErrUsernameAlreadyExist
+		}
+		return 0, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	_, err = tx.Exec(
+		"INSERT INTO user_identities (user_id, issuer, subject) VALUES ($1, $2, $3)",
+		userID, issuer, subject,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return userID, nil
+}
@@ -0,0 +1,329 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package repo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/jmoiron/sqlx"
+)
+
+var ErrTotpNotEnrolled = errors.New("totp not enrolled")
+
+type TotpEnrollment struct {
+	UserID    int
+	Secret    string
+	Confirmed bool
+	// LastUsedStep is the latest accepted time step, codes of this or an
+	// earlier step are rejected to prevent replay.
+	LastUsedStep int64
+}
+
+type MfaRepo interface {
+	Totp(userID int) (TotpEnrollment, error)
+	SaveTotp(userID int, secret string) error
+	ConfirmTotp(userID int, recoveryCodeHashes []string) error
+	UseTotpStep(userID int, step int64) (bool, error)
+	ReplaceRecoveryCodes(userID int, recoveryCodeHashes []string) error
+	UseRecoveryCode(userID int, codeHash string) (bool, error)
+	DeleteTotp(userID int) error
+	RewrapSecrets() (int, error)
+}
+
+type PgMfaRepo struct {
+	pgsql  *sqlx.DB
+	cipher SecretCipher
+}
+
+func PgMfaRepoCtor(pgsql *sqlx.DB, cipher SecretCipher) MfaRepo {
+	return PgMfaRepo{pgsql, cipher}
+}
+
+type totpRow struct {
+	UserID        int          `db:"user_id"`
+	Secret        string       `db:"secret"`
+	SecretDataKey string       `db:"secret_data_key"`
+	SecretKeyID   string       `db:"secret_key_id"`
+	ConfirmedAt   sql.NullTime `db:"confirmed_at"`
+	LastUsedStep  int64        `db:"last_used_step"`
+}
+
+func (r PgMfaRepo) Totp(userID int) (TotpEnrollment, error) {
+	var row totpRow
+	err := r.pgsql.Get(
+		&row,
+		strings.Join([]string{
+			"SELECT user_id, secret, secret_data_key, secret_key_id, confirmed_at, last_used_step",
+			"FROM user_totp",
+			"WHERE user_id = $1",
+		}, "\n"),
+		userID,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return TotpEnrollment{}, fmt.Errorf("%w: %d", ErrTotpNotEnrolled, userID)
+		}
+		return TotpEnrollment{}, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	secret, err := r.cipher.Decrypt(EncryptedSecret{
+		Ciphertext: row.Secret,
+		DataKey:    row.SecretDataKey,
+		KeyID:      row.SecretKeyID,
+	})
+	if err != nil {
+		return TotpEnrollment{}, err
+	}
+	return TotpEnrollment{
+		UserID:       row.UserID,
+		Secret:       secret,
+		Confirmed:    row.ConfirmedAt.Valid,
+		LastUsedStep: row.LastUsedStep,
+	}, nil
+}
+
+// SaveTotp stores a new unconfirmed secret, replacing a previous
+// unconfirmed one. A confirmed enrollment is left untouched.
+func (r PgMfaRepo) SaveTotp(userID int, secret string) error {
+	encrypted, err := r.cipher.Encrypt(secret)
+	if err != nil {
+		return err
+	}
+	_, err = r.pgsql.Exec(
+		strings.Join([]string{
+			"INSERT INTO user_totp (user_id, secret, secret_data_key, secret_key_id)",
+			"VALUES ($1, $2, $3, $4)",
+			"ON CONFLICT (user_id) DO UPDATE SET",
+			"  secret = EXCLUDED.secret,",
+			"  secret_data_key = EXCLUDED.secret_data_key,",
+			"  secret_key_id = EXCLUDED.secret_key_id,",
+			"  last_used_step = 0,",
+			"  created_at = CURRENT_TIMESTAMP",
+			"WHERE user_totp.confirmed_at IS NULL",
+		}, "\n"),
+		userID, encrypted.Ciphertext, encrypted.DataKey, encrypted.KeyID,
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return nil
+}
+
+func (r PgMfaRepo) ConfirmTotp(userID int, recoveryCodeHashes []string) error {
+	tx, err := r.pgsql.Beginx()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil && !errors.Is(rollbackErr, sql.ErrTxDone) {
+			log.Error("Error rolling back transaction. Err=%s\n", rollbackErr)
+		}
+	}()
+	result, err := tx.Exec(
+		"UPDATE user_totp SET confirmed_at = CURRENT_TIMESTAMP WHERE user_id = $1",
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w: %d", ErrTotpNotEnrolled, userID)
+	}
+	err = replaceRecoveryCodes(tx, userID, recoveryCodeHashes)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return nil
+}
+
+// UseTotpStep records the step of an accepted code. It returns false when
+// a code of this or a later step has already been used.
+func (r PgMfaRepo) UseTotpStep(userID int, step int64) (bool, error) {
+	result, err := r.pgsql.Exec(
+		strings.Join([]string{
+			"UPDATE user_totp SET last_used_step = $2",
+			"WHERE user_id = $1 AND last_used_step < $2",
+		}, "\n"),
+		userID, step,
+	)
+	if err != nil {
+		return false, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return affected > 0, nil
+}
+
+func (r PgMfaRepo) ReplaceRecoveryCodes(userID int, recoveryCodeHashes []string) error {
+	tx, err := r.pgsql.Beginx()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil && !errors.Is(rollbackErr, sql.ErrTxDone) {
+			log.Error("Error rolling back transaction. Err=%s\n", rollbackErr)
+		}
+	}()
+	err = replaceRecoveryCodes(tx, userID, recoveryCodeHashes)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return nil
+}
+
+func replaceRecoveryCodes(tx *sqlx.Tx, userID int, recoveryCodeHashes []string) error {
+	_, err := tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	for _, codeHash := range recoveryCodeHashes {
+		_, err = tx.Exec(
+			"INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)",
+			userID, codeHash,
+		)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrSQL, err)
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode burns an unused recovery code, false means there was no
+// such code left.
+func (r PgMfaRepo) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	result, err := r.pgsql.Exec(
+		strings.Join([]string{
+			"UPDATE user_recovery_codes SET used_at = CURRENT_TIMESTAMP",
+			"WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL",
+		}, "\n"),
+		userID, codeHash,
+	)
+	if err != nil {
+		return false, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return affected > 0, nil
+}
+
+func (r PgMfaRepo) DeleteTotp(userID int) error {
+	tx, err := r.pgsql.Beginx()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil && !errors.Is(rollbackErr, sql.ErrTxDone) {
+			log.Error("Error rolling back transaction. Err=%s\n", rollbackErr)
+		}
+	}()
+	_, err = tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	_, err = tx.Exec("DELETE FROM user_totp WHERE user_id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return nil
+}
+
+// RewrapSecrets seals every TOTP data key not owned by the active master
+// key with it.
+func (r PgMfaRepo) RewrapSecrets() (int, error) {
+	tx, err := r.pgsql.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil && !errors.Is(rollbackErr, sql.ErrTxDone) {
+			log.Error("Error rolling back transaction. Err=%s\n", rollbackErr)
+		}
+	}()
+	var rows []totpRow
+	err = tx.Select(
+		&rows,
+		strings.Join([]string{
+			"SELECT user_id, secret, secret_data_key, secret_key_id, confirmed_at, last_used_step",
+			"FROM user_totp",
+			"WHERE secret_key_id <> $1",
+			"FOR UPDATE",
+		}, "\n"),
+		r.cipher.ActiveKeyID(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	for _, row := range rows {
+		secret, err := r.cipher.Rewrap(EncryptedSecret{
+			Ciphertext: row.Secret,
+			DataKey:    row.SecretDataKey,
+			KeyID:      row.SecretKeyID,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("totp of user %d: %w", row.UserID, err)
+		}
+		_, err = tx.Exec(
+			strings.Join([]string{
+				"UPDATE user_totp SET",
+				"  secret = $1,",
+				"  secret_data_key = $2,",
+				"  secret_key_id = $3",
+				"WHERE user_id = $4",
+			}, "\n"),
+			secret.Ciphertext, secret.DataKey, secret.KeyID, row.UserID,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s", ErrSQL, err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return len(rows), nil
+}
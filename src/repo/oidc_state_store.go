@@ -0,0 +1,93 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+var ErrOidcStateNotFound = errors.New("oidc state not found")
+
+// OidcState is what the login request has to remember until the identity
+// provider redirects back with the same state.
+type OidcState struct {
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+	// LinkUserID is set when a logged in user links the identity to the
+	// account instead of logging in with it.
+	LinkUserID int `json:"link_user_id,omitempty"`
+}
+
+type OidcStateStore interface {
+	Save(state string, oidcState OidcState, ttl time.Duration) error
+	// Take returns the state and forgets it, every state is single use.
+	Take(state string) (OidcState, error)
+}
+
+type RedisOidcStateStore struct {
+	rdb *redis.Client
+}
+
+func RedisOidcStateStoreCtor(rdb *redis.Client) OidcStateStore {
+	return RedisOidcStateStore{rdb}
+}
+
+func oidcStateKey(state string) string {
+	return "auth:oidc-state:" + state
+}
+
+func (s RedisOidcStateStore) Save(state string, oidcState OidcState, ttl time.Duration) error {
+	encoded, err := json.Marshal(oidcState)
+	if err != nil {
+		return err
+	}
+	err = s.rdb.Set(context.Background(), oidcStateKey(state), encoded, ttl).Err()
+	if err != nil {
+		return fmt.Errorf("save oidc state: %w", err)
+	}
+	return nil
+}
+
+func (s RedisOidcStateStore) Take(state string) (OidcState, error) {
+	encoded, err := s.rdb.GetDel(context.Background(), oidcStateKey(state)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return OidcState{}, ErrOidcStateNotFound
+		}
+		return OidcState{}, fmt.Errorf("take oidc state: %w", err)
+	}
+	var oidcState OidcState
+	err = json.Unmarshal(encoded, &oidcState)
+	if err != nil {
+		return OidcState{}, fmt.Errorf("decode oidc state: %w", err)
+	}
+	return oidcState, nil
+}
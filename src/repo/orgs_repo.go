@@ -0,0 +1,370 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package repo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrOrgNotFound       = errors.New("organization not found")
+	ErrOrgInviteNotFound = errors.New("organization invite not found")
+	ErrOrgMemberExists   = errors.New("user is already a member of the organization")
+	ErrLastOrgOwner      = errors.New("organization must keep at least one owner")
+)
+
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleMember = "member"
+)
+
+type Org struct {
+	OrgID     int       `db:"org_id"`
+	Name      string    `db:"name"`
+	Role      string    `db:"role"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type OrgMember struct {
+	UserID    int       `db:"user_id"`
+	Username  string    `db:"username"`
+	Role      string    `db:"role"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type OrgInvite struct {
+	OrgInviteID int       `db:"org_invite_id"`
+	OrgID       int       `db:"org_id"`
+	OrgName     string    `db:"org_name"`
+	UserID      int       `db:"user_id"`
+	Username    string    `db:"username"`
+	InvitedBy   *string   `db:"invited_by"`
+	CreatedAt   time.Time `db:"created_at"`
+}
+
+type OrgsRepo interface {
+	// Create makes an organization with the user as its owner.
+	Create(userID int, name string) (int, error)
+	List(userID int) ([]Org, error)
+	// Role returns the role of the user in the organization or
+	// ErrOrgNotFound when the user is not a member.
+	Role(orgID, userID int) (string, error)
+	Members(orgID int) ([]OrgMember, error)
+	SetRole(orgID, userID int, role string) error
+	// RemoveMember refuses with ErrLastOrgOwner to remove the only owner,
+	// the check and the delete happen in one transaction.
+	RemoveMember(orgID, userID int) error
+	Delete(orgID int) error
+	Invite(orgID, invitedBy int, username string) (OrgInvite, error)
+	// Invites lists invites waiting for the user to accept them.
+	Invites(userID int) ([]OrgInvite, error)
+	// AcceptInvite turns the invite of the user into a membership and
+	// returns the organization id.
+	AcceptInvite(userID, orgInviteID int) (int, error)
+	DeleteInvite(userID, orgInviteID int) error
+}
+
+type PgOrgsRepo struct {
+	pgsql *sqlx.DB
+}
+
+func PgOrgsRepoCtor(pgsql *sqlx.DB) OrgsRepo {
+	return PgOrgsRepo{pgsql}
+}
+
+func (r PgOrgsRepo) Create(userID int, name string) (int, error) {
+	tx, err := r.pgsql.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil && !errors.Is(rollbackErr, sql.ErrTxDone) {
+			log.Error("Error rolling back transaction. Err=%s\n", rollbackErr)
+		}
+	}()
+	var orgID int
+	err = tx.QueryRow(
+		"INSERT INTO orgs (name, created_by) VALUES ($1, $2) RETURNING org_id",
+		name, userID,
+	).Scan(&orgID)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	_, err = tx.Exec(
+		"INSERT INTO org_members (org_id, user_id, role) VALUES ($1, $2, $3)",
+		orgID, userID, OrgRoleOwner,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return orgID, nil
+}
+
+func (r PgOrgsRepo) List(userID int) ([]Org, error) {
+	orgs := []Org{}
+	err := r.pgsql.Select(
+		&orgs,
+		strings.Join([]string{
+			"SELECT o.org_id, o.name, m.role, o.created_at",
+			"FROM orgs o",
+			"JOIN org_members m ON m.org_id = o.org_id",
+			"WHERE m.user_id = $1",
+			"ORDER BY o.name",
+		}, "\n"),
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return orgs, nil
+}
+
+func (r PgOrgsRepo) Role(orgID, userID int) (string, error) {
+	var role string
+	err := r.pgsql.Get(
+		&role,
+		"SELECT role FROM org_members WHERE org_id = $1 AND user_id = $2",
+		orgID, userID,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("%w: %d", ErrOrgNotFound, orgID)
+		}
+		return "", fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return role, nil
+}
+
+func (r PgOrgsRepo) Members(orgID int) ([]OrgMember, error) {
+	members := []OrgMember{}
+	err := r.pgsql.Select(
+		&members,
+		strings.Join([]string{
+			"SELECT m.user_id, u.username, m.role, m.created_at",
+			"FROM org_members m",
+			"JOIN users u ON u.user_id = m.user_id",
+			"WHERE m.org_id = $1",
+			"ORDER BY u.username",
+		}, "\n"),
+		orgID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return members, nil
+}
+
+func (r PgOrgsRepo) SetRole(orgID, userID int, role string) error {
+	result, err := r.pgsql.Exec(
+		"UPDATE org_members SET role = $1 WHERE org_id = $2 AND user_id = $3",
+		role, orgID, userID,
+	)
+	return r.affected(result, err, userID)
+}
+
+func (r PgOrgsRepo) RemoveMember(orgID, userID int) error {
+	tx, err := r.pgsql.Beginx()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil && !errors.Is(rollbackErr, sql.ErrTxDone) {
+			log.Error("Error rolling back transaction. Err=%s\n", rollbackErr)
+		}
+	}()
+	owners := []int{}
+	err = tx.Select(
+		&owners,
+		"SELECT user_id FROM org_members WHERE org_id = $1 AND role = $2 FOR UPDATE",
+		orgID, OrgRoleOwner,
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	if slices.Contains(owners, userID) && len(owners) <= 1 {
+		return ErrLastOrgOwner
+	}
+	result, err := tx.Exec(
+		"DELETE FROM org_members WHERE org_id = $1 AND user_id = $2",
+		orgID, userID,
+	)
+	err = r.affected(result, err, userID)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return nil
+}
+
+func (r PgOrgsRepo) affected(result sql.Result, err error, userID int) error {
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w: %d", ErrUserNotFound, userID)
+	}
+	return nil
+}
+
+func (r PgOrgsRepo) Delete(orgID int) error {
+	_, err := r.pgsql.Exec("DELETE FROM orgs WHERE org_id = $1", orgID)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return nil
+}
+
+var orgInviteColumns = strings.Join([]string{
+	"SELECT i.org_invite_id, i.org_id, o.name AS org_name, i.user_id, u.username,",
+	"  b.username AS invited_by, i.created_at",
+	"FROM org_invites i",
+	"JOIN orgs o ON o.org_id = i.org_id",
+	"JOIN users u ON u.user_id = i.user_id",
+	"LEFT JOIN users b ON b.user_id = i.invited_by",
+}, "\n")
+
+func (r PgOrgsRepo) Invite(orgID, invitedBy int, username string) (OrgInvite, error) {
+	var userID int
+	err := r.pgsql.Get(&userID, "SELECT user_id FROM users WHERE username = $1", username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return OrgInvite{}, fmt.Errorf("%w: %s", ErrUserNotFound, username)
+		}
+		return OrgInvite{}, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	if _, err := r.Role(orgID, userID); err == nil {
+		return OrgInvite{}, fmt.Errorf("%w: %s", ErrOrgMemberExists, username)
+	} else if !errors.Is(err, ErrOrgNotFound) {
+		return OrgInvite{}, err
+	}
+	var orgInviteID int
+	err = r.pgsql.QueryRow(
+		strings.Join([]string{
+			"INSERT INTO org_invites (org_id, user_id, invited_by)",
+			"VALUES ($1, $2, $3)",
+			"ON CONFLICT (org_id, user_id) DO UPDATE SET invited_by = $3, created_at = CURRENT_TIMESTAMP",
+			"RETURNING org_invite_id",
+		}, "\n"),
+		orgID, userID, invitedBy,
+	).Scan(&orgInviteID)
+	if err != nil {
+		return OrgInvite{}, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	var invite OrgInvite
+	err = r.pgsql.Get(&invite, orgInviteColumns+"\nWHERE i.org_invite_id = $1", orgInviteID)
+	if err != nil {
+		return OrgInvite{}, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return invite, nil
+}
+
+func (r PgOrgsRepo) Invites(userID int) ([]OrgInvite, error) {
+	invites := []OrgInvite{}
+	err := r.pgsql.Select(
+		&invites,
+		orgInviteColumns+"\nWHERE i.user_id = $1\nORDER BY i.created_at DESC",
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return invites, nil
+}
+
+func (r PgOrgsRepo) AcceptInvite(userID, orgInviteID int) (int, error) {
+	tx, err := r.pgsql.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil && !errors.Is(rollbackErr, sql.ErrTxDone) {
+			log.Error("Error rolling back transaction. Err=%s\n", rollbackErr)
+		}
+	}()
+	var orgID int
+	err = tx.QueryRow(
+		"DELETE FROM org_invites WHERE org_invite_id = $1 AND user_id = $2 RETURNING org_id",
+		orgInviteID, userID,
+	).Scan(&orgID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("%w: %d", ErrOrgInviteNotFound, orgInviteID)
+		}
+		return 0, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	_, err = tx.Exec(
+		strings.Join([]string{
+			"INSERT INTO org_members (org_id, user_id, role)",
+			"VALUES ($1, $2, $3)",
+			"ON CONFLICT (org_id, user_id) DO NOTHING",
+		}, "\n"),
+		orgID, userID, OrgRoleMember,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return orgID, nil
+}
+
+func (r PgOrgsRepo) DeleteInvite(userID, orgInviteID int) error {
+	result, err := r.pgsql.Exec(
+		"DELETE FROM org_invites WHERE org_invite_id = $1 AND user_id = $2",
+		orgInviteID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w: %d", ErrOrgInviteNotFound, orgInviteID)
+	}
+	return nil
+}
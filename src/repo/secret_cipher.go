@@ -0,0 +1,200 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package repo
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const masterKeySize = 32
+
+var (
+	ErrUnknownMasterKey = errors.New("unknown master key")
+	ErrInvalidMasterKey = errors.New("invalid master key")
+	ErrDecryptSecret    = errors.New("error decrypting secret")
+	ErrPlaintextSecret  = errors.New("secret is not encrypted")
+)
+
+// EncryptedSecret is a value sealed with its own data key. The data key
+// itself is sealed with the master key KeyID.
+type EncryptedSecret struct {
+	Ciphertext string
+	DataKey    string
+	KeyID      string
+}
+
+type SecretCipher interface {
+	Encrypt(plaintext string) (EncryptedSecret, error)
+	Decrypt(secret EncryptedSecret) (string, error)
+	Rewrap(secret EncryptedSecret) (EncryptedSecret, error)
+	ActiveKeyID() string
+}
+
+type AesGcmSecretCipher struct {
+	masterKeys  map[string][]byte
+	activeKeyID string
+}
+
+func AesGcmSecretCipherCtor(masterKeys map[string][]byte, activeKeyID string) (SecretCipher, error) {
+	if _, ok := masterKeys[activeKeyID]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMasterKey, activeKeyID)
+	}
+	return AesGcmSecretCipher{masterKeys, activeKeyID}, nil
+}
+
+// ParseMasterKeys reads "id1:base64key,id2:base64key" into a key ring.
+func ParseMasterKeys(raw string) (map[string][]byte, error) {
+	masterKeys := map[string][]byte{}
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		keyID, encoded, found := strings.Cut(item, ":")
+		if !found || keyID == "" {
+			return nil, fmt.Errorf("%w: expected <id>:<base64 key>", ErrInvalidMasterKey)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", ErrInvalidMasterKey, keyID, err)
+		}
+		if len(key) != masterKeySize {
+			return nil, fmt.Errorf("%w: %s must be %d bytes", ErrInvalidMasterKey, keyID, masterKeySize)
+		}
+		masterKeys[keyID] = key
+	}
+	return masterKeys, nil
+}
+
+func (c AesGcmSecretCipher) ActiveKeyID() string {
+	return c.activeKeyID
+}
+
+func (c AesGcmSecretCipher) Encrypt(plaintext string) (EncryptedSecret, error) {
+	dataKey := make([]byte, masterKeySize)
+	_, err := rand.Read(dataKey)
+	if err != nil {
+		return EncryptedSecret{}, err
+	}
+	ciphertext, err := seal(dataKey, []byte(plaintext), nil)
+	if err != nil {
+		return EncryptedSecret{}, err
+	}
+	wrappedKey, err := seal(c.masterKeys[c.activeKeyID], dataKey, []byte(c.activeKeyID))
+	if err != nil {
+		return EncryptedSecret{}, err
+	}
+	return EncryptedSecret{
+		Ciphertext: ciphertext,
+		DataKey:    wrappedKey,
+		KeyID:      c.activeKeyID,
+	}, nil
+}
+
+func (c AesGcmSecretCipher) Decrypt(secret EncryptedSecret) (string, error) {
+	dataKey, err := c.unwrap(secret)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataKey, secret.Ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// Rewrap seals the data key with the active master key. The secret
+// ciphertext is left untouched.
+func (c AesGcmSecretCipher) Rewrap(secret EncryptedSecret) (EncryptedSecret, error) {
+	dataKey, err := c.unwrap(secret)
+	if err != nil {
+		return EncryptedSecret{}, err
+	}
+	wrappedKey, err := seal(c.masterKeys[c.activeKeyID], dataKey, []byte(c.activeKeyID))
+	if err != nil {
+		return EncryptedSecret{}, err
+	}
+	return EncryptedSecret{
+		Ciphertext: secret.Ciphertext,
+		DataKey:    wrappedKey,
+		KeyID:      c.activeKeyID,
+	}, nil
+}
+
+func (c AesGcmSecretCipher) unwrap(secret EncryptedSecret) ([]byte, error) {
+	masterKey, ok := c.masterKeys[secret.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMasterKey, secret.KeyID)
+	}
+	return open(masterKey, secret.DataKey, []byte(secret.KeyID))
+}
+
+func seal(key, plaintext, additionalData []byte) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, additionalData)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func open(key []byte, encoded string, additionalData []byte) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDecryptSecret, err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("%w: ciphertext too short", ErrDecryptSecret)
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDecryptSecret, err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,177 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package repo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrShareLinkNotFound  = errors.New("share link not found")
+	ErrShareLinkExhausted = errors.New("share link download limit reached")
+)
+
+// ShareLink publishes an object, or every object under Key when it ends
+// with a slash, to anyone holding the token.
+type ShareLink struct {
+	ShareLinkID  int        `db:"share_link_id"`
+	UserID       int        `db:"user_id"`
+	BucketID     int        `db:"bucket_id"`
+	BucketName   string     `db:"bucket_name"`
+	Key          string     `db:"object_key"`
+	Prefix       string     `db:"token_prefix"`
+	PasswordHash *string    `db:"password_hash"`
+	ExpiresAt    *time.Time `db:"expires_at"`
+	MaxDownloads *int       `db:"max_downloads"`
+	Downloads    int        `db:"downloads"`
+	CreatedAt    time.Time  `db:"created_at"`
+}
+
+type NewShareLink struct {
+	BucketID     int
+	Key          string
+	TokenHash    string
+	Prefix       string
+	PasswordHash *string
+	ExpiresAt    *time.Time
+	MaxDownloads *int
+}
+
+type ShareLinksRepo interface {
+	List(userID int) ([]ShareLink, error)
+	Create(userID int, link NewShareLink) (ShareLink, error)
+	Delete(userID, shareLinkID int) error
+	ByHash(tokenHash string) (ShareLink, error)
+	// CountDownload takes one download of the link or fails with
+	// ErrShareLinkExhausted once max_downloads is reached.
+	CountDownload(shareLinkID int) error
+}
+
+type PgShareLinksRepo struct {
+	pgsql *sqlx.DB
+}
+
+func PgShareLinksRepoCtor(pgsql *sqlx.DB) ShareLinksRepo {
+	return PgShareLinksRepo{pgsql}
+}
+
+var shareLinkColumns = strings.Join([]string{
+	"SELECT l.share_link_id, l.user_id, l.bucket_id, b.bucket_name, l.object_key, l.token_prefix,",
+	"  l.password_hash, l.expires_at, l.max_downloads, l.downloads, l.created_at",
+	"FROM share_links l",
+	"JOIN buckets b ON b.bucket_id = l.bucket_id",
+}, "\n")
+
+func (r PgShareLinksRepo) List(userID int) ([]ShareLink, error) {
+	links := []ShareLink{}
+	err := r.pgsql.Select(
+		&links,
+		shareLinkColumns+"\nWHERE l.user_id = $1\nORDER BY l.created_at DESC",
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return links, nil
+}
+
+func (r PgShareLinksRepo) Create(userID int, link NewShareLink) (ShareLink, error) {
+	var shareLinkID int
+	err := r.pgsql.QueryRow(
+		strings.Join([]string{
+			"INSERT INTO share_links",
+			"  (user_id, bucket_id, object_key, token_hash, token_prefix, password_hash, expires_at, max_downloads)",
+			"VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+			"RETURNING share_link_id",
+		}, "\n"),
+		userID, link.BucketID, link.Key, link.TokenHash, link.Prefix, link.PasswordHash, link.ExpiresAt, link.MaxDownloads,
+	).Scan(&shareLinkID)
+	if err != nil {
+		return ShareLink{}, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	var created ShareLink
+	err = r.pgsql.Get(&created, shareLinkColumns+"\nWHERE l.share_link_id = $1", shareLinkID)
+	if err != nil {
+		return ShareLink{}, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return created, nil
+}
+
+func (r PgShareLinksRepo) Delete(userID, shareLinkID int) error {
+	result, err := r.pgsql.Exec(
+		"DELETE FROM share_links WHERE share_link_id = $1 AND user_id = $2",
+		shareLinkID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w: %d", ErrShareLinkNotFound, shareLinkID)
+	}
+	return nil
+}
+
+func (r PgShareLinksRepo) ByHash(tokenHash string) (ShareLink, error) {
+	var link ShareLink
+	err := r.pgsql.Get(&link, shareLinkColumns+"\nWHERE l.token_hash = $1", tokenHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ShareLink{}, ErrShareLinkNotFound
+		}
+		return ShareLink{}, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return link, nil
+}
+
+func (r PgShareLinksRepo) CountDownload(shareLinkID int) error {
+	result, err := r.pgsql.Exec(
+		strings.Join([]string{
+			"UPDATE share_links SET downloads = downloads + 1",
+			"WHERE share_link_id = $1 AND (max_downloads IS NULL OR downloads < max_downloads)",
+		}, "\n"),
+		shareLinkID,
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w: %d", ErrShareLinkExhausted, shareLinkID)
+	}
+	return nil
+}
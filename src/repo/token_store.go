@@ -0,0 +1,290 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrRefreshReused   = errors.New("refresh token already rotated")
+)
+
+type Session struct {
+	SessionID  string
+	UserID     int
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	IP         string
+	UserAgent  string
+}
+
+// TokenStore keeps revocation state of issued tokens. A session is one
+// refresh token family; the access tokens issued for it live as long as it
+// exists.
+type TokenStore interface {
+	Deny(jti string, ttl time.Duration) error
+	IsDenied(jti string) (bool, error)
+	// Consume denies a single-use token and tells whether this call was
+	// the first one to do so.
+	Consume(jti string, ttl time.Duration) (bool, error)
+	CreateSession(session Session, refreshJti string, ttl time.Duration) error
+	// RotateSession replaces the refresh token of the session only while
+	// currentJti is still its refresh token, otherwise ErrRefreshReused.
+	RotateSession(userID int, sessionID, currentJti, refreshJti string, ttl time.Duration) error
+	TouchSession(sessionID string, lastUsedAt time.Time, ip, userAgent string) error
+	RefreshJti(sessionID string) (string, error)
+	Sessions(userID int) ([]Session, error)
+	DeleteSession(userID int, sessionID string) error
+	DeleteUserSessions(userID int) error
+}
+
+type RedisTokenStore struct {
+	rdb *redis.Client
+}
+
+func RedisTokenStoreCtor(rdb *redis.Client) TokenStore {
+	return RedisTokenStore{rdb}
+}
+
+func denylistKey(jti string) string {
+	return "auth:denylist:" + jti
+}
+
+func sessionKey(sessionID string) string {
+	return "auth:session:" + sessionID
+}
+
+func userSessionsKey(userID int) string {
+	return "auth:user-sessions:" + strconv.Itoa(userID)
+}
+
+func (s RedisTokenStore) Deny(jti string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+	err := s.rdb.Set(context.Background(), denylistKey(jti), 1, ttl).Err()
+	if err != nil {
+		return fmt.Errorf("deny token: %w", err)
+	}
+	return nil
+}
+
+func (s RedisTokenStore) IsDenied(jti string) (bool, error) {
+	count, err := s.rdb.Exists(context.Background(), denylistKey(jti)).Result()
+	if err != nil {
+		return false, fmt.Errorf("check denied token: %w", err)
+	}
+	return count > 0, nil
+}
+
+func (s RedisTokenStore) Consume(jti string, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		return false, nil
+	}
+	consumed, err := s.rdb.SetNX(context.Background(), denylistKey(jti), 1, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("consume token: %w", err)
+	}
+	return consumed, nil
+}
+
+func (s RedisTokenStore) CreateSession(session Session, refreshJti string, ttl time.Duration) error {
+	ctx := context.Background()
+	_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(
+			ctx,
+			sessionKey(session.SessionID),
+			"user_id", session.UserID,
+			"refresh_jti", refreshJti,
+			"created_at", session.CreatedAt.Unix(),
+			"last_used_at", session.LastUsedAt.Unix(),
+			"ip", session.IP,
+			"user_agent", session.UserAgent,
+		)
+		pipe.Expire(ctx, sessionKey(session.SessionID), ttl)
+		pipe.SAdd(ctx, userSessionsKey(session.UserID), session.SessionID)
+		pipe.Expire(ctx, userSessionsKey(session.UserID), ttl)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("create session: %w", err)
+	}
+	return nil
+}
+
+// Compares and swaps the refresh_jti of a session hash in one step, so
+// two refreshes with the same token can not both win. Returns -1 for a
+// missing session, 0 for a stale jti and 1 once rotated.
+var rotateSessionScript = redis.NewScript(`
+local current = redis.call("HGET", KEYS[1], "refresh_jti")
+if not current then
+	return -1
+end
+if current ~= ARGV[1] then
+	return 0
+end
+redis.call("HSET", KEYS[1], "refresh_jti", ARGV[2])
+redis.call("EXPIRE", KEYS[1], ARGV[3])
+redis.call("EXPIRE", KEYS[2], ARGV[3])
+return 1
+`)
+
+func (s RedisTokenStore) RotateSession(userID int, sessionID, currentJti, refreshJti string, ttl time.Duration) error {
+	rotated, err := rotateSessionScript.Run(
+		context.Background(),
+		s.rdb,
+		[]string{sessionKey(sessionID), userSessionsKey(userID)},
+		currentJti,
+		refreshJti,
+		int64(ttl.Seconds()),
+	).Int()
+	if err != nil {
+		return fmt.Errorf("rotate session: %w", err)
+	}
+	switch rotated {
+	case -1:
+		return ErrSessionNotFound
+	case 0:
+		return ErrRefreshReused
+	}
+	return nil
+}
+
+// touchSessionScript updates a session only while it exists, so a request
+// racing a logout does not recreate the session hash without a TTL.
+var touchSessionScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+redis.call("HSET", KEYS[1], "last_used_at", ARGV[1], "ip", ARGV[2], "user_agent", ARGV[3])
+return 1
+`)
+
+func (s RedisTokenStore) TouchSession(sessionID string, lastUsedAt time.Time, ip, userAgent string) error {
+	touched, err := touchSessionScript.Run(
+		context.Background(),
+		s.rdb,
+		[]string{sessionKey(sessionID)},
+		lastUsedAt.Unix(),
+		ip,
+		userAgent,
+	).Int()
+	if err != nil {
+		return fmt.Errorf("touch session: %w", err)
+	}
+	if touched == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (s RedisTokenStore) Sessions(userID int) ([]Session, error) {
+	ctx := context.Background()
+	sessionIDs, err := s.rdb.SMembers(ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("list user sessions: %w", err)
+	}
+	sessions := make([]Session, 0, len(sessionIDs))
+	for _, sessionID := range sessionIDs {
+		fields, err := s.rdb.HGetAll(ctx, sessionKey(sessionID)).Result()
+		if err != nil {
+			return nil, fmt.Errorf("get session: %w", err)
+		}
+		if len(fields) == 0 {
+			s.rdb.SRem(ctx, userSessionsKey(userID), sessionID)
+			continue
+		}
+		createdAt, _ := strconv.ParseInt(fields["created_at"], 10, 64)
+		lastUsedAt, _ := strconv.ParseInt(fields["last_used_at"], 10, 64)
+		sessions = append(sessions, Session{
+			SessionID:  sessionID,
+			UserID:     userID,
+			CreatedAt:  time.Unix(createdAt, 0),
+			LastUsedAt: time.Unix(lastUsedAt, 0),
+			IP:         fields["ip"],
+			UserAgent:  fields["user_agent"],
+		})
+	}
+	return sessions, nil
+}
+
+func (s RedisTokenStore) RefreshJti(sessionID string) (string, error) {
+	jti, err := s.rdb.HGet(context.Background(), sessionKey(sessionID), "refresh_jti").Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return "", ErrSessionNotFound
+		}
+		return "", fmt.Errorf("get session: %w", err)
+	}
+	return jti, nil
+}
+
+func (s RedisTokenStore) DeleteSession(userID int, sessionID string) error {
+	ctx := context.Background()
+	ownerID, err := s.rdb.HGet(ctx, sessionKey(sessionID), "user_id").Int()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return ErrSessionNotFound
+		}
+		return fmt.Errorf("get session: %w", err)
+	}
+	if ownerID != userID {
+		return ErrSessionNotFound
+	}
+	_, err = s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, sessionKey(sessionID))
+		pipe.SRem(ctx, userSessionsKey(userID), sessionID)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("delete session: %w", err)
+	}
+	return nil
+}
+
+func (s RedisTokenStore) DeleteUserSessions(userID int) error {
+	ctx := context.Background()
+	sessionIDs, err := s.rdb.SMembers(ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return fmt.Errorf("list user sessions: %w", err)
+	}
+	keys := []string{userSessionsKey(userID)}
+	for _, sessionID := range sessionIDs {
+		keys = append(keys, sessionKey(sessionID))
+	}
+	err = s.rdb.Del(ctx, keys...).Err()
+	if err != nil {
+		return fmt.Errorf("delete user sessions: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package repo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/jmoiron/sqlx"
+)
+
+var ErrUploadSessionNotFound = errors.New("upload session not found")
+
+type UploadSession struct {
+	SessionID   int       `db:"session_id"`
+	UserID      int       `db:"user_id"`
+	BucketID    int       `db:"bucket_id"`
+	ObjectKey   string    `db:"object_key"`
+	UploadID    string    `db:"upload_id"`
+	ContentType string    `db:"content_type"`
+	CreatedAt   time.Time `db:"created_at"`
+}
+
+type UploadSessionsRepo interface {
+	List(userID int) ([]UploadSession, error)
+	GetByID(userID, sessionID int) (*UploadSession, error)
+	Create(userID, bucketID int, objectKey, uploadID, contentType string) (int, error)
+	Delete(userID, sessionID int) error
+}
+
+type PgUploadSessionsRepo struct {
+	pgsql *sqlx.DB
+}
+
+func PgUploadSessionsRepoCtor(pgsql *sqlx.DB) UploadSessionsRepo {
+	return PgUploadSessionsRepo{pgsql}
+}
+
+func (r PgUploadSessionsRepo) List(userID int) ([]UploadSession, error) {
+	sessions := []UploadSession{}
+	err := r.pgsql.Select(
+		&sessions,
+		strings.Join([]string{
+			"SELECT",
+			"  session_id,",
+			"  user_id,",
+			"  bucket_id,",
+			"  object_key,",
+			"  upload_id,",
+			"  content_type,",
+			"  created_at",
+			"FROM upload_sessions",
+			"WHERE user_id = $1",
+			"ORDER BY created_at DESC",
+		}, "\n"),
+		userID,
+	)
+	if err != nil {
+		log.Error("Error listing upload sessions. Err=%s\n", err)
+		return nil, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return sessions, nil
+}
+
+func (r PgUploadSessionsRepo) GetByID(userID, sessionID int) (*UploadSession, error) {
+	var session UploadSession
+	err := r.pgsql.Get(
+		&session,
+		strings.Join([]string{
+			"SELECT",
+			"  session_id,",
+			"  user_id,",
+			"  bucket_id,",
+			"  object_key,",
+			"  upload_id,",
+			"  content_type,",
+			"  created_at",
+			"FROM upload_sessions",
+			"WHERE session_id = $1 AND user_id = $2",
+		}, "\n"),
+		sessionID, userID,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUploadSessionNotFound
+		}
+		log.Error("Error getting upload session. Err=%s\n", err)
+		return nil, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return &session, nil
+}
+
+func (r PgUploadSessionsRepo) Create(userID, bucketID int, objectKey, uploadID, contentType string) (int, error) {
+	var sessionID int
+	err := r.pgsql.QueryRow(
+		strings.Join([]string{
+			"INSERT INTO upload_sessions (user_id, bucket_id, object_key, upload_id, content_type)",
+			"VALUES ($1, $2, $3, $4, $5)",
+			"RETURNING session_id",
+		}, "\n"),
+		userID, bucketID, objectKey, uploadID, contentType,
+	).Scan(&sessionID)
+	if err != nil {
+		log.Error("Error creating upload session. Err=%s\n", err)
+		return 0, fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	return sessionID, nil
+}
+
+func (r PgUploadSessionsRepo) Delete(userID, sessionID int) error {
+	result, err := r.pgsql.Exec(
+		strings.Join([]string{
+			"DELETE FROM upload_sessions",
+			"WHERE session_id = $1 AND user_id = $2",
+		}, "\n"),
+		sessionID, userID,
+	)
+	if err != nil {
+		log.Error("Error deleting upload session. Err=%s\n", err)
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	if affected == 0 {
+		return ErrUploadSessionNotFound
+	}
+	return nil
+}
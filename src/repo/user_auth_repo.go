@@ -17,6 +17,7 @@ var (
 type UserAuthRepo interface {
 	UserId(username string) (int, error)
 	PasswordHash(username string) (string, error)
+	UpdatePasswordHash(userID int, passwordHash string) error
 }
 
 type PgUserAuthRepo struct {
@@ -70,3 +71,24 @@ func (repo PgUserAuthRepo) PasswordHash(username string) (string, error) {
 	}
 	return passwordHash, nil
 }
+
+func (repo PgUserAuthRepo) UpdatePasswordHash(userID int, passwordHash string) error {
+	result, err := repo.pgsql.Exec(
+		strings.Join([]string{
+			"UPDATE users SET password_hash=$2",
+			"WHERE user_id=$1",
+		}, "\n"),
+		userID, passwordHash,
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSQL, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w: %d", ErrUserNotFound, userID)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package srv
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/blablatdinov/web-s3/src/repo"
+)
+
+// ApiTokenPrefix marks personal API tokens so AuthMiddleware can tell them
+// from JWTs.
+const ApiTokenPrefix = "ws3_"
+
+// last_used_at is written at most this often per token.
+const apiTokenTouchInterval = time.Minute
+
+var (
+	ErrInvalidApiToken = errors.New("invalid api token")
+	ErrInvalidScope    = errors.New("invalid api token scope")
+)
+
+type ApiTokens interface {
+	// Create returns the stored token and its plain value, which is never
+	// shown again.
+	Create(userID int, name, scope string, bucketIDs []int, expiresAt *time.Time) (repo.ApiToken, string, error)
+	List(userID int) ([]repo.ApiToken, error)
+	Revoke(userID, apiTokenID int) error
+	Authenticate(rawToken string) (repo.ApiToken, error)
+}
+
+type ApiTokensSrv struct {
+	repo repo.ApiTokensRepo
+}
+
+func ApiTokensSrvCtor(repo repo.ApiTokensRepo) ApiTokens {
+	return ApiTokensSrv{repo}
+}
+
+func IsApiToken(rawToken string) bool {
+	return strings.HasPrefix(rawToken, ApiTokenPrefix)
+}
+
+func hashToken(rawToken string) string {
+	sum := sha256.Sum256([]byte(rawToken))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s ApiTokensSrv) Create(userID int, name, scope string, bucketIDs []int, expiresAt *time.Time) (repo.ApiToken, string, error) {
+	if scope != repo.ApiTokenScopeRead && scope != repo.ApiTokenScopeReadWrite {
+		return repo.ApiToken{}, "", fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+	}
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return repo.ApiToken{}, "", err
+	}
+	rawToken := ApiTokenPrefix + hex.EncodeToString(buf)
+	token, err := s.repo.Create(userID, repo.NewApiToken{
+		Name:      name,
+		TokenHash: hashToken(rawToken),
+		Prefix:    rawToken[:len(ApiTokenPrefix)+6],
+		Scope:     scope,
+		BucketIDs: bucketIDs,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return repo.ApiToken{}, "", err
+	}
+	return token, rawToken, nil
+}
+
+func (s ApiTokensSrv) List(userID int) ([]repo.ApiToken, error) {
+	return s.repo.List(userID)
+}
+
+func (s ApiTokensSrv) Revoke(userID, apiTokenID int) error {
+	return s.repo.Delete(userID, apiTokenID)
+}
+
+func (s ApiTokensSrv) Authenticate(rawToken string) (repo.ApiToken, error) {
+	token, err := s.repo.ByHash(hashToken(rawToken))
+	if err != nil {
+		if errors.Is(err, repo.ErrApiTokenNotFound) {
+			return repo.ApiToken{}, ErrInvalidApiToken
+		}
+		return repo.ApiToken{}, err
+	}
+	now := time.Now()
+	if token.ExpiresAt != nil && now.After(*token.ExpiresAt) {
+		return repo.ApiToken{}, fmt.Errorf("%w: expired", ErrInvalidApiToken)
+	}
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > apiTokenTouchInterval {
+		err = s.repo.Touch(token.ApiTokenID, now)
+		if err != nil {
+			return repo.ApiToken{}, err
+		}
+		token.LastUsedAt = &now
+	}
+	return token, nil
+}
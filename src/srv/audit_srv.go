@@ -0,0 +1,149 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+package srv
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/blablatdinov/web-s3/src/repo"
+)
+
+const (
+	AuditResultSuccess = "success"
+	AuditResultDenied  = "denied"
+	AuditResultFailure = "failure"
+
+	DefaultAuditPageSize = 100
+	MaxAuditPageSize     = 1000
+	maxAuditUserAgent    = 1024
+
+	// Failed requests without a user, like ones with a bad token, are
+	// recorded up to this many per IP and window, the rest is dropped.
+	anonymousAuditLimit  = 60
+	anonymousAuditWindow = time.Minute
+)
+
+var ErrInvalidAuditResult = errors.New("invalid audit result")
+
+type AuditLog interface {
+	// Record appends the entry, deriving its result from the HTTP status
+	// when the result is not set.
+	Record(entry repo.AuditEntry) error
+	// Search returns what the user may see: every entry for audit admins,
+	// otherwise the user's own entries and those about buckets the user
+	// administers.
+	Search(userID int, filter repo.AuditFilter) ([]repo.AuditEntry, error)
+}
+
+type AuditLogSrv struct {
+	repo        repo.AuditRepo
+	attempts    repo.AttemptStore
+	bucketsRepo repo.BucketsRepo
+	authz       BucketAuthz
+	admins      []int
+}
+
+// AuditLogSrvCtor takes audit admins by user id, usernames could be claimed
+// by whoever signs up first.
+func AuditLogSrvCtor(
+	repo repo.AuditRepo,
+	attempts repo.AttemptStore,
+	bucketsRepo repo.BucketsRepo,
+	authz BucketAuthz,
+	admins []int,
+) AuditLog {
+	return AuditLogSrv{repo, attempts, bucketsRepo, authz, admins}
+}
+
+// AuditResult classifies an HTTP status, refused credentials and
+// permissions are told apart from other failures.
+func AuditResult(status int) string {
+	switch {
+	case status < 400:
+		return AuditResultSuccess
+	case status == 401 || status == 403 || status == 429:
+		return AuditResultDenied
+	}
+	return AuditResultFailure
+}
+
+func (s AuditLogSrv) Record(entry repo.AuditEntry) error {
+	if entry.Result == "" {
+		entry.Result = AuditResult(entry.Status)
+	}
+	if len(entry.UserAgent) > maxAuditUserAgent {
+		entry.UserAgent = entry.UserAgent[:maxAuditUserAgent]
+	}
+	if entry.UserID == nil && entry.Result != AuditResultSuccess {
+		wait, err := s.attempts.Hit("audit:"+entry.IP, anonymousAuditLimit, anonymousAuditWindow)
+		if err != nil {
+			return err
+		}
+		if wait > 0 {
+			return nil
+		}
+	}
+	return s.repo.Append(entry)
+}
+
+func (s AuditLogSrv) Search(userID int, filter repo.AuditFilter) ([]repo.AuditEntry, error) {
+	if filter.Result != "" && !slices.Contains(
+		[]string{AuditResultSuccess, AuditResultDenied, AuditResultFailure}, filter.Result,
+	) {
+		return nil, ErrInvalidAuditResult
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultAuditPageSize
+	}
+	filter.Limit = min(filter.Limit, MaxAuditPageSize)
+	filter.Visible = nil
+	if !slices.Contains(s.admins, userID) {
+		visible, err := s.visibility(userID)
+		if err != nil {
+			return nil, err
+		}
+		filter.Visible = &visible
+	}
+	return s.repo.Search(filter)
+}
+
+func (s AuditLogSrv) visibility(userID int) (repo.AuditVisibility, error) {
+	visible := repo.AuditVisibility{UserID: userID, BucketIDs: []int{}}
+	buckets, err := s.bucketsRepo.List(userID)
+	if err != nil {
+		return repo.AuditVisibility{}, err
+	}
+	for i := range buckets {
+		permissions, err := s.authz.Permissions(userID, &buckets[i])
+		if err != nil {
+			return repo.AuditVisibility{}, err
+		}
+		if permissions.Allows(BucketActionManage, "") {
+			visible.BucketIDs = append(visible.BucketIDs, buckets[i].BucketID)
+		}
+	}
+	return visible, nil
+}
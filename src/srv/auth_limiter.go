@@ -0,0 +1,224 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package srv
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/blablatdinov/web-s3/src/repo"
+)
+
+var ErrTooManyAttempts = errors.New("too many attempts")
+
+// TooManyAttemptsError is returned when a request is throttled, RetryAfter
+// tells when the client may try again.
+type TooManyAttemptsError struct {
+	RetryAfter time.Duration
+}
+
+func (e TooManyAttemptsError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrTooManyAttempts, e.RetryAfter)
+}
+
+func (e TooManyAttemptsError) Unwrap() error {
+	return ErrTooManyAttempts
+}
+
+// AuthLimits configures throttling of login, sign-up and share link
+// passwords. A zero limit or threshold disables the corresponding check.
+type AuthLimits struct {
+	LoginPerIP           int
+	LoginPerUsername     int
+	LoginWindow          time.Duration
+	SignupPerIP          int
+	SignupWindow         time.Duration
+	SharePasswordPerIP   int
+	SharePasswordPerLink int
+	SharePasswordWindow  time.Duration
+	LockoutThreshold     int
+	LockoutBase          time.Duration
+	LockoutMax           time.Duration
+}
+
+func DefaultAuthLimits() AuthLimits {
+	return AuthLimits{
+		LoginPerIP:           20,
+		LoginPerUsername:     10,
+		LoginWindow:          time.Minute,
+		SignupPerIP:          5,
+		SignupWindow:         time.Hour,
+		SharePasswordPerIP:   20,
+		SharePasswordPerLink: 10,
+		SharePasswordWindow:  time.Minute,
+		LockoutThreshold:     5,
+		LockoutBase:          time.Minute,
+		LockoutMax:           time.Hour,
+	}
+}
+
+type AuthLimiter interface {
+	AllowLogin(username string, client Client) error
+	LoginFailed(username string) error
+	LoginSucceeded(username string) error
+	AllowSignup(client Client) error
+	// AllowSharePassword, SharePasswordFailed and SharePasswordSucceeded
+	// throttle password guesses on a share link the way logins are
+	// throttled, with their own budget and lockouts. The link is named by
+	// the public prefix of its token.
+	AllowSharePassword(linkPrefix string, client Client) error
+	SharePasswordFailed(linkPrefix string) error
+	SharePasswordSucceeded(linkPrefix string) error
+}
+
+type AttemptsAuthLimiter struct {
+	limits AuthLimits
+	store  repo.AttemptStore
+}
+
+func AuthLimiterCtor(limits AuthLimits, store repo.AttemptStore) AuthLimiter {
+	return AttemptsAuthLimiter{limits, store}
+}
+
+func usernameKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+func shareLinkKey(linkPrefix string) string {
+	return "share:" + linkPrefix
+}
+
+// AllowLogin rejects the attempt while the account is locked or when the
+// client IP or the username has used up its window.
+func (l AttemptsAuthLimiter) AllowLogin(username string, client Client) error {
+	err := l.unlocked(usernameKey(username))
+	if err != nil {
+		return err
+	}
+	err = l.hit("login:ip:"+client.IP, l.limits.LoginPerIP, l.limits.LoginWindow)
+	if err != nil {
+		return err
+	}
+	return l.hit("login:"+usernameKey(username), l.limits.LoginPerUsername, l.limits.LoginWindow)
+}
+
+// LoginFailed counts a failed password check and locks the account once
+// the threshold is reached. Every further failure doubles the lock, up to
+// LockoutMax.
+func (l AttemptsAuthLimiter) LoginFailed(username string) error {
+	return l.failed(usernameKey(username))
+}
+
+func (l AttemptsAuthLimiter) LoginSucceeded(username string) error {
+	return l.succeeded(usernameKey(username))
+}
+
+func (l AttemptsAuthLimiter) AllowSharePassword(linkPrefix string, client Client) error {
+	err := l.unlocked(shareLinkKey(linkPrefix))
+	if err != nil {
+		return err
+	}
+	err = l.hit("share:ip:"+client.IP, l.limits.SharePasswordPerIP, l.limits.SharePasswordWindow)
+	if err != nil {
+		return err
+	}
+	return l.hit("share:link:"+linkPrefix, l.limits.SharePasswordPerLink, l.limits.SharePasswordWindow)
+}
+
+func (l AttemptsAuthLimiter) SharePasswordFailed(linkPrefix string) error {
+	return l.failed(shareLinkKey(linkPrefix))
+}
+
+func (l AttemptsAuthLimiter) SharePasswordSucceeded(linkPrefix string) error {
+	return l.succeeded(shareLinkKey(linkPrefix))
+}
+
+func (l AttemptsAuthLimiter) unlocked(key string) error {
+	if l.limits.LockoutThreshold <= 0 {
+		return nil
+	}
+	lockedFor, err := l.store.LockedFor(key)
+	if err != nil {
+		return err
+	}
+	if lockedFor > 0 {
+		return TooManyAttemptsError{lockedFor}
+	}
+	return nil
+}
+
+func (l AttemptsAuthLimiter) failed(key string) error {
+	if l.limits.LockoutThreshold <= 0 {
+		return nil
+	}
+	failures, err := l.store.AddFailure(key, l.limits.LockoutMax)
+	if err != nil {
+		return err
+	}
+	if failures < l.limits.LockoutThreshold {
+		return nil
+	}
+	lockFor := l.lockDuration(failures - l.limits.LockoutThreshold)
+	return l.store.Lock(key, lockFor, lockFor+l.limits.LockoutMax)
+}
+
+func (l AttemptsAuthLimiter) succeeded(key string) error {
+	if l.limits.LockoutThreshold <= 0 {
+		return nil
+	}
+	return l.store.Reset(key)
+}
+
+func (l AttemptsAuthLimiter) AllowSignup(client Client) error {
+	return l.hit("signup:ip:"+client.IP, l.limits.SignupPerIP, l.limits.SignupWindow)
+}
+
+func (l AttemptsAuthLimiter) hit(key string, limit int, window time.Duration) error {
+	if limit <= 0 {
+		return nil
+	}
+	wait, err := l.store.Hit(key, limit, window)
+	if err != nil {
+		return err
+	}
+	if wait > 0 {
+		return TooManyAttemptsError{wait}
+	}
+	return nil
+}
+
+func (l AttemptsAuthLimiter) lockDuration(step int) time.Duration {
+	if step > 32 {
+		return l.limits.LockoutMax
+	}
+	lockFor := float64(l.limits.LockoutBase) * math.Pow(2, float64(step))
+	if lockFor > float64(l.limits.LockoutMax) {
+		return l.limits.LockoutMax
+	}
+	return time.Duration(lockFor)
+}
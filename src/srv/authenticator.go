@@ -0,0 +1,91 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package srv
+
+import (
+	"errors"
+
+	"github.com/blablatdinov/web-s3/src/repo"
+)
+
+// Authenticator checks user credentials against one backend and returns
+// the local user id and canonical username. It fails with
+// repo.ErrUserNotFound for unknown users and ErrInvalidPassword for
+// rejected credentials.
+type Authenticator interface {
+	Authenticate(username, password string) (int, string, error)
+}
+
+// LocalAuthenticator checks bcrypt hashes stored in the users table.
+type LocalAuthenticator struct {
+	repo repo.UserAuthRepo
+}
+
+func LocalAuthenticatorCtor(repo repo.UserAuthRepo) Authenticator {
+	return LocalAuthenticator{repo}
+}
+
+func (a LocalAuthenticator) Authenticate(username, password string) (int, string, error) {
+	userID, err := a.repo.UserId(username)
+	if err != nil {
+		return 0, "", err
+	}
+	passwordHash, err := a.repo.PasswordHash(username)
+	if err != nil {
+		return 0, "", err
+	}
+	if !PswrdCtor(password).Check(passwordHash) {
+		return 0, "", ErrInvalidPassword
+	}
+	return userID, username, nil
+}
+
+// ChainAuthenticator tries backends in order until one accepts the
+// credentials. Infrastructure errors stop the chain.
+type ChainAuthenticator struct {
+	backends []Authenticator
+}
+
+func ChainAuthenticatorCtor(backends ...Authenticator) Authenticator {
+	return ChainAuthenticator{backends}
+}
+
+func (a ChainAuthenticator) Authenticate(username, password string) (int, string, error) {
+	err := error(repo.ErrUserNotFound)
+	for _, backend := range a.backends {
+		userID, canonical, backendErr := backend.Authenticate(username, password)
+		if backendErr == nil {
+			return userID, canonical, nil
+		}
+		if !errors.Is(backendErr, repo.ErrUserNotFound) && !errors.Is(backendErr, ErrInvalidPassword) {
+			return 0, "", backendErr
+		}
+		// A known user with a wrong password is reported over unknown ones.
+		if errors.Is(backendErr, ErrInvalidPassword) || errors.Is(err, repo.ErrUserNotFound) {
+			err = backendErr
+		}
+	}
+	return 0, "", err
+}
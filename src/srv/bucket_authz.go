@@ -0,0 +1,217 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package srv
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/blablatdinov/web-s3/src/repo"
+)
+
+type BucketAction string
+
+const (
+	BucketActionList  BucketAction = "list"
+	BucketActionRead  BucketAction = "read"
+	BucketActionWrite BucketAction = "write"
+	// BucketActionOverwrite covers replacing an existing object, which
+	// loses it just like a delete.
+	BucketActionOverwrite BucketAction = "overwrite"
+	BucketActionDelete    BucketAction = "delete"
+	// BucketActionManage covers granting roles on the bucket.
+	BucketActionManage BucketAction = "manage"
+)
+
+var (
+	ErrBucketForbidden   = errors.New("bucket action forbidden")
+	ErrInvalidBucketRole = errors.New("invalid bucket role")
+	ErrInvalidGrantee    = errors.New("grant needs either a username or an org_id")
+)
+
+// bucketRoleRanks orders roles, each role can do everything the lower ones
+// can.
+var bucketRoleRanks = map[string]int{
+	repo.BucketRoleViewer:   1,
+	repo.BucketRoleUploader: 2,
+	repo.BucketRoleEditor:   3,
+	repo.BucketRoleAdmin:    4,
+}
+
+// bucketActionRanks is the lowest role rank allowed to do the action.
+var bucketActionRanks = map[BucketAction]int{
+	BucketActionList:      1,
+	BucketActionRead:      1,
+	BucketActionWrite:     2,
+	BucketActionOverwrite: 3,
+	BucketActionDelete:    3,
+	BucketActionManage:    4,
+}
+
+// BucketPermissions are the roles a user holds on a bucket, each for keys
+// under its own prefix.
+type BucketPermissions struct {
+	grants []repo.BucketGrant
+}
+
+// Allows reports whether the action is allowed on the key, prefixes of
+// directories are keys too.
+func (p BucketPermissions) Allows(action BucketAction, key string) bool {
+	for _, grant := range p.grants {
+		if bucketRoleRanks[grant.Role] >= bucketActionRanks[action] && strings.HasPrefix(key, grant.Prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Browsable reports whether the directory may show up in a listing, it is
+// either readable or leads to a readable prefix.
+func (p BucketPermissions) Browsable(prefix string) bool {
+	for _, grant := range p.grants {
+		if strings.HasPrefix(prefix, grant.Prefix) || strings.HasPrefix(grant.Prefix, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// BucketAuthz is the single place deciding what a user may do in a bucket,
+// handlers ask it before touching S3.
+type BucketAuthz interface {
+	Permissions(userID int, bucket *repo.Bucket) (BucketPermissions, error)
+	// Authorize fails with ErrBucketForbidden unless the action is allowed
+	// on every key.
+	Authorize(userID int, bucket *repo.Bucket, action BucketAction, keys ...string) error
+	Grants(userID int, bucket *repo.Bucket) ([]repo.BucketGrant, error)
+	Grant(userID int, bucket *repo.Bucket, grant repo.NewBucketGrant) (repo.BucketGrant, error)
+	Revoke(userID int, bucket *repo.Bucket, bucketGrantID int) error
+}
+
+type GrantsBucketAuthz struct {
+	grants repo.BucketGrantsRepo
+	orgs   repo.OrgsRepo
+}
+
+func GrantsBucketAuthzCtor(grants repo.BucketGrantsRepo, orgs repo.OrgsRepo) BucketAuthz {
+	return GrantsBucketAuthz{grants, orgs}
+}
+
+// Permissions gives admin to the owner of a personal bucket and to owners
+// of the organization of a shared one, other organization members view it.
+// Explicit grants add to that.
+func (a GrantsBucketAuthz) Permissions(userID int, bucket *repo.Bucket) (BucketPermissions, error) {
+	if bucket.OrgID == nil && bucket.UserID == userID {
+		return BucketPermissions{[]repo.BucketGrant{{BucketID: bucket.BucketID, Role: repo.BucketRoleAdmin}}}, nil
+	}
+	var permissions BucketPermissions
+	if bucket.OrgID != nil {
+		role, err := a.orgs.Role(*bucket.OrgID, userID)
+		if err != nil && !errors.Is(err, repo.ErrOrgNotFound) {
+			return BucketPermissions{}, err
+		}
+		if role == repo.OrgRoleOwner {
+			return BucketPermissions{[]repo.BucketGrant{{BucketID: bucket.BucketID, Role: repo.BucketRoleAdmin}}}, nil
+		}
+		if role == repo.OrgRoleMember {
+			permissions.grants = append(permissions.grants, repo.BucketGrant{BucketID: bucket.BucketID, Role: repo.BucketRoleViewer})
+		}
+	}
+	grants, err := a.grants.ForUser(bucket.BucketID, userID)
+	if err != nil {
+		return BucketPermissions{}, err
+	}
+	permissions.grants = append(permissions.grants, grants...)
+	return permissions, nil
+}
+
+func (a GrantsBucketAuthz) Authorize(userID int, bucket *repo.Bucket, action BucketAction, keys ...string) error {
+	permissions, err := a.Permissions(userID, bucket)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if !permissions.Allows(action, key) {
+			return fmt.Errorf("%w: %s %q", ErrBucketForbidden, action, key)
+		}
+	}
+	return nil
+}
+
+// Grants lists the grants under prefixes the user manages.
+func (a GrantsBucketAuthz) Grants(userID int, bucket *repo.Bucket) ([]repo.BucketGrant, error) {
+	permissions, err := a.Permissions(userID, bucket)
+	if err != nil {
+		return nil, err
+	}
+	grants, err := a.grants.List(bucket.BucketID)
+	if err != nil {
+		return nil, err
+	}
+	managed := []repo.BucketGrant{}
+	for _, grant := range grants {
+		if permissions.Allows(BucketActionManage, grant.Prefix) {
+			managed = append(managed, grant)
+		}
+	}
+	if len(managed) == 0 && !permissions.Allows(BucketActionManage, "") {
+		return nil, fmt.Errorf("%w: %s", ErrBucketForbidden, BucketActionManage)
+	}
+	return managed, nil
+}
+
+func (a GrantsBucketAuthz) Grant(userID int, bucket *repo.Bucket, grant repo.NewBucketGrant) (repo.BucketGrant, error) {
+	if _, ok := bucketRoleRanks[grant.Role]; !ok {
+		return repo.BucketGrant{}, fmt.Errorf("%w: %q", ErrInvalidBucketRole, grant.Role)
+	}
+	if (grant.Username == "") == (grant.OrgID == nil) {
+		return repo.BucketGrant{}, ErrInvalidGrantee
+	}
+	grant.Prefix = strings.TrimPrefix(grant.Prefix, "/")
+	err := a.Authorize(userID, bucket, BucketActionManage, grant.Prefix)
+	if err != nil {
+		return repo.BucketGrant{}, err
+	}
+	return a.grants.Create(bucket.BucketID, grant)
+}
+
+func (a GrantsBucketAuthz) Revoke(userID int, bucket *repo.Bucket, bucketGrantID int) error {
+	grants, err := a.grants.List(bucket.BucketID)
+	if err != nil {
+		return err
+	}
+	for _, grant := range grants {
+		if grant.BucketGrantID != bucketGrantID {
+			continue
+		}
+		err = a.Authorize(userID, bucket, BucketActionManage, grant.Prefix)
+		if err != nil {
+			return err
+		}
+		return a.grants.Delete(bucket.BucketID, bucketGrantID)
+	}
+	return fmt.Errorf("%w: %d", repo.ErrBucketGrantNotFound, bucketGrantID)
+}
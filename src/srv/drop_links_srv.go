@@ -0,0 +1,176 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package srv
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/blablatdinov/web-s3/src/repo"
+)
+
+const maxDropFileNameLength = 255
+
+var (
+	ErrInvalidDropLink     = errors.New("invalid drop link")
+	ErrInvalidFileName     = errors.New("invalid file name")
+	ErrExtensionNotAllowed = errors.New("file extension not allowed")
+	ErrFileTooLarge        = errors.New("file too large")
+)
+
+type NewDropLink struct {
+	Prefix            string
+	ExpiresAt         *time.Time
+	MaxFileSize       *int64
+	AllowedExtensions []string
+	QuotaBytes        *int64
+}
+
+type DropLinks interface {
+	// Create returns the stored link and its token, which is never shown
+	// again. The user must be allowed to write under the prefix.
+	Create(userID int, bucket *repo.Bucket, link NewDropLink) (repo.DropLink, string, error)
+	List(userID int) ([]repo.DropLink, error)
+	Revoke(userID, dropLinkID int) error
+	// Open resolves a link that has not expired.
+	Open(rawToken string) (repo.DropLink, error)
+	// Accept checks the file against the link limits, reserves its size in
+	// the quota and returns the key to store it under.
+	Accept(link repo.DropLink, fileName string, size int64) (string, error)
+	// Release gives back the quota reserved for an upload that failed.
+	Release(link repo.DropLink, size int64) error
+}
+
+type DropLinksSrv struct {
+	repo  repo.DropLinksRepo
+	authz BucketAuthz
+}
+
+func DropLinksSrvCtor(repo repo.DropLinksRepo, authz BucketAuthz) DropLinks {
+	return DropLinksSrv{repo, authz}
+}
+
+// normalizeExtension turns "PDF", ".pdf" and " .Pdf " into ".pdf".
+func normalizeExtension(extension string) string {
+	extension = strings.ToLower(strings.TrimSpace(extension))
+	if extension != "" && !strings.HasPrefix(extension, ".") {
+		extension = "." + extension
+	}
+	return extension
+}
+
+func (s DropLinksSrv) Create(userID int, bucket *repo.Bucket, link NewDropLink) (repo.DropLink, string, error) {
+	prefix := strings.TrimPrefix(link.Prefix, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	err := s.authz.Authorize(userID, bucket, BucketActionWrite, prefix)
+	if err != nil {
+		return repo.DropLink{}, "", err
+	}
+	extensions := make([]string, 0, len(link.AllowedExtensions))
+	for _, extension := range link.AllowedExtensions {
+		if extension = normalizeExtension(extension); extension != "" {
+			extensions = append(extensions, extension)
+		}
+	}
+	buf := make([]byte, 24)
+	_, err = rand.Read(buf)
+	if err != nil {
+		return repo.DropLink{}, "", err
+	}
+	rawToken := hex.EncodeToString(buf)
+	created, err := s.repo.Create(userID, repo.NewDropLink{
+		BucketID:          bucket.BucketID,
+		Prefix:            prefix,
+		TokenHash:         hashToken(rawToken),
+		TokenPrefix:       rawToken[:8],
+		ExpiresAt:         link.ExpiresAt,
+		MaxFileSize:       link.MaxFileSize,
+		AllowedExtensions: extensions,
+		QuotaBytes:        link.QuotaBytes,
+	})
+	if err != nil {
+		return repo.DropLink{}, "", err
+	}
+	return created, rawToken, nil
+}
+
+func (s DropLinksSrv) List(userID int) ([]repo.DropLink, error) {
+	return s.repo.List(userID)
+}
+
+func (s DropLinksSrv) Revoke(userID, dropLinkID int) error {
+	return s.repo.Delete(userID, dropLinkID)
+}
+
+func (s DropLinksSrv) Open(rawToken string) (repo.DropLink, error) {
+	link, err := s.repo.ByHash(hashToken(rawToken))
+	if err != nil {
+		if errors.Is(err, repo.ErrDropLinkNotFound) {
+			return repo.DropLink{}, ErrInvalidDropLink
+		}
+		return repo.DropLink{}, err
+	}
+	if link.ExpiresAt != nil && time.Now().After(*link.ExpiresAt) {
+		return repo.DropLink{}, fmt.Errorf("%w: expired", ErrInvalidDropLink)
+	}
+	return link, nil
+}
+
+// Accept stores every upload under a random name part, so outsiders can
+// neither overwrite files nor probe which names exist.
+func (s DropLinksSrv) Accept(link repo.DropLink, fileName string, size int64) (string, error) {
+	fileName = path.Base(strings.ReplaceAll(strings.TrimSpace(fileName), "\\", "/"))
+	if fileName == "." || fileName == "/" || fileName == "" || len(fileName) > maxDropFileNameLength {
+		return "", fmt.Errorf("%w: %q", ErrInvalidFileName, fileName)
+	}
+	if len(link.AllowedExtensions) > 0 && !slices.Contains(link.AllowedExtensions, normalizeExtension(path.Ext(fileName))) {
+		return "", fmt.Errorf("%w: %q", ErrExtensionNotAllowed, fileName)
+	}
+	if link.MaxFileSize != nil && size > *link.MaxFileSize {
+		return "", fmt.Errorf("%w: %d bytes", ErrFileTooLarge, size)
+	}
+	buf := make([]byte, 4)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	err = s.repo.Reserve(link.DropLinkID, size)
+	if err != nil {
+		return "", err
+	}
+	return link.Prefix + hex.EncodeToString(buf) + "-" + fileName, nil
+}
+
+func (s DropLinksSrv) Release(link repo.DropLink, size int64) error {
+	return s.repo.Release(link.DropLinkID, size)
+}
@@ -0,0 +1,185 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package srv
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/blablatdinov/web-s3/src/repo"
+	"github.com/go-ldap/ldap/v3"
+)
+
+type LdapConfig struct {
+	URL      string
+	StartTLS bool
+	// BindDN and BindPassword of the service account used to search users,
+	// anonymous search when empty.
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter gets the escaped username in place of %s, e.g. (uid=%s).
+	UserFilter        string
+	UsernameAttribute string
+	GroupAttribute    string
+	// AllowedGroups lists group DNs allowed to log in, any user when empty.
+	AllowedGroups []string
+	Timeout       time.Duration
+}
+
+// LdapAuthenticator binds as the user found by the search filter. Users are
+// linked to local accounts through their DN, the account is created on the
+// first successful login.
+type LdapAuthenticator struct {
+	config     LdapConfig
+	identities repo.IdentitiesRepo
+}
+
+func LdapAuthenticatorCtor(config LdapConfig, identities repo.IdentitiesRepo) Authenticator {
+	if config.UserFilter == "" {
+		config.UserFilter = "(uid=%s)"
+	}
+	if config.UsernameAttribute == "" {
+		config.UsernameAttribute = "uid"
+	}
+	if config.GroupAttribute == "" {
+		config.GroupAttribute = "memberOf"
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+	return LdapAuthenticator{config, identities}
+}
+
+func (a LdapAuthenticator) Authenticate(username, password string) (int, string, error) {
+	// An empty password is an unauthenticated bind that most servers accept.
+	if username == "" || password == "" {
+		return 0, "", ErrInvalidPassword
+	}
+	conn, err := a.dial()
+	if err != nil {
+		return 0, "", err
+	}
+	defer conn.Close()
+	if a.config.BindDN != "" {
+		err = conn.Bind(a.config.BindDN, a.config.BindPassword)
+		if err != nil {
+			return 0, "", fmt.Errorf("ldap service bind: %w", err)
+		}
+	}
+	result, err := conn.Search(ldap.NewSearchRequest(
+		a.config.BaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2,
+		int(a.config.Timeout.Seconds()),
+		false,
+		fmt.Sprintf(a.config.UserFilter, ldap.EscapeFilter(username)),
+		[]string{a.config.UsernameAttribute, a.config.GroupAttribute},
+		nil,
+	))
+	if err != nil {
+		return 0, "", fmt.Errorf("ldap search: %w", err)
+	}
+	if len(result.Entries) != 1 {
+		return 0, "", fmt.Errorf("%w: %s", repo.ErrUserNotFound, username)
+	}
+	entry := result.Entries[0]
+	err = conn.Bind(entry.DN, password)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return 0, "", ErrInvalidPassword
+		}
+		return 0, "", fmt.Errorf("ldap user bind: %w", err)
+	}
+	if !a.allowed(entry.GetAttributeValues(a.config.GroupAttribute)) {
+		return 0, "", fmt.Errorf("%w: %s is not in an allowed group", ErrInvalidPassword, entry.DN)
+	}
+	canonical := entry.GetAttributeValue(a.config.UsernameAttribute)
+	if canonical == "" {
+		canonical = username
+	}
+	subject := strings.ToLower(entry.DN)
+	userID, canonical, err := a.user(subject, canonical)
+	if err != nil {
+		return 0, "", err
+	}
+	return userID, canonical, nil
+}
+
+func (a LdapAuthenticator) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(a.config.URL, ldap.DialWithDialer(&net.Dialer{Timeout: a.config.Timeout}))
+	if err != nil {
+		return nil, fmt.Errorf("ldap dial: %w", err)
+	}
+	conn.SetTimeout(a.config.Timeout)
+	if a.config.StartTLS {
+		serverURL, err := url.Parse(a.config.URL)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap url: %w", err)
+		}
+		err = conn.StartTLS(&tls.Config{ServerName: serverURL.Hostname()})
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap starttls: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+func (a LdapAuthenticator) allowed(groups []string) bool {
+	if len(a.config.AllowedGroups) == 0 {
+		return true
+	}
+	for _, group := range groups {
+		for _, allowed := range a.config.AllowedGroups {
+			if strings.EqualFold(group, allowed) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (a LdapAuthenticator) user(subject, username string) (int, string, error) {
+	userID, linked, err := a.identities.User(a.config.URL, subject)
+	if err == nil {
+		return userID, linked, nil
+	}
+	if !errors.Is(err, repo.ErrIdentityNotFound) {
+		return 0, "", err
+	}
+	userID, err = a.identities.Provision(a.config.URL, subject, username)
+	if err != nil {
+		return 0, "", err
+	}
+	return userID, username, nil
+}
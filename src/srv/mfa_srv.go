@@ -0,0 +1,228 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package srv
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/blablatdinov/web-s3/src/repo"
+)
+
+var (
+	ErrInvalidMfaCode    = errors.New("invalid mfa code")
+	ErrMfaAlreadyEnabled = errors.New("mfa already enabled")
+	ErrMfaNotEnabled     = errors.New("mfa not enabled")
+)
+
+const recoveryCodesCount = 10
+
+type TotpSetup struct {
+	Secret string
+	URI    string
+}
+
+type Mfa interface {
+	Enabled(userID int) (bool, error)
+	Enroll(userID int, username string) (TotpSetup, error)
+	Confirm(userID int, code string) ([]string, error)
+	Verify(userID int, code string) error
+	RegenerateRecoveryCodes(userID int, code string) ([]string, error)
+	Disable(userID int, code string) error
+}
+
+type TotpMfaSrv struct {
+	repo   repo.MfaRepo
+	issuer string
+}
+
+func TotpMfaSrvCtor(repo repo.MfaRepo, issuer string) Mfa {
+	return TotpMfaSrv{repo, issuer}
+}
+
+func (s TotpMfaSrv) Enabled(userID int) (bool, error) {
+	enrollment, err := s.repo.Totp(userID)
+	if err != nil {
+		if errors.Is(err, repo.ErrTotpNotEnrolled) {
+			return false, nil
+		}
+		return false, err
+	}
+	return enrollment.Confirmed, nil
+}
+
+// Enroll generates a new secret that becomes active only after Confirm.
+func (s TotpMfaSrv) Enroll(userID int, username string) (TotpSetup, error) {
+	enabled, err := s.Enabled(userID)
+	if err != nil {
+		return TotpSetup{}, err
+	}
+	if enabled {
+		return TotpSetup{}, ErrMfaAlreadyEnabled
+	}
+	secret, err := GenerateTotpSecret()
+	if err != nil {
+		return TotpSetup{}, err
+	}
+	err = s.repo.SaveTotp(userID, secret)
+	if err != nil {
+		return TotpSetup{}, err
+	}
+	return TotpSetup{secret, TotpURI(s.issuer, username, secret)}, nil
+}
+
+// Confirm enables MFA once the user proves the authenticator app works and
+// returns recovery codes, the only time they are shown in plain text.
+func (s TotpMfaSrv) Confirm(userID int, code string) ([]string, error) {
+	enrollment, err := s.repo.Totp(userID)
+	if err != nil {
+		if errors.Is(err, repo.ErrTotpNotEnrolled) {
+			return nil, ErrMfaNotEnabled
+		}
+		return nil, err
+	}
+	if enrollment.Confirmed {
+		return nil, ErrMfaAlreadyEnabled
+	}
+	err = s.verifyTotp(enrollment, code)
+	if err != nil {
+		return nil, err
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	err = s.repo.ConfirmTotp(userID, hashes)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Verify accepts either a current TOTP code or an unused recovery code.
+func (s TotpMfaSrv) Verify(userID int, code string) error {
+	enrollment, err := s.repo.Totp(userID)
+	if err != nil {
+		if errors.Is(err, repo.ErrTotpNotEnrolled) {
+			return ErrMfaNotEnabled
+		}
+		return err
+	}
+	if !enrollment.Confirmed {
+		return ErrMfaNotEnabled
+	}
+	code = strings.TrimSpace(code)
+	if len(code) == totpDigits {
+		return s.verifyTotp(enrollment, code)
+	}
+	used, err := s.repo.UseRecoveryCode(userID, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidMfaCode
+	}
+	return nil
+}
+
+func (s TotpMfaSrv) RegenerateRecoveryCodes(userID int, code string) ([]string, error) {
+	err := s.Verify(userID, code)
+	if err != nil {
+		return nil, err
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	err = s.repo.ReplaceRecoveryCodes(userID, hashes)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (s TotpMfaSrv) Disable(userID int, code string) error {
+	err := s.Verify(userID, code)
+	if err != nil {
+		return err
+	}
+	return s.repo.DeleteTotp(userID)
+}
+
+// verifyTotp allows one step of clock drift in both directions and every
+// step to be used once.
+func (s TotpMfaSrv) verifyTotp(enrollment repo.TotpEnrollment, code string) error {
+	current := totpStep(time.Now())
+	for step := current - 1; step <= current+1; step++ {
+		expected, err := totpCodeAt(enrollment.Secret, step)
+		if err != nil {
+			return err
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) != 1 {
+			continue
+		}
+		fresh, err := s.repo.UseTotpStep(enrollment.UserID, step)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return ErrInvalidMfaCode
+		}
+		return nil
+	}
+	return ErrInvalidMfaCode
+}
+
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodesCount)
+	hashes := make([]string, 0, recoveryCodesCount)
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for range recoveryCodesCount {
+		buf := make([]byte, 7)
+		_, err := rand.Read(buf)
+		if err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(encoding.EncodeToString(buf))[:10]
+		code := raw[:5] + "-" + raw[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// Recovery codes are random enough for a plain hash, the separator and
+// case are ignored.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,212 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package srv
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/blablatdinov/web-s3/src/repo"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrOidcInvalidState   = errors.New("invalid or expired oidc state")
+	ErrOidcInvalidToken   = errors.New("invalid oidc token")
+	ErrOidcUserNotAllowed = errors.New("oidc user is not provisioned")
+)
+
+const oidcStateTTL = 10 * time.Minute
+
+type OidcConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// UsernameClaim names the id token claim used as username of
+	// provisioned users.
+	UsernameClaim string
+	AutoProvision bool
+}
+
+type OidcLogin interface {
+	// AuthURL returns the provider login URL and its state, the state has
+	// to be kept by the browser to prove the callback comes back to it.
+	AuthURL() (string, string, error)
+	// LinkURL is AuthURL for a logged in user linking the identity to the
+	// account.
+	LinkURL(userID int) (string, string, error)
+	// Callback requires browserState, the state kept by the browser, to
+	// match the returned state.
+	Callback(code, state, browserState string, client Client) (Tokens, error)
+}
+
+type OidcSrv struct {
+	config     OidcConfig
+	oauth      oauth2.Config
+	verifier   *oidc.IDTokenVerifier
+	states     repo.OidcStateStore
+	identities repo.IdentitiesRepo
+	userAuth   UserAuth
+}
+
+// OidcSrvCtor reads the provider metadata from the issuer discovery
+// document.
+func OidcSrvCtor(
+	ctx context.Context,
+	config OidcConfig,
+	states repo.OidcStateStore,
+	identities repo.IdentitiesRepo,
+	userAuth UserAuth,
+) (OidcLogin, error) {
+	provider, err := oidc.NewProvider(ctx, config.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	scopes := config.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{oidc.ScopeOpenID, "profile", "email"}
+	}
+	if config.UsernameClaim == "" {
+		config.UsernameClaim = "preferred_username"
+	}
+	return OidcSrv{
+		config: config,
+		oauth: oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       scopes,
+		},
+		verifier:   provider.Verifier(&oidc.Config{ClientID: config.ClientID}),
+		states:     states,
+		identities: identities,
+		userAuth:   userAuth,
+	}, nil
+}
+
+// AuthURL starts an authorization code flow with PKCE.
+func (s OidcSrv) AuthURL() (string, string, error) {
+	return s.start(0)
+}
+
+func (s OidcSrv) LinkURL(userID int) (string, string, error) {
+	return s.start(userID)
+}
+
+func (s OidcSrv) start(linkUserID int) (string, string, error) {
+	state, err := randomID()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomID()
+	if err != nil {
+		return "", "", err
+	}
+	verifier := oauth2.GenerateVerifier()
+	err = s.states.Save(
+		state,
+		repo.OidcState{Verifier: verifier, Nonce: nonce, LinkUserID: linkUserID},
+		oidcStateTTL,
+	)
+	if err != nil {
+		return "", "", err
+	}
+	authURL := s.oauth.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	return authURL, state, nil
+}
+
+// Callback exchanges the authorization code, verifies the id token and
+// logs in the user linked to its subject. A link flow first links the
+// subject to the user who started it. Users with MFA enabled still have
+// to pass the second factor.
+func (s OidcSrv) Callback(code, state, browserState string, client Client) (Tokens, error) {
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(browserState)) != 1 {
+		return Tokens{}, fmt.Errorf("%w: state does not belong to this browser", ErrOidcInvalidState)
+	}
+	oidcState, err := s.states.Take(state)
+	if err != nil {
+		if errors.Is(err, repo.ErrOidcStateNotFound) {
+			return Tokens{}, ErrOidcInvalidState
+		}
+		return Tokens{}, err
+	}
+	ctx := context.Background()
+	token, err := s.oauth.Exchange(ctx, code, oauth2.VerifierOption(oidcState.Verifier))
+	if err != nil {
+		return Tokens{}, fmt.Errorf("%w: exchange code: %s", ErrOidcInvalidToken, err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return Tokens{}, fmt.Errorf("%w: id_token is missing", ErrOidcInvalidToken)
+	}
+	idToken, err := s.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return Tokens{}, fmt.Errorf("%w: %s", ErrOidcInvalidToken, err)
+	}
+	if idToken.Nonce != oidcState.Nonce {
+		return Tokens{}, fmt.Errorf("%w: nonce mismatch", ErrOidcInvalidToken)
+	}
+	if oidcState.LinkUserID != 0 {
+		err = s.identities.Link(oidcState.LinkUserID, idToken.Issuer, idToken.Subject)
+		if err != nil {
+			return Tokens{}, err
+		}
+	}
+	userID, username, err := s.identities.User(idToken.Issuer, idToken.Subject)
+	if errors.Is(err, repo.ErrIdentityNotFound) {
+		userID, username, err = s.provision(idToken)
+	}
+	if err != nil {
+		return Tokens{}, err
+	}
+	return s.userAuth.Login(userID, username, client)
+}
+
+func (s OidcSrv) provision(idToken *oidc.IDToken) (int, string, error) {
+	if !s.config.AutoProvision {
+		return 0, "", fmt.Errorf("%w: %s", ErrOidcUserNotAllowed, idToken.Subject)
+	}
+	claims := map[string]any{}
+	err := idToken.Claims(&claims)
+	if err != nil {
+		return 0, "", fmt.Errorf("%w: %s", ErrOidcInvalidToken, err)
+	}
+	username, _ := claims[s.config.UsernameClaim].(string)
+	if username == "" {
+		return 0, "", fmt.Errorf("%w: claim %s is missing", ErrOidcInvalidToken, s.config.UsernameClaim)
+	}
+	userID, err := s.identities.Provision(idToken.Issuer, idToken.Subject, username)
+	if err != nil {
+		return 0, "", err
+	}
+	return userID, username, nil
+}
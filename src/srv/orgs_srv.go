@@ -0,0 +1,154 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package srv
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/blablatdinov/web-s3/src/repo"
+)
+
+const orgNameMaxLength = 128
+
+var (
+	ErrInvalidOrgName  = errors.New("invalid organization name")
+	ErrInvalidOrgRole  = errors.New("invalid organization role")
+	ErrOrgForbidden    = errors.New("organization owner role required")
+	ErrLastOrgOwner    = repo.ErrLastOrgOwner
+	ErrOrgSelfDemotion = errors.New("organization owners cannot demote themselves")
+)
+
+type Orgs interface {
+	Create(userID int, name string) (repo.Org, error)
+	List(userID int) ([]repo.Org, error)
+	Members(userID, orgID int) ([]repo.OrgMember, error)
+	Invite(userID, orgID int, username string) (repo.OrgInvite, error)
+	Invites(userID int) ([]repo.OrgInvite, error)
+	Accept(userID, orgInviteID int) (int, error)
+	Decline(userID, orgInviteID int) error
+	SetRole(userID, orgID, memberID int, role string) error
+	// RemoveMember lets owners remove anyone and members leave on their own.
+	RemoveMember(userID, orgID, memberID int) error
+	Delete(userID, orgID int) error
+}
+
+type OrgsSrv struct {
+	repo repo.OrgsRepo
+}
+
+func OrgsSrvCtor(repo repo.OrgsRepo) Orgs {
+	return OrgsSrv{repo}
+}
+
+func (s OrgsSrv) Create(userID int, name string) (repo.Org, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > orgNameMaxLength {
+		return repo.Org{}, fmt.Errorf("%w: %q", ErrInvalidOrgName, name)
+	}
+	orgID, err := s.repo.Create(userID, name)
+	if err != nil {
+		return repo.Org{}, err
+	}
+	return repo.Org{OrgID: orgID, Name: name, Role: repo.OrgRoleOwner}, nil
+}
+
+func (s OrgsSrv) List(userID int) ([]repo.Org, error) {
+	return s.repo.List(userID)
+}
+
+func (s OrgsSrv) Members(userID, orgID int) ([]repo.OrgMember, error) {
+	_, err := s.repo.Role(orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.Members(orgID)
+}
+
+// owner fails unless the user owns the organization, members of other
+// organizations only learn that it does not exist.
+func (s OrgsSrv) owner(userID, orgID int) error {
+	role, err := s.repo.Role(orgID, userID)
+	if err != nil {
+		return err
+	}
+	if role != repo.OrgRoleOwner {
+		return ErrOrgForbidden
+	}
+	return nil
+}
+
+func (s OrgsSrv) Invite(userID, orgID int, username string) (repo.OrgInvite, error) {
+	err := s.owner(userID, orgID)
+	if err != nil {
+		return repo.OrgInvite{}, err
+	}
+	return s.repo.Invite(orgID, userID, username)
+}
+
+func (s OrgsSrv) Invites(userID int) ([]repo.OrgInvite, error) {
+	return s.repo.Invites(userID)
+}
+
+func (s OrgsSrv) Accept(userID, orgInviteID int) (int, error) {
+	return s.repo.AcceptInvite(userID, orgInviteID)
+}
+
+func (s OrgsSrv) Decline(userID, orgInviteID int) error {
+	return s.repo.DeleteInvite(userID, orgInviteID)
+}
+
+func (s OrgsSrv) SetRole(userID, orgID, memberID int, role string) error {
+	if role != repo.OrgRoleOwner && role != repo.OrgRoleMember {
+		return fmt.Errorf("%w: %q", ErrInvalidOrgRole, role)
+	}
+	err := s.owner(userID, orgID)
+	if err != nil {
+		return err
+	}
+	if memberID == userID && role != repo.OrgRoleOwner {
+		return ErrOrgSelfDemotion
+	}
+	return s.repo.SetRole(orgID, memberID, role)
+}
+
+func (s OrgsSrv) RemoveMember(userID, orgID, memberID int) error {
+	if memberID != userID {
+		err := s.owner(userID, orgID)
+		if err != nil {
+			return err
+		}
+	}
+	return s.repo.RemoveMember(orgID, memberID)
+}
+
+func (s OrgsSrv) Delete(userID, orgID int) error {
+	err := s.owner(userID, orgID)
+	if err != nil {
+		return err
+	}
+	return s.repo.Delete(orgID)
+}
@@ -0,0 +1,81 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package srv
+
+import (
+	"errors"
+
+	"github.com/blablatdinov/web-s3/src/repo"
+)
+
+var (
+	ErrSamePassword    = errors.New("new password equals the old one")
+	ErrNoLocalPassword = errors.New("account has no local password")
+)
+
+type PasswordChange interface {
+	Change(userID int, username, oldPassword, newPassword string) error
+}
+
+type PasswordChangeSrv struct {
+	repo     repo.UserAuthRepo
+	policy   PasswordPolicy
+	userAuth UserAuth
+}
+
+func PasswordChangeSrvCtor(repo repo.UserAuthRepo, policy PasswordPolicy, userAuth UserAuth) PasswordChange {
+	return PasswordChangeSrv{repo, policy, userAuth}
+}
+
+// Change replaces the password after checking the old one and revokes
+// every session of the user, including the one that made the request.
+func (s PasswordChangeSrv) Change(userID int, username, oldPassword, newPassword string) error {
+	passwordHash, err := s.repo.PasswordHash(username)
+	if err != nil {
+		return err
+	}
+	if passwordHash == repo.UnusablePasswordHash {
+		return ErrNoLocalPassword
+	}
+	if !PswrdCtor(oldPassword).Check(passwordHash) {
+		return ErrInvalidPassword
+	}
+	if oldPassword == newPassword {
+		return ErrSamePassword
+	}
+	err = s.policy.Check(username, newPassword)
+	if err != nil {
+		return err
+	}
+	newHash, err := PswrdCtor(newPassword).Hash()
+	if err != nil {
+		return err
+	}
+	err = s.repo.UpdatePasswordHash(userID, newHash)
+	if err != nil {
+		return err
+	}
+	return s.userAuth.RevokeUser(userID)
+}
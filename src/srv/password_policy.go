@@ -0,0 +1,161 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package srv
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+var ErrWeakPassword = errors.New("password does not satisfy policy")
+
+//go:embed common_passwords.txt
+var commonPasswords string
+
+type CharClass string
+
+const (
+	CharClassLower  CharClass = "lower"
+	CharClassUpper  CharClass = "upper"
+	CharClassDigit  CharClass = "digit"
+	CharClassSymbol CharClass = "symbol"
+)
+
+// PasswordPolicyError lists every rule the password breaks.
+type PasswordPolicyError struct {
+	Violations []string
+}
+
+func (e PasswordPolicyError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrWeakPassword, strings.Join(e.Violations, ", "))
+}
+
+func (e PasswordPolicyError) Unwrap() error {
+	return ErrWeakPassword
+}
+
+type PasswordPolicy struct {
+	MinLength int
+	// MaxLength is in bytes, bcrypt ignores everything past 72.
+	MaxLength int
+	Classes   []CharClass
+	denylist  map[string]bool
+}
+
+// DefaultPasswordPolicy requires 8 characters with lower and upper case
+// letters and a digit, and rejects the bundled list of common passwords.
+func DefaultPasswordPolicy() PasswordPolicy {
+	policy := PasswordPolicy{
+		MinLength: 8,
+		MaxLength: 72,
+		Classes:   []CharClass{CharClassLower, CharClassUpper, CharClassDigit},
+		denylist:  map[string]bool{},
+	}
+	policy, _ = policy.WithDenylist(strings.NewReader(commonPasswords))
+	return policy
+}
+
+// ParseCharClasses reads a comma separated list like "lower,upper,digit".
+func ParseCharClasses(raw string) ([]CharClass, error) {
+	classes := []CharClass{}
+	for _, name := range strings.Split(raw, ",") {
+		class := CharClass(strings.TrimSpace(name))
+		switch class {
+		case "":
+			continue
+		case CharClassLower, CharClassUpper, CharClassDigit, CharClassSymbol:
+			classes = append(classes, class)
+		default:
+			return nil, fmt.Errorf("unknown character class %q", class)
+		}
+	}
+	return classes, nil
+}
+
+// WithDenylist returns a copy of the policy that also rejects every
+// password listed in r, one per line.
+func (p PasswordPolicy) WithDenylist(r io.Reader) (PasswordPolicy, error) {
+	denylist := make(map[string]bool, len(p.denylist))
+	for password := range p.denylist {
+		denylist[password] = true
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		password := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if password != "" {
+			denylist[password] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return p, fmt.Errorf("read password denylist: %w", err)
+	}
+	p.denylist = denylist
+	return p, nil
+}
+
+func (p PasswordPolicy) Check(username, password string) error {
+	violations := []string{}
+	if len([]rune(password)) < p.MinLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		violations = append(violations, fmt.Sprintf("must be at most %d bytes long", p.MaxLength))
+	}
+	for _, class := range p.Classes {
+		if !strings.ContainsFunc(password, classMatcher(class)) {
+			violations = append(violations, fmt.Sprintf("must contain a %s character", class))
+		}
+	}
+	if username != "" && strings.EqualFold(password, username) {
+		violations = append(violations, "must not be equal to the username")
+	}
+	if p.denylist[strings.ToLower(password)] {
+		violations = append(violations, "is too common")
+	}
+	if len(violations) > 0 {
+		return PasswordPolicyError{violations}
+	}
+	return nil
+}
+
+func classMatcher(class CharClass) func(rune) bool {
+	switch class {
+	case CharClassLower:
+		return unicode.IsLower
+	case CharClassUpper:
+		return unicode.IsUpper
+	case CharClassDigit:
+		return unicode.IsDigit
+	default:
+		return func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package srv
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+type ArchiveEntry struct {
+	Key     string
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// ArchiveEntriesForPrefix lists every object under prefix. Entry names are
+// relative to the parent of prefix so the archive keeps the folder itself.
+func ArchiveEntriesForPrefix(ctx context.Context, s3Client *s3.Client, bucketName, prefix string) ([]ArchiveEntry, error) {
+	base := path.Dir(strings.TrimSuffix(prefix, "/"))
+	if base == "." {
+		base = ""
+	} else {
+		base += "/"
+	}
+	entries := []ArchiveEntry{}
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Contents {
+			key := aws.ToString(item.Key)
+			if strings.HasSuffix(key, "/") {
+				continue
+			}
+			entries = append(entries, ArchiveEntry{
+				Key:     key,
+				Name:    strings.TrimPrefix(key, base),
+				Size:    aws.ToInt64(item.Size),
+				ModTime: aws.ToTime(item.LastModified),
+			})
+		}
+	}
+	return entries, nil
+}
+
+// ArchiveEntriesForKeys resolves size and modification time of every key.
+// Entry names are relative to the deepest folder shared by all keys, so
+// same-named files from different folders do not collide in the archive.
+func ArchiveEntriesForKeys(ctx context.Context, s3Client *s3.Client, bucketName string, keys []string) ([]ArchiveEntry, error) {
+	base := archiveKeysBase(keys)
+	seen := make(map[string]bool, len(keys))
+	entries := make([]ArchiveEntry, 0, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("head object %s: %w", key, err)
+		}
+		entries = append(entries, ArchiveEntry{
+			Key:     key,
+			Name:    strings.TrimPrefix(key, base),
+			Size:    aws.ToInt64(head.ContentLength),
+			ModTime: aws.ToTime(head.LastModified),
+		})
+	}
+	return entries, nil
+}
+
+// archiveKeysBase returns the deepest folder, with a trailing slash, that
+// contains every key, or an empty string when the keys share none.
+func archiveKeysBase(keys []string) string {
+	if len(keys) == 0 {
+		return ""
+	}
+	common := strings.Split(keys[0], "/")
+	common = common[:len(common)-1]
+	for _, key := range keys[1:] {
+		parts := strings.Split(key, "/")
+		parts = parts[:len(parts)-1]
+		n := 0
+		for n < len(common) && n < len(parts) && common[n] == parts[n] {
+			n++
+		}
+		common = common[:n]
+	}
+	if len(common) == 0 {
+		return ""
+	}
+	return strings.Join(common, "/") + "/"
+}
+
+func ArchiveSize(entries []ArchiveEntry) int64 {
+	var size int64
+	for _, entry := range entries {
+		size += entry.Size
+	}
+	return size
+}
+
+func copyArchiveEntry(ctx context.Context, w io.Writer, s3Client *s3.Client, bucketName string, entry ArchiveEntry) error {
+	object, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(entry.Key),
+	})
+	if err != nil {
+		return fmt.Errorf("get object %s: %w", entry.Key, err)
+	}
+	defer object.Body.Close()
+	_, err = io.CopyN(w, object.Body, entry.Size)
+	if err != nil {
+		return fmt.Errorf("copy object %s: %w", entry.Key, err)
+	}
+	return nil
+}
+
+// WriteZipArchive streams the objects one by one into a ZIP archive.
+func WriteZipArchive(ctx context.Context, w io.Writer, s3Client *s3.Client, bucketName string, entries []ArchiveEntry) error {
+	archive := zip.NewWriter(w)
+	for _, entry := range entries {
+		file, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     entry.Name,
+			Method:   zip.Deflate,
+			Modified: entry.ModTime,
+		})
+		if err != nil {
+			return err
+		}
+		err = copyArchiveEntry(ctx, file, s3Client, bucketName, entry)
+		if err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// WriteTarGzArchive streams the objects one by one into a gzipped tarball.
+func WriteTarGzArchive(ctx context.Context, w io.Writer, s3Client *s3.Client, bucketName string, entries []ArchiveEntry) error {
+	compressed := gzip.NewWriter(w)
+	archive := tar.NewWriter(compressed)
+	for _, entry := range entries {
+		err := archive.WriteHeader(&tar.Header{
+			Name:    entry.Name,
+			Mode:    0o644,
+			Size:    entry.Size,
+			ModTime: entry.ModTime,
+		})
+		if err != nil {
+			return err
+		}
+		err = copyArchiveEntry(ctx, archive, s3Client, bucketName, entry)
+		if err != nil {
+			return err
+		}
+	}
+	err := archive.Close()
+	if err != nil {
+		return err
+	}
+	return compressed.Close()
+}
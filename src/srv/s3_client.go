@@ -26,15 +26,18 @@ package srv
 
 import (
 	"context"
+	"errors"
+	"net/http"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/blablatdinov/web-s3/src/repo"
 )
 
-func CreateS3ClientFromBucket(ctx context.Context, bucket *repo.Bucket) (*s3.Client, error) {
+func CreateS3ClientFromBucket(ctx context.Context, bucket *repo.Bucket, optFns ...func(*s3.Options)) (*s3.Client, error) {
 	cfg, err := config.LoadDefaultConfig(ctx,
 		config.WithRegion(bucket.Region),
 		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
@@ -54,5 +57,29 @@ func CreateS3ClientFromBucket(ctx context.Context, bucket *repo.Bucket) (*s3.Cli
 		})
 	}
 
+	s3Options = append(s3Options, optFns...)
 	return s3.NewFromConfig(cfg, s3Options...), nil
 }
+
+// ObjectExists tells whether the key holds an object.
+func ObjectExists(ctx context.Context, s3Client *s3.Client, bucketName, key string) (bool, error) {
+	_, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err == nil {
+		return true, nil
+	}
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound {
+		return false, nil
+	}
+	return false, err
+}
+
+// IsPreconditionFailed tells whether S3 refused a conditional request, e.g.
+// a write with If-None-Match when the key already holds an object.
+func IsPreconditionFailed(err error) bool {
+	var respErr *awshttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusPreconditionFailed
+}
@@ -0,0 +1,255 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package srv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/blablatdinov/web-s3/src/repo"
+)
+
+var ErrObjectExists = errors.New("object already exists")
+
+const (
+	maxCopyObjectSize = 5 * 1024 * 1024 * 1024
+	copyPartSize      = 512 * 1024 * 1024
+	maxCopyParts      = 10000
+)
+
+type ObjectCopier interface {
+	Copy(ctx context.Context, srcKey, dstKey string) error
+}
+
+// S3ObjectCopier copies objects between two registered buckets. When both
+// buckets are reachable with the same credentials the copy happens on the
+// storage side, otherwise the object is streamed through the server.
+type S3ObjectCopier struct {
+	src        *s3.Client
+	srcBucket  string
+	dst        *s3.Client
+	dstBucket  string
+	serverSide bool
+}
+
+func S3ObjectCopierCtor(src *s3.Client, srcBucket *repo.Bucket, dst *s3.Client, dstBucket *repo.Bucket) ObjectCopier {
+	return S3ObjectCopier{
+		src:        src,
+		srcBucket:  srcBucket.BucketName,
+		dst:        dst,
+		dstBucket:  dstBucket.BucketName,
+		serverSide: sameStorage(srcBucket, dstBucket),
+	}
+}
+
+func sameStorage(a, b *repo.Bucket) bool {
+	if a.BucketID == b.BucketID {
+		return true
+	}
+	return a.AccessKeyID == b.AccessKeyID &&
+		a.SecretAccessKey == b.SecretAccessKey &&
+		a.Region == b.Region &&
+		aws.ToString(a.Endpoint) == aws.ToString(b.Endpoint)
+}
+
+// SamePhysicalBucket tells whether two registrations address the same bucket
+// of the same storage, whatever credentials they use.
+func SamePhysicalBucket(a, b *repo.Bucket) bool {
+	if a.BucketID == b.BucketID {
+		return true
+	}
+	return a.BucketName == b.BucketName &&
+		normalizeEndpoint(a.Endpoint) == normalizeEndpoint(b.Endpoint)
+}
+
+func normalizeEndpoint(endpoint *string) string {
+	return strings.TrimSuffix(strings.ToLower(aws.ToString(endpoint)), "/")
+}
+
+func (c S3ObjectCopier) Copy(ctx context.Context, srcKey, dstKey string) error {
+	if c.serverSide {
+		return c.serverSideCopy(ctx, srcKey, dstKey)
+	}
+	return c.streamCopy(ctx, srcKey, dstKey)
+}
+
+func (c S3ObjectCopier) copySource(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return c.srcBucket + "/" + strings.Join(segments, "/")
+}
+
+func (c S3ObjectCopier) serverSideCopy(ctx context.Context, srcKey, dstKey string) error {
+	head, err := c.src.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(c.srcBucket),
+		Key:    aws.String(srcKey),
+	})
+	if err != nil {
+		return fmt.Errorf("head source object: %w", err)
+	}
+	size := aws.ToInt64(head.ContentLength)
+	if size <= maxCopyObjectSize {
+		_, err = c.dst.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:     aws.String(c.dstBucket),
+			Key:        aws.String(dstKey),
+			CopySource: aws.String(c.copySource(srcKey)),
+		})
+		if err != nil {
+			return fmt.Errorf("copy object: %w", err)
+		}
+		return nil
+	}
+	return c.multipartCopy(ctx, srcKey, dstKey, head)
+}
+
+// multipartCopy copies objects too large for CopyObject. Unlike CopyObject it
+// does not carry the source metadata over, so it is set on the new upload.
+func (c S3ObjectCopier) multipartCopy(ctx context.Context, srcKey, dstKey string, head *s3.HeadObjectOutput) error {
+	size := aws.ToInt64(head.ContentLength)
+	upload, err := c.dst.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:                  aws.String(c.dstBucket),
+		Key:                     aws.String(dstKey),
+		ContentType:             head.ContentType,
+		CacheControl:            head.CacheControl,
+		ContentDisposition:      head.ContentDisposition,
+		ContentEncoding:         head.ContentEncoding,
+		ContentLanguage:         head.ContentLanguage,
+		Expires:                 head.Expires,
+		Metadata:                head.Metadata,
+		WebsiteRedirectLocation: head.WebsiteRedirectLocation,
+	})
+	if err != nil {
+		return fmt.Errorf("create multipart upload: %w", err)
+	}
+	parts := []types.CompletedPart{}
+	partSize := CopyPartSize(size)
+	var partNumber int32 = 1
+	for offset := int64(0); offset < size; offset += partSize {
+		last := min(offset+partSize, size) - 1
+		part, err := c.dst.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:          aws.String(c.dstBucket),
+			Key:             aws.String(dstKey),
+			UploadId:        upload.UploadId,
+			PartNumber:      aws.Int32(partNumber),
+			CopySource:      aws.String(c.copySource(srcKey)),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", offset, last)),
+		})
+		if err != nil {
+			c.abort(ctx, dstKey, upload.UploadId)
+			return fmt.Errorf("upload part copy: %w", err)
+		}
+		parts = append(parts, types.CompletedPart{
+			ETag:       part.CopyPartResult.ETag,
+			PartNumber: aws.Int32(partNumber),
+		})
+		partNumber++
+	}
+	_, err = c.dst.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(c.dstBucket),
+		Key:             aws.String(dstKey),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		c.abort(ctx, dstKey, upload.UploadId)
+		return fmt.Errorf("complete multipart upload: %w", err)
+	}
+	return nil
+}
+
+// CopyPartSize picks the multipart copy part size for an object, parts
+// grow past copyPartSize when the object would not fit in the part limit.
+func CopyPartSize(size int64) int64 {
+	if size <= copyPartSize*maxCopyParts {
+		return copyPartSize
+	}
+	const mib = 1024 * 1024
+	partSize := (size + maxCopyParts - 1) / maxCopyParts
+	return (partSize + mib - 1) / mib * mib
+}
+
+func (c S3ObjectCopier) abort(ctx context.Context, dstKey string, uploadID *string) {
+	_, _ = c.dst.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(c.dstBucket),
+		Key:      aws.String(dstKey),
+		UploadId: uploadID,
+	})
+}
+
+func (c S3ObjectCopier) streamCopy(ctx context.Context, srcKey, dstKey string) error {
+	object, err := c.src.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(c.srcBucket),
+		Key:    aws.String(srcKey),
+	})
+	if err != nil {
+		return fmt.Errorf("get source object: %w", err)
+	}
+	defer object.Body.Close()
+	_, err = manager.NewUploader(c.dst).Upload(ctx, &s3.PutObjectInput{
+		Bucket:                  aws.String(c.dstBucket),
+		Key:                     aws.String(dstKey),
+		Body:                    object.Body,
+		ContentType:             object.ContentType,
+		CacheControl:            object.CacheControl,
+		ContentDisposition:      object.ContentDisposition,
+		ContentEncoding:         object.ContentEncoding,
+		ContentLanguage:         object.ContentLanguage,
+		Expires:                 object.Expires,
+		Metadata:                object.Metadata,
+		WebsiteRedirectLocation: object.WebsiteRedirectLocation,
+	})
+	if err != nil {
+		return fmt.Errorf("upload destination object: %w", err)
+	}
+	return nil
+}
+
+// ListKeys returns every object key under prefix, following continuation tokens.
+func ListKeys(ctx context.Context, s3Client *s3.Client, bucketName, prefix string) ([]string, error) {
+	keys := []string{}
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Contents {
+			keys = append(keys, aws.ToString(item.Key))
+		}
+	}
+	return keys, nil
+}
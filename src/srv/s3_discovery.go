@@ -0,0 +1,137 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package srv
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/blablatdinov/web-s3/src/repo"
+)
+
+type DiscoveredBucket struct {
+	BucketName string     `json:"bucket_name"`
+	Region     string     `json:"region"`
+	CreatedAt  *time.Time `json:"created_at"`
+}
+
+// DiscoverBuckets lists every bucket visible to the credentials and resolves
+// its region. When the storage can not tell the region, the region from the
+// credentials is used.
+func DiscoverBuckets(ctx context.Context, credentials *repo.Bucket) ([]DiscoveredBucket, error) {
+	s3Client, err := CreateS3ClientFromBucket(ctx, credentials)
+	if err != nil {
+		return nil, err
+	}
+	discovered := []DiscoveredBucket{}
+	paginator := s3.NewListBucketsPaginator(s3Client, &s3.ListBucketsInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Buckets {
+			bucketName := aws.ToString(item.Name)
+			region := aws.ToString(item.BucketRegion)
+			if region == "" {
+				region, err = manager.GetBucketRegion(ctx, s3Client, bucketName)
+				if err != nil || region == "" {
+					region = credentials.Region
+				}
+			}
+			discovered = append(discovered, DiscoveredBucket{
+				BucketName: bucketName,
+				Region:     region,
+				CreatedAt:  item.CreationDate,
+			})
+		}
+	}
+	return discovered, nil
+}
+
+// BucketImportRejection explains why a bucket picked for import is refused.
+type BucketImportRejection struct {
+	BucketName string           `json:"bucket_name"`
+	Error      string           `json:"error"`
+	Diagnosis  *BucketDiagnosis `json:"diagnosis,omitempty"`
+}
+
+// ResolveImport checks buckets picked for import on the server side. Each
+// one must be listed for the credentials, which also gives its region, and
+// pass the connection probe, so import can not skip the checks of create.
+func ResolveImport(
+	ctx context.Context,
+	credentials *repo.Bucket,
+	bucketNames []string,
+	probe BucketProbe,
+) ([]repo.NewBucket, []BucketImportRejection, error) {
+	discovered, err := DiscoverBuckets(ctx, credentials)
+	if err != nil {
+		return nil, nil, err
+	}
+	regions := make(map[string]string, len(discovered))
+	for _, bucket := range discovered {
+		regions[bucket.BucketName] = bucket.Region
+	}
+	resolved := []repo.NewBucket{}
+	rejected := []BucketImportRejection{}
+	for _, bucketName := range bucketNames {
+		region, ok := regions[bucketName]
+		if !ok {
+			rejected = append(rejected, BucketImportRejection{
+				BucketName: bucketName,
+				Error:      "Bucket is not visible to the access key",
+			})
+			continue
+		}
+		bucket := repo.NewBucket{
+			BucketName:      bucketName,
+			AccessKeyID:     credentials.AccessKeyID,
+			SecretAccessKey: credentials.SecretAccessKey,
+			Region:          region,
+			Endpoint:        credentials.Endpoint,
+		}
+		diagnosis := probe.Probe(ctx, &repo.Bucket{
+			BucketName:      bucket.BucketName,
+			AccessKeyID:     bucket.AccessKeyID,
+			SecretAccessKey: bucket.SecretAccessKey,
+			Region:          bucket.Region,
+			Endpoint:        bucket.Endpoint,
+		})
+		if !diagnosis.OK {
+			rejected = append(rejected, BucketImportRejection{
+				BucketName: bucketName,
+				Error:      "Bucket connection check failed",
+				Diagnosis:  &diagnosis,
+			})
+			continue
+		}
+		resolved = append(resolved, bucket)
+	}
+	return resolved, rejected, nil
+}
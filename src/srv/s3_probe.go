@@ -0,0 +1,145 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package srv
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/blablatdinov/web-s3/src/repo"
+)
+
+const probeTimeout = 10 * time.Second
+
+const (
+	ProbeOK                  = "ok"
+	ProbeAuthFailed          = "auth_failed"
+	ProbeAccessDenied        = "access_denied"
+	ProbeWrongRegion         = "wrong_region"
+	ProbeBucketNotFound      = "bucket_not_found"
+	ProbeEndpointUnreachable = "endpoint_unreachable"
+	ProbeTLSError            = "tls_error"
+	ProbeUnknownError        = "unknown_error"
+)
+
+type BucketDiagnosis struct {
+	OK              bool   `json:"ok"`
+	Status          string `json:"status"`
+	Message         string `json:"message"`
+	SuggestedRegion string `json:"suggested_region,omitempty"`
+}
+
+type BucketProbe interface {
+	Probe(ctx context.Context, bucket *repo.Bucket) BucketDiagnosis
+}
+
+// S3BucketProbe checks bucket credentials with HeadBucket followed by a
+// single-key listing and explains the first failure it meets.
+type S3BucketProbe struct{}
+
+func S3BucketProbeCtor() BucketProbe {
+	return S3BucketProbe{}
+}
+
+func (p S3BucketProbe) Probe(ctx context.Context, bucket *repo.Bucket) BucketDiagnosis {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	s3Client, err := CreateS3ClientFromBucket(ctx, bucket, func(o *s3.Options) {
+		o.RetryMaxAttempts = 1
+	})
+	if err != nil {
+		return BucketDiagnosis{Status: ProbeUnknownError, Message: err.Error()}
+	}
+	_, err = s3Client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(bucket.BucketName),
+	})
+	if err != nil {
+		diagnosis := DiagnoseS3Error(err)
+		// HeadBucket has no response body, so a 403 does not tell bad
+		// credentials from a missing permission. Listing does.
+		if diagnosis.Status != ProbeAccessDenied {
+			return diagnosis
+		}
+	}
+	_, err = s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucket.BucketName),
+		MaxKeys: aws.Int32(1),
+	})
+	if err != nil {
+		return DiagnoseS3Error(err)
+	}
+	return BucketDiagnosis{OK: true, Status: ProbeOK, Message: "Connection succeeded"}
+}
+
+// DiagnoseS3Error maps an S3 client error onto a BucketDiagnosis.
+func DiagnoseS3Error(err error) BucketDiagnosis {
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var recordHeaderErr tls.RecordHeaderError
+	if errors.As(err, &certErr) || errors.As(err, &unknownAuthorityErr) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &recordHeaderErr) {
+		return BucketDiagnosis{Status: ProbeTLSError, Message: err.Error()}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) {
+		return BucketDiagnosis{Status: ProbeEndpointUnreachable, Message: err.Error()}
+	}
+	var respErr *awshttp.ResponseError
+	if !errors.As(err, &respErr) || respErr.Response == nil || respErr.Response.Response == nil {
+		return BucketDiagnosis{Status: ProbeUnknownError, Message: err.Error()}
+	}
+	code := ""
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		code = apiErr.ErrorCode()
+	}
+	region := respErr.Response.Header.Get("X-Amz-Bucket-Region")
+	switch {
+	case code == "InvalidAccessKeyId" || code == "SignatureDoesNotMatch" || code == "InvalidToken":
+		return BucketDiagnosis{Status: ProbeAuthFailed, Message: "Access key or secret key is invalid"}
+	case code == "PermanentRedirect" || code == "AuthorizationHeaderMalformed" ||
+		code == "IllegalLocationConstraintException" || respErr.HTTPStatusCode() == http.StatusMovedPermanently:
+		return BucketDiagnosis{
+			Status:          ProbeWrongRegion,
+			Message:         "Bucket is located in another region",
+			SuggestedRegion: region,
+		}
+	case code == "NoSuchBucket" || respErr.HTTPStatusCode() == http.StatusNotFound:
+		return BucketDiagnosis{Status: ProbeBucketNotFound, Message: "Bucket does not exist"}
+	case respErr.HTTPStatusCode() == http.StatusForbidden:
+		return BucketDiagnosis{Status: ProbeAccessDenied, Message: "Credentials are not allowed to access the bucket"}
+	}
+	return BucketDiagnosis{Status: ProbeUnknownError, Message: err.Error()}
+}
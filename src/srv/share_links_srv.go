@@ -0,0 +1,158 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2024 Almaz Ilaletdinov <a.ilaletdinov@yandex.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE
+OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package srv
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/blablatdinov/web-s3/src/repo"
+)
+
+var (
+	ErrInvalidShareLink      = errors.New("invalid share link")
+	ErrSharePasswordRequired = errors.New("share link password required")
+	ErrInvalidSharePassword  = errors.New("invalid share link password")
+)
+
+type NewShareLink struct {
+	Key          string
+	Password     string
+	ExpiresAt    *time.Time
+	MaxDownloads *int
+}
+
+type ShareLinks interface {
+	// Create returns the stored link and its token, which is never shown
+	// again. The user must be allowed to read what the link publishes.
+	Create(userID int, bucket *repo.Bucket, link NewShareLink) (repo.ShareLink, string, error)
+	List(userID int) ([]repo.ShareLink, error)
+	Revoke(userID, shareLinkID int) error
+	// Open resolves a link that has not expired, checking its password
+	// when it has one.
+	Open(rawToken, password string) (repo.ShareLink, error)
+	// Download takes one download of the link.
+	Download(link repo.ShareLink) error
+}
+
+type ShareLinksSrv struct {
+	repo  repo.ShareLinksRepo
+	authz BucketAuthz
+}
+
+func ShareLinksSrvCtor(repo repo.ShareLinksRepo, authz BucketAuthz) ShareLinks {
+	return ShareLinksSrv{repo, authz}
+}
+
+// IsShareFolder reports whether the link publishes a prefix rather than a
+// single object.
+func IsShareFolder(link repo.ShareLink) bool {
+	return link.Key == "" || strings.HasSuffix(link.Key, "/")
+}
+
+// IsShareLinkExhausted reports whether every download of the link is taken.
+func IsShareLinkExhausted(link repo.ShareLink) bool {
+	return link.MaxDownloads != nil && link.Downloads >= *link.MaxDownloads
+}
+
+// CountsAsShareDownload tells whether a response of S3 delivers the object
+// from its start. Range requests resuming a download or seeking in a video
+// and revalidations answered with 304 do not take a download.
+func CountsAsShareDownload(contentRange *string) bool {
+	return contentRange == nil || strings.HasPrefix(*contentRange, "bytes 0-")
+}
+
+func (s ShareLinksSrv) Create(userID int, bucket *repo.Bucket, link NewShareLink) (repo.ShareLink, string, error) {
+	key := strings.TrimPrefix(link.Key, "/")
+	err := s.authz.Authorize(userID, bucket, BucketActionRead, key)
+	if err != nil {
+		return repo.ShareLink{}, "", err
+	}
+	var passwordHash *string
+	if link.Password != "" {
+		hash, err := PswrdCtor(link.Password).Hash()
+		if err != nil {
+			return repo.ShareLink{}, "", err
+		}
+		passwordHash = &hash
+	}
+	buf := make([]byte, 24)
+	_, err = rand.Read(buf)
+	if err != nil {
+		return repo.ShareLink{}, "", err
+	}
+	rawToken := hex.EncodeToString(buf)
+	created, err := s.repo.Create(userID, repo.NewShareLink{
+		BucketID:     bucket.BucketID,
+		Key:          key,
+		TokenHash:    hashToken(rawToken),
+		Prefix:       rawToken[:8],
+		PasswordHash: passwordHash,
+		ExpiresAt:    link.ExpiresAt,
+		MaxDownloads: link.MaxDownloads,
+	})
+	if err != nil {
+		return repo.ShareLink{}, "", err
+	}
+	return created, rawToken, nil
+}
+
+func (s ShareLinksSrv) List(userID int) ([]repo.ShareLink, error) {
+	return s.repo.List(userID)
+}
+
+func (s ShareLinksSrv) Revoke(userID, shareLinkID int) error {
+	return s.repo.Delete(userID, shareLinkID)
+}
+
+func (s ShareLinksSrv) Open(rawToken, password string) (repo.ShareLink, error) {
+	link, err := s.repo.ByHash(hashToken(rawToken))
+	if err != nil {
+		if errors.Is(err, repo.ErrShareLinkNotFound) {
+			return repo.ShareLink{}, ErrInvalidShareLink
+		}
+		return repo.ShareLink{}, err
+	}
+	if link.ExpiresAt != nil && time.Now().After(*link.ExpiresAt) {
+		return repo.ShareLink{}, fmt.Errorf("%w: expired", ErrInvalidShareLink)
+	}
+	if link.PasswordHash != nil {
+		if password == "" {
+			return repo.ShareLink{}, ErrSharePasswordRequired
+		}
+		if !PswrdCtor(password).Check(*link.PasswordHash) {
+			return repo.ShareLink{}, ErrInvalidSharePassword
+		}
+	}
+	return link, nil
+}
+
+func (s ShareLinksSrv) Download(link repo.ShareLink) error {
+	return s.repo.CountDownload(link.ShareLinkID)
+}
@@ -1,6 +1,8 @@
 package srv
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"time"
@@ -9,39 +11,300 @@ import (
 	"github.com/golang-jwt/jwt"
 )
 
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+	MfaTokenTTL     = 5 * time.Minute
+
+	accessTokenType  = "access"
+	refreshTokenType = "refresh"
+	mfaTokenType     = "mfa"
+)
+
+var (
+	ErrInvalidPassword = errors.New("invalid password")
+	ErrInvalidToken    = errors.New("invalid token")
+	ErrTokenRevoked    = errors.New("token revoked")
+)
+
+// Tokens holds either a token pair or, when the user has to pass the second
+// factor, only MfaToken to exchange with VerifyMfa.
+type Tokens struct {
+	Access   string
+	Refresh  string
+	MfaToken string
+}
+
+// Client describes where a request came from.
+type Client struct {
+	IP        string
+	UserAgent string
+}
+
 type UserAuth interface {
-	Jwt(string, string) (string, error)
+	Jwt(username, password string, client Client) (Tokens, error)
+	VerifyMfa(mfaToken, code string, client Client) (Tokens, error)
+	MfaUsername(mfaToken string) (string, error)
+	Login(userID int, username string, client Client) (Tokens, error)
+	StartSession(userID int, username string, client Client) (Tokens, error)
+	// CheckPassword confirms the password of a logged in user against the
+	// same backends as Jwt, for actions that weaken the account.
+	CheckPassword(userID int, username, password string) error
+	Refresh(refreshToken string, client Client) (Tokens, error)
+	Logout(accessToken string) error
+	Touch(sessionID string, client Client) error
+	Sessions(userID int) ([]repo.Session, error)
+	RevokeSession(userID int, sessionID string) error
+	RevokeUser(userID int) error
 	Validate(token string) (bool, error)
 	ExtractClaims(token string) (jwt.MapClaims, error)
 }
 
 type UserAuthSrv struct {
-	secretKey string
-	repo      repo.UserAuthRepo
+	secretKey     string
+	authenticator Authenticator
+	tokenStore    repo.TokenStore
+	mfa           Mfa
 }
 
-func UserAuthSrvCtor(secretKey string, repo repo.UserAuthRepo) UserAuth {
-	return UserAuthSrv{secretKey, repo}
+func UserAuthSrvCtor(secretKey string, authenticator Authenticator, tokenStore repo.TokenStore, mfa Mfa) UserAuth {
+	return UserAuthSrv{secretKey, authenticator, tokenStore, mfa}
+}
+
+func (u UserAuthSrv) Jwt(Username, Password string, client Client) (Tokens, error) {
+	userId, Username, err := u.authenticator.Authenticate(Username, Password)
+	if err != nil {
+		return Tokens{}, err
+	}
+	return u.Login(userId, Username, client)
+}
+
+// Login logs in a user whose first factor was checked by the caller, for
+// example by an external identity provider. Users with MFA enabled get
+// only an mfa token, the same as from Jwt.
+func (u UserAuthSrv) Login(userID int, username string, client Client) (Tokens, error) {
+	mfaEnabled, err := u.mfa.Enabled(userID)
+	if err != nil {
+		return Tokens{}, err
+	}
+	if mfaEnabled {
+		mfaToken, err := u.mfaToken(userID, username)
+		if err != nil {
+			return Tokens{}, err
+		}
+		return Tokens{MfaToken: mfaToken}, nil
+	}
+	return u.StartSession(userID, username, client)
+}
+
+func (u UserAuthSrv) CheckPassword(userID int, username, password string) error {
+	authenticatedID, _, err := u.authenticator.Authenticate(username, password)
+	if err != nil {
+		if errors.Is(err, repo.ErrUserNotFound) {
+			return ErrInvalidPassword
+		}
+		return err
+	}
+	if authenticatedID != userID {
+		return ErrInvalidPassword
+	}
+	return nil
+}
+
+// VerifyMfa finishes a login started by Jwt for a user with MFA enabled.
+// The mfa token is single use, a wrong code spends it too.
+func (u UserAuthSrv) VerifyMfa(mfaToken, code string, client Client) (Tokens, error) {
+	claims, err := u.parse(mfaToken, mfaTokenType)
+	if err != nil {
+		return Tokens{}, err
+	}
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		return Tokens{}, fmt.Errorf("%w: user_id claim is missing", ErrInvalidToken)
+	}
+	jti, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+	// The token is spent before the code is checked, so concurrent requests
+	// can not try several codes or log in twice with one token.
+	consumed, err := u.tokenStore.Consume(jti, time.Until(time.Unix(int64(exp), 0)))
+	if err != nil {
+		return Tokens{}, err
+	}
+	if !consumed {
+		return Tokens{}, ErrTokenRevoked
+	}
+	err = u.mfa.Verify(int(userID), code)
+	if err != nil {
+		return Tokens{}, err
+	}
+	username, _ := claims["username"].(string)
+	return u.StartSession(int(userID), username, client)
 }
 
-func (u UserAuthSrv) Jwt(Username, Password string) (string, error) {
-	userId, err := u.repo.UserId(Username)
+func (u UserAuthSrv) MfaUsername(mfaToken string) (string, error) {
+	claims, err := u.parse(mfaToken, mfaTokenType)
 	if err != nil {
 		return "", err
 	}
-	passwordHash, err := u.repo.PasswordHash(Username)
+	username, _ := claims["username"].(string)
+	return username, nil
+}
+
+func (u UserAuthSrv) mfaToken(userID int, username string) (string, error) {
+	jti, err := randomID()
 	if err != nil {
 		return "", err
 	}
-	passValid := PswrdCtor(Password).Check(passwordHash)
-	if !passValid {
-		return "", errors.New("invalid password")
+	now := time.Now()
+	return u.sign(jwt.MapClaims{
+		"user_id":  userID,
+		"username": username,
+		"jti":      jti,
+		"type":     mfaTokenType,
+		"iat":      now.Unix(),
+		"exp":      now.Add(MfaTokenTTL).Unix(),
+	})
+}
+
+// StartSession issues a session without asking for the second factor,
+// callers have to check it first.
+func (u UserAuthSrv) StartSession(userID int, username string, client Client) (Tokens, error) {
+	sessionID, err := randomID()
+	if err != nil {
+		return Tokens{}, err
 	}
-	claims := jwt.MapClaims{
-		"user_id":  userId,
-		"username": Username,
-		"exp":      time.Now().Add(time.Hour * 72).Unix(),
+	tokens, refreshJti, err := u.issue(userID, username, sessionID)
+	if err != nil {
+		return Tokens{}, err
+	}
+	now := time.Now()
+	err = u.tokenStore.CreateSession(repo.Session{
+		SessionID:  sessionID,
+		UserID:     userID,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		IP:         client.IP,
+		UserAgent:  client.UserAgent,
+	}, refreshJti, RefreshTokenTTL)
+	if err != nil {
+		return Tokens{}, err
+	}
+	return tokens, nil
+}
+
+// Refresh exchanges a refresh token for a new token pair. Every refresh
+// token can be used once: presenting an already rotated one revokes the
+// whole session.
+func (u UserAuthSrv) Refresh(refreshToken string, client Client) (Tokens, error) {
+	claims, err := u.parse(refreshToken, refreshTokenType)
+	if err != nil {
+		return Tokens{}, err
 	}
+	userID, sessionID, jti, err := sessionClaims(claims)
+	if err != nil {
+		return Tokens{}, err
+	}
+	username, _ := claims["username"].(string)
+	tokens, refreshJti, err := u.issue(userID, username, sessionID)
+	if err != nil {
+		return Tokens{}, err
+	}
+	err = u.tokenStore.RotateSession(userID, sessionID, jti, refreshJti, RefreshTokenTTL)
+	if err != nil {
+		if errors.Is(err, repo.ErrSessionNotFound) {
+			return Tokens{}, ErrTokenRevoked
+		}
+		if errors.Is(err, repo.ErrRefreshReused) {
+			err = u.tokenStore.DeleteSession(userID, sessionID)
+			if err != nil && !errors.Is(err, repo.ErrSessionNotFound) {
+				return Tokens{}, err
+			}
+			return Tokens{}, fmt.Errorf("%w: refresh token reused", ErrTokenRevoked)
+		}
+		return Tokens{}, err
+	}
+	err = u.Touch(sessionID, client)
+	if err != nil {
+		return Tokens{}, err
+	}
+	return tokens, nil
+}
+
+// Logout denies the access token and drops its session, so the paired
+// refresh token stops working too.
+func (u UserAuthSrv) Logout(accessToken string) error {
+	claims, err := u.ExtractClaims(accessToken)
+	if err != nil {
+		return err
+	}
+	userID, sessionID, jti, err := sessionClaims(claims)
+	if err != nil {
+		return err
+	}
+	exp, _ := claims["exp"].(float64)
+	err = u.tokenStore.Deny(jti, time.Until(time.Unix(int64(exp), 0)))
+	if err != nil {
+		return err
+	}
+	return u.tokenStore.DeleteSession(userID, sessionID)
+}
+
+func (u UserAuthSrv) Touch(sessionID string, client Client) error {
+	return u.tokenStore.TouchSession(sessionID, time.Now(), client.IP, client.UserAgent)
+}
+
+func (u UserAuthSrv) Sessions(userID int) ([]repo.Session, error) {
+	return u.tokenStore.Sessions(userID)
+}
+
+func (u UserAuthSrv) RevokeSession(userID int, sessionID string) error {
+	return u.tokenStore.DeleteSession(userID, sessionID)
+}
+
+func (u UserAuthSrv) RevokeUser(userID int) error {
+	return u.tokenStore.DeleteUserSessions(userID)
+}
+
+// issue signs a new token pair for the session and returns it along with
+// the refresh token id the session has to remember.
+func (u UserAuthSrv) issue(userID int, username, sessionID string) (Tokens, string, error) {
+	now := time.Now()
+	accessJti, err := randomID()
+	if err != nil {
+		return Tokens{}, "", err
+	}
+	refreshJti, err := randomID()
+	if err != nil {
+		return Tokens{}, "", err
+	}
+	access, err := u.sign(jwt.MapClaims{
+		"user_id":  userID,
+		"username": username,
+		"sid":      sessionID,
+		"jti":      accessJti,
+		"type":     accessTokenType,
+		"iat":      now.Unix(),
+		"exp":      now.Add(AccessTokenTTL).Unix(),
+	})
+	if err != nil {
+		return Tokens{}, "", err
+	}
+	refresh, err := u.sign(jwt.MapClaims{
+		"user_id":  userID,
+		"username": username,
+		"sid":      sessionID,
+		"jti":      refreshJti,
+		"type":     refreshTokenType,
+		"iat":      now.Unix(),
+		"exp":      now.Add(RefreshTokenTTL).Unix(),
+	})
+	if err != nil {
+		return Tokens{}, "", err
+	}
+	return Tokens{Access: access, Refresh: refresh}, refreshJti, nil
+}
+
+func (u UserAuthSrv) sign(claims jwt.MapClaims) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	t, err := token.SignedString([]byte(u.secretKey))
 	if err != nil {
@@ -50,7 +313,7 @@ func (u UserAuthSrv) Jwt(Username, Password string) (string, error) {
 	return t, nil
 }
 
-func (u UserAuthSrv) Validate(token string) (bool, error) {
+func (u UserAuthSrv) parse(token, tokenType string) (jwt.MapClaims, error) {
 	parsedToken, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
@@ -58,30 +321,78 @@ func (u UserAuthSrv) Validate(token string) (bool, error) {
 		return []byte(u.secretKey), nil
 	})
 	if err != nil {
-		return false, err
+		return nil, fmt.Errorf("%w: %s", ErrInvalidToken, err)
 	}
 	if !parsedToken.Valid {
-		return false, errors.New("token is not valid")
+		return nil, fmt.Errorf("%w: token is not valid", ErrInvalidToken)
+	}
+	claims, ok := parsedToken.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("%w: invalid token claims", ErrInvalidToken)
+	}
+	if claims["type"] != tokenType {
+		return nil, fmt.Errorf("%w: expected %s token", ErrInvalidToken, tokenType)
+	}
+	return claims, nil
+}
+
+func (u UserAuthSrv) Validate(token string) (bool, error) {
+	_, err := u.ExtractClaims(token)
+	if err != nil {
+		return false, err
 	}
 	return true, nil
 }
 
+// ExtractClaims returns claims of a valid access token that was neither
+// denied on logout nor issued for a revoked session.
 func (u UserAuthSrv) ExtractClaims(token string) (jwt.MapClaims, error) {
-	parsedToken, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(u.secretKey), nil
-	})
+	claims, err := u.parse(token, accessTokenType)
 	if err != nil {
 		return nil, err
 	}
-	if !parsedToken.Valid {
-		return nil, errors.New("token is not valid")
+	_, sessionID, jti, err := sessionClaims(claims)
+	if err != nil {
+		return nil, err
 	}
-	claims, ok := parsedToken.Claims.(jwt.MapClaims)
-	if !ok {
-		return nil, errors.New("invalid token claims")
+	denied, err := u.tokenStore.IsDenied(jti)
+	if err != nil {
+		return nil, err
+	}
+	if denied {
+		return nil, ErrTokenRevoked
+	}
+	_, err = u.tokenStore.RefreshJti(sessionID)
+	if err != nil {
+		if errors.Is(err, repo.ErrSessionNotFound) {
+			return nil, ErrTokenRevoked
+		}
+		return nil, err
 	}
 	return claims, nil
 }
+
+func sessionClaims(claims jwt.MapClaims) (int, string, string, error) {
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		return 0, "", "", fmt.Errorf("%w: user_id claim is missing", ErrInvalidToken)
+	}
+	sessionID, ok := claims["sid"].(string)
+	if !ok || sessionID == "" {
+		return 0, "", "", fmt.Errorf("%w: sid claim is missing", ErrInvalidToken)
+	}
+	jti, ok := claims["jti"].(string)
+	if !ok || jti == "" {
+		return 0, "", "", fmt.Errorf("%w: jti claim is missing", ErrInvalidToken)
+	}
+	return int(userID), sessionID, jti, nil
+}
+
+func randomID() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
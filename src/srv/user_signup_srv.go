@@ -16,17 +16,22 @@ type UserSignupSrv interface {
 }
 
 type UsrSignupSrv struct {
-	repo repo.UserSignupRepo
+	repo   repo.UserSignupRepo
+	policy PasswordPolicy
 }
 
-func UsrSignupSrvCtor(repo repo.UserSignupRepo) UserSignupSrv {
-	return UsrSignupSrv{repo}
+func UsrSignupSrvCtor(repo repo.UserSignupRepo, policy PasswordPolicy) UserSignupSrv {
+	return UsrSignupSrv{repo, policy}
 }
 
 func (u UsrSignupSrv) Create(username, rawPassword string) (int, error) {
 	if username == "" {
 		return 0, fmt.Errorf("%w", ErrUsernameEmpty)
 	}
+	err := u.policy.Check(username, rawPassword)
+	if err != nil {
+		return 0, err
+	}
 	hashedPassword, err := PswrdCtor(rawPassword).Hash()
 	if err != nil {
 		return 0, err
@@ -0,0 +1,57 @@
+package repo_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	repo "github.com/blablatdinov/web-s3/src/repo"
+)
+
+func TestSecretCipherRoundTrip(t *testing.T) {
+	cipher, err := repo.AesGcmSecretCipherCtor(map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}, "k1")
+	if err != nil {
+		t.Fatalf("Fail on create cipher: %s", err.Error())
+	}
+	secret, err := cipher.Encrypt("fkSecretAccessKey")
+	if err != nil {
+		t.Fatalf("Fail on encrypt: %s", err.Error())
+	}
+	if secret.KeyID != "k1" || secret.Ciphertext == "fkSecretAccessKey" {
+		t.Fatalf("Unexpected encrypted secret: %+v", secret)
+	}
+	plaintext, err := cipher.Decrypt(secret)
+	if err != nil {
+		t.Fatalf("Fail on decrypt: %s", err.Error())
+	}
+	if plaintext != "fkSecretAccessKey" {
+		t.Fatalf("Decrypted secret not matched: %s", plaintext)
+	}
+}
+
+func TestSecretCipherRewrap(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+	oldCipher, _ := repo.AesGcmSecretCipherCtor(map[string][]byte{"k1": oldKey}, "k1")
+	secret, err := oldCipher.Encrypt("fkSecretAccessKey")
+	if err != nil {
+		t.Fatalf("Fail on encrypt: %s", err.Error())
+	}
+	rotatedCipher, _ := repo.AesGcmSecretCipherCtor(map[string][]byte{"k1": oldKey, "k2": newKey}, "k2")
+	rewrapped, err := rotatedCipher.Rewrap(secret)
+	if err != nil {
+		t.Fatalf("Fail on rewrap: %s", err.Error())
+	}
+	newCipher, _ := repo.AesGcmSecretCipherCtor(map[string][]byte{"k2": newKey}, "k2")
+	plaintext, err := newCipher.Decrypt(rewrapped)
+	if err != nil {
+		t.Fatalf("Fail on decrypt rewrapped secret: %s", err.Error())
+	}
+	if plaintext != "fkSecretAccessKey" {
+		t.Fatalf("Decrypted secret not matched: %s", plaintext)
+	}
+	_, err = newCipher.Decrypt(secret)
+	if !errors.Is(err, repo.ErrUnknownMasterKey) {
+		t.Fatalf("Expected unknown master key error, got %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package srv_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	repo "github.com/blablatdinov/web-s3/src/repo"
+	srv "github.com/blablatdinov/web-s3/src/srv"
+)
+
+func TestApiTokens(t *testing.T) {
+	apiTokens := srv.ApiTokensSrvCtor(repo.FkApiTokensRepoCtor())
+	_, _, err := apiTokens.Create(1, "ci", "admin", nil, nil)
+	if !errors.Is(err, srv.ErrInvalidScope) {
+		t.Fatalf("Unknown scope accepted")
+	}
+	created, rawToken, err := apiTokens.Create(1, "ci", repo.ApiTokenScopeRead, []int{3}, nil)
+	if err != nil {
+		t.Fatalf("Fail on create token: %s", err)
+	}
+	if !srv.IsApiToken(rawToken) || !strings.HasPrefix(rawToken, created.Prefix) {
+		t.Fatalf("Unexpected token %s with prefix %s", rawToken, created.Prefix)
+	}
+	token, err := apiTokens.Authenticate(rawToken)
+	if err != nil {
+		t.Fatalf("Fail on authenticate: %s", err)
+	}
+	if token.UserID != 1 || token.LastUsedAt == nil || len(token.BucketIDs) != 1 {
+		t.Fatalf("Unexpected token %+v", token)
+	}
+	_, err = apiTokens.Authenticate(rawToken + "0")
+	if !errors.Is(err, srv.ErrInvalidApiToken) {
+		t.Fatalf("Unknown token accepted")
+	}
+	err = apiTokens.Revoke(2, created.ApiTokenID)
+	if !errors.Is(err, repo.ErrApiTokenNotFound) {
+		t.Fatalf("Token of another user revoked")
+	}
+	err = apiTokens.Revoke(1, created.ApiTokenID)
+	if err != nil {
+		t.Fatalf("Fail on revoke: %s", err)
+	}
+	_, err = apiTokens.Authenticate(rawToken)
+	if !errors.Is(err, srv.ErrInvalidApiToken) {
+		t.Fatalf("Revoked token accepted")
+	}
+}
+
+func TestExpiredApiToken(t *testing.T) {
+	apiTokens := srv.ApiTokensSrvCtor(repo.FkApiTokensRepoCtor())
+	expiresAt := time.Now().Add(-time.Minute)
+	_, rawToken, err := apiTokens.Create(1, "ci", repo.ApiTokenScopeReadWrite, nil, &expiresAt)
+	if err != nil {
+		t.Fatalf("Fail on create token: %s", err)
+	}
+	_, err = apiTokens.Authenticate(rawToken)
+	if !errors.Is(err, srv.ErrInvalidApiToken) {
+		t.Fatalf("Expired token accepted")
+	}
+}
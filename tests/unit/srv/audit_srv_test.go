@@ -0,0 +1,114 @@
+package srv_test
+
+import (
+	"errors"
+	"testing"
+
+	repo "github.com/blablatdinov/web-s3/src/repo"
+	srv "github.com/blablatdinov/web-s3/src/srv"
+)
+
+type fkAuditBuckets struct {
+	repo.BucketsRepo
+	buckets []repo.Bucket
+}
+
+func (r fkAuditBuckets) List(userID int) ([]repo.Bucket, error) {
+	return r.buckets, nil
+}
+
+func fkAuditLog(buckets ...repo.Bucket) srv.AuditLog {
+	return srv.AuditLogSrvCtor(
+		repo.FkAuditRepoCtor(),
+		repo.FkAttemptStoreCtor(),
+		fkAuditBuckets{buckets: buckets},
+		fkBucketAuthz(map[string]int{"alice": 1, "bob": 2}),
+		[]int{3},
+	)
+}
+
+func auditEntry(userID, bucketID, status int) repo.AuditEntry {
+	return repo.AuditEntry{UserID: &userID, BucketID: &bucketID, Action: "GET /files", Status: status}
+}
+
+func TestAuditVisibility(t *testing.T) {
+	auditLog := fkAuditLog(repo.Bucket{BucketID: 1, UserID: 1})
+	for _, entry := range []repo.AuditEntry{auditEntry(1, 1, 200), auditEntry(2, 1, 403), auditEntry(2, 2, 200)} {
+		err := auditLog.Record(entry)
+		if err != nil {
+			t.Fatalf("Fail on record: %s", err)
+		}
+	}
+	owned, err := auditLog.Search(1, repo.AuditFilter{})
+	if err != nil {
+		t.Fatalf("Fail on search: %s", err)
+	}
+	if len(owned) != 2 || owned[0].Result != srv.AuditResultDenied || owned[1].Result != srv.AuditResultSuccess {
+		t.Fatalf("Owner sees %+v", owned)
+	}
+	own, err := auditLog.Search(2, repo.AuditFilter{})
+	if err != nil {
+		t.Fatalf("Fail on search: %s", err)
+	}
+	if len(own) != 2 {
+		t.Fatalf("User sees %d entries, expected own 2", len(own))
+	}
+	all, err := auditLog.Search(3, repo.AuditFilter{})
+	if err != nil {
+		t.Fatalf("Fail on search: %s", err)
+	}
+	if len(all) != 3 {
+		t.Fatalf("Audit admin sees %d entries", len(all))
+	}
+}
+
+func TestAuditPages(t *testing.T) {
+	auditLog := fkAuditLog()
+	for range 5 {
+		err := auditLog.Record(auditEntry(1, 1, 500))
+		if err != nil {
+			t.Fatalf("Fail on record: %s", err)
+		}
+	}
+	_, err := auditLog.Search(1, repo.AuditFilter{Result: "ok"})
+	if !errors.Is(err, srv.ErrInvalidAuditResult) {
+		t.Fatalf("Unknown result accepted")
+	}
+	filter := repo.AuditFilter{Result: srv.AuditResultFailure, Limit: 2}
+	seen := 0
+	for {
+		page, err := auditLog.Search(1, filter)
+		if err != nil {
+			t.Fatalf("Fail on search: %s", err)
+		}
+		seen += len(page)
+		if len(page) < filter.Limit {
+			break
+		}
+		filter.BeforeID = page[len(page)-1].AuditID
+	}
+	if seen != 5 {
+		t.Fatalf("Paged through %d entries, expected 5", seen)
+	}
+}
+
+func TestAuditAnonymousFailuresThrottled(t *testing.T) {
+	auditLog := fkAuditLog()
+	for range 100 {
+		err := auditLog.Record(repo.AuditEntry{Action: "GET /files", IP: "10.0.0.1", Status: 401})
+		if err != nil {
+			t.Fatalf("Fail on record: %s", err)
+		}
+	}
+	err := auditLog.Record(auditEntry(1, 1, 403))
+	if err != nil {
+		t.Fatalf("Fail on record: %s", err)
+	}
+	entries, err := auditLog.Search(3, repo.AuditFilter{Limit: srv.MaxAuditPageSize})
+	if err != nil {
+		t.Fatalf("Fail on search: %s", err)
+	}
+	if len(entries) >= 100 || entries[0].UserID == nil {
+		t.Fatalf("Anonymous failures not throttled, %d entries", len(entries))
+	}
+}
@@ -0,0 +1,83 @@
+package srv_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	repo "github.com/blablatdinov/web-s3/src/repo"
+	srv "github.com/blablatdinov/web-s3/src/srv"
+)
+
+func TestLoginRateLimit(t *testing.T) {
+	limits := srv.DefaultAuthLimits()
+	limits.LoginPerIP = 3
+	limiter := srv.AuthLimiterCtor(limits, repo.FkAttemptStoreCtor())
+	client := srv.Client{IP: "127.0.0.1"}
+	for i := range 3 {
+		err := limiter.AllowLogin("user"+string(rune('a'+i)), client)
+		if err != nil {
+			t.Fatalf("Attempt %d rejected: %s", i, err)
+		}
+	}
+	err := limiter.AllowLogin("user", client)
+	var throttled srv.TooManyAttemptsError
+	if !errors.As(err, &throttled) || throttled.RetryAfter <= 0 {
+		t.Fatalf("Expected throttling, got %v", err)
+	}
+	err = limiter.AllowLogin("user", srv.Client{IP: "127.0.0.2"})
+	if err != nil {
+		t.Fatalf("Other IP throttled: %s", err)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	limits := srv.DefaultAuthLimits()
+	limits.LockoutThreshold = 2
+	limits.LockoutBase = time.Minute
+	limiter := srv.AuthLimiterCtor(limits, repo.FkAttemptStoreCtor())
+	client := srv.Client{IP: "127.0.0.1"}
+	var throttled srv.TooManyAttemptsError
+	for range 2 {
+		if err := limiter.LoginFailed("User1"); err != nil {
+			t.Fatalf("Fail on record failure: %s", err)
+		}
+	}
+	err := limiter.AllowLogin("user1", client)
+	if !errors.As(err, &throttled) || throttled.RetryAfter > time.Minute {
+		t.Fatalf("Expected one minute lockout, got %v", err)
+	}
+	if err = limiter.LoginFailed("user1"); err != nil {
+		t.Fatalf("Fail on record failure: %s", err)
+	}
+	err = limiter.AllowLogin("user1", client)
+	if !errors.As(err, &throttled) || throttled.RetryAfter <= time.Minute {
+		t.Fatalf("Expected lockout to double, got %v", err)
+	}
+	if err = limiter.LoginSucceeded("user1"); err != nil {
+		t.Fatalf("Fail on reset: %s", err)
+	}
+	if err = limiter.AllowLogin("user1", client); err != nil {
+		t.Fatalf("Lockout not reset: %s", err)
+	}
+}
+
+func TestSharePasswordLimitSeparateFromLogin(t *testing.T) {
+	limits := srv.DefaultAuthLimits()
+	limits.LockoutThreshold = 1
+	limits.SharePasswordPerIP = 1
+	limiter := srv.AuthLimiterCtor(limits, repo.FkAttemptStoreCtor())
+	client := srv.Client{IP: "127.0.0.1"}
+	if err := limiter.AllowSharePassword("abcdefgh", client); err != nil {
+		t.Fatalf("Fail on first share password attempt: %s", err)
+	}
+	if err := limiter.AllowSharePassword("ijklmnop", client); !errors.Is(err, srv.ErrTooManyAttempts) {
+		t.Fatalf("Share password attempts not throttled per IP, got %v", err)
+	}
+	if err := limiter.SharePasswordFailed("abcdefgh"); err != nil {
+		t.Fatalf("Fail on record failure: %s", err)
+	}
+	if err := limiter.AllowLogin("share:abcdefgh", client); err != nil {
+		t.Fatalf("Share link lockout leaked into logins: %s", err)
+	}
+}
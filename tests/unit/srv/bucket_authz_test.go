@@ -0,0 +1,123 @@
+package srv_test
+
+import (
+	"errors"
+	"testing"
+
+	repo "github.com/blablatdinov/web-s3/src/repo"
+	srv "github.com/blablatdinov/web-s3/src/srv"
+)
+
+// fkBucketAuthz builds grant based authorization over fake users and orgs,
+// shared by the tests of services that check bucket permissions.
+func fkBucketAuthz(users map[string]int) srv.BucketAuthz {
+	orgs := repo.FkOrgsRepoCtor(users)
+	return srv.GrantsBucketAuthzCtor(repo.FkBucketGrantsRepoCtor(users, orgs), orgs)
+}
+
+func TestBucketAuthzRoles(t *testing.T) {
+	authz := fkBucketAuthz(map[string]int{"alice": 1, "bob": 2, "carol": 3})
+	bucket := &repo.Bucket{BucketID: 1, UserID: 1}
+	err := authz.Authorize(1, bucket, srv.BucketActionManage, "")
+	if err != nil {
+		t.Fatalf("Owner can not manage the bucket: %s", err)
+	}
+	err = authz.Authorize(2, bucket, srv.BucketActionRead, "a.txt")
+	if !errors.Is(err, srv.ErrBucketForbidden) {
+		t.Fatalf("Stranger reads the bucket")
+	}
+	_, err = authz.Grant(1, bucket, repo.NewBucketGrant{Username: "bob", Role: "owner"})
+	if !errors.Is(err, srv.ErrInvalidBucketRole) {
+		t.Fatalf("Unknown role granted")
+	}
+	_, err = authz.Grant(1, bucket, repo.NewBucketGrant{Username: "bob", Role: repo.BucketRoleUploader})
+	if err != nil {
+		t.Fatalf("Fail on grant: %s", err)
+	}
+	err = authz.Authorize(2, bucket, srv.BucketActionWrite, "a.txt")
+	if err != nil {
+		t.Fatalf("Uploader can not upload: %s", err)
+	}
+	err = authz.Authorize(2, bucket, srv.BucketActionDelete, "a.txt")
+	if !errors.Is(err, srv.ErrBucketForbidden) {
+		t.Fatalf("Uploader deletes")
+	}
+	err = authz.Authorize(2, bucket, srv.BucketActionOverwrite, "a.txt")
+	if !errors.Is(err, srv.ErrBucketForbidden) {
+		t.Fatalf("Uploader overwrites")
+	}
+	_, err = authz.Grant(2, bucket, repo.NewBucketGrant{Username: "carol", Role: repo.BucketRoleViewer})
+	if !errors.Is(err, srv.ErrBucketForbidden) {
+		t.Fatalf("Uploader grants roles")
+	}
+}
+
+func TestBucketAuthzPrefixes(t *testing.T) {
+	authz := fkBucketAuthz(map[string]int{"alice": 1, "bob": 2})
+	bucket := &repo.Bucket{BucketID: 1, UserID: 1}
+	grant, err := authz.Grant(1, bucket, repo.NewBucketGrant{Username: "bob", Role: repo.BucketRoleEditor, Prefix: "/reports/"})
+	if err != nil || grant.Prefix != "reports/" {
+		t.Fatalf("Unexpected grant %+v, err=%v", grant, err)
+	}
+	err = authz.Authorize(2, bucket, srv.BucketActionDelete, "reports/2024.csv")
+	if err != nil {
+		t.Fatalf("Editor can not delete inside the prefix: %s", err)
+	}
+	err = authz.Authorize(2, bucket, srv.BucketActionOverwrite, "reports/2024.csv")
+	if err != nil {
+		t.Fatalf("Editor can not overwrite inside the prefix: %s", err)
+	}
+	err = authz.Authorize(2, bucket, srv.BucketActionRead, "reports/2024.csv", "secret.txt")
+	if !errors.Is(err, srv.ErrBucketForbidden) {
+		t.Fatalf("Editor reads outside the prefix")
+	}
+	permissions, err := authz.Permissions(2, bucket)
+	if err != nil {
+		t.Fatalf("Fail on permissions: %s", err)
+	}
+	if !permissions.Browsable("") || !permissions.Browsable("reports/q1/") || permissions.Browsable("private/") {
+		t.Fatalf("Unexpected browsable prefixes")
+	}
+	err = authz.Revoke(2, bucket, grant.BucketGrantID)
+	if !errors.Is(err, srv.ErrBucketForbidden) {
+		t.Fatalf("Editor revoked a grant")
+	}
+	err = authz.Revoke(1, bucket, grant.BucketGrantID)
+	if err != nil {
+		t.Fatalf("Fail on revoke: %s", err)
+	}
+	err = authz.Authorize(2, bucket, srv.BucketActionRead, "reports/2024.csv")
+	if !errors.Is(err, srv.ErrBucketForbidden) {
+		t.Fatalf("Revoked grant still applies")
+	}
+}
+
+func TestBucketAuthzOrgs(t *testing.T) {
+	users := map[string]int{"alice": 1, "bob": 2}
+	orgs := repo.FkOrgsRepoCtor(users)
+	authz := srv.GrantsBucketAuthzCtor(repo.FkBucketGrantsRepoCtor(users, orgs), orgs)
+	orgID, _ := orgs.Create(1, "team")
+	invite, _ := orgs.Invite(orgID, 1, "bob")
+	_, _ = orgs.AcceptInvite(2, invite.OrgInviteID)
+	bucket := &repo.Bucket{BucketID: 1, UserID: 3, OrgID: &orgID}
+	err := authz.Authorize(1, bucket, srv.BucketActionDelete, "a.txt")
+	if err != nil {
+		t.Fatalf("Organization owner can not delete: %s", err)
+	}
+	err = authz.Authorize(2, bucket, srv.BucketActionRead, "a.txt")
+	if err != nil {
+		t.Fatalf("Member can not read: %s", err)
+	}
+	err = authz.Authorize(2, bucket, srv.BucketActionDelete, "a.txt")
+	if !errors.Is(err, srv.ErrBucketForbidden) {
+		t.Fatalf("Member deletes without a grant")
+	}
+	_, err = authz.Grant(1, bucket, repo.NewBucketGrant{OrgID: &orgID, Role: repo.BucketRoleEditor})
+	if err != nil {
+		t.Fatalf("Fail on group grant: %s", err)
+	}
+	err = authz.Authorize(2, bucket, srv.BucketActionDelete, "a.txt")
+	if err != nil {
+		t.Fatalf("Group grant does not apply: %s", err)
+	}
+}
@@ -0,0 +1,91 @@
+package srv_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	repo "github.com/blablatdinov/web-s3/src/repo"
+	srv "github.com/blablatdinov/web-s3/src/srv"
+)
+
+func fkDropLinks() srv.DropLinks {
+	return srv.DropLinksSrvCtor(repo.FkDropLinksRepoCtor(), fkBucketAuthz(map[string]int{"alice": 1, "bob": 2}))
+}
+
+func TestDropLinkLimits(t *testing.T) {
+	dropLinks := fkDropLinks()
+	bucket := &repo.Bucket{BucketID: 1, UserID: 1}
+	_, _, err := dropLinks.Create(2, bucket, srv.NewDropLink{Prefix: "inbox"})
+	if !errors.Is(err, srv.ErrBucketForbidden) {
+		t.Fatalf("Stranger created a drop link")
+	}
+	maxFileSize := int64(100)
+	quota := int64(150)
+	created, rawToken, err := dropLinks.Create(1, bucket, srv.NewDropLink{
+		Prefix:            "/inbox",
+		MaxFileSize:       &maxFileSize,
+		AllowedExtensions: []string{"PDF", ".zip"},
+		QuotaBytes:        &quota,
+	})
+	if err != nil {
+		t.Fatalf("Fail on create drop link: %s", err)
+	}
+	if created.Prefix != "inbox/" || created.AllowedExtensions[0] != ".pdf" {
+		t.Fatalf("Unexpected link %+v", created)
+	}
+	link, err := dropLinks.Open(rawToken)
+	if err != nil {
+		t.Fatalf("Fail on open: %s", err)
+	}
+	_, err = dropLinks.Accept(link, "run.exe", 10)
+	if !errors.Is(err, srv.ErrExtensionNotAllowed) {
+		t.Fatalf("Forbidden extension accepted")
+	}
+	_, err = dropLinks.Accept(link, "big.pdf", 101)
+	if !errors.Is(err, srv.ErrFileTooLarge) {
+		t.Fatalf("Too large file accepted")
+	}
+	key, err := dropLinks.Accept(link, "../../etc/Invoice.PDF", 100)
+	if err != nil {
+		t.Fatalf("Fail on accept: %s", err)
+	}
+	if !strings.HasPrefix(key, "inbox/") || !strings.HasSuffix(key, "-Invoice.PDF") || strings.Contains(key, "..") {
+		t.Fatalf("Unexpected key %s", key)
+	}
+	_, err = dropLinks.Accept(link, "second.pdf", 60)
+	if !errors.Is(err, repo.ErrDropLinkQuotaExceeded) {
+		t.Fatalf("Quota not enforced")
+	}
+	err = dropLinks.Release(link, 100)
+	if err != nil {
+		t.Fatalf("Fail on release: %s", err)
+	}
+	_, err = dropLinks.Accept(link, "second.pdf", 60)
+	if err != nil {
+		t.Fatalf("Released quota not reusable: %s", err)
+	}
+}
+
+func TestDropLinkExpiry(t *testing.T) {
+	dropLinks := fkDropLinks()
+	bucket := &repo.Bucket{BucketID: 1, UserID: 1}
+	expiresAt := time.Now().Add(-time.Minute)
+	created, rawToken, err := dropLinks.Create(1, bucket, srv.NewDropLink{ExpiresAt: &expiresAt})
+	if err != nil {
+		t.Fatalf("Fail on create drop link: %s", err)
+	}
+	_, err = dropLinks.Open(rawToken)
+	if !errors.Is(err, srv.ErrInvalidDropLink) {
+		t.Fatalf("Expired link opened")
+	}
+	err = dropLinks.Revoke(1, created.DropLinkID)
+	if err != nil {
+		t.Fatalf("Fail on revoke: %s", err)
+	}
+	_, err = dropLinks.Open(rawToken)
+	if !errors.Is(err, srv.ErrInvalidDropLink) {
+		t.Fatalf("Revoked link opened")
+	}
+}
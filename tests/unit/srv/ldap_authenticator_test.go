@@ -0,0 +1,152 @@
+package srv_test
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	repo "github.com/blablatdinov/web-s3/src/repo"
+	srv "github.com/blablatdinov/web-s3/src/srv"
+	"github.com/jimlambrt/gldap"
+)
+
+type ldapUser struct {
+	dn       string
+	password string
+	groups   []string
+}
+
+var ldapUsers = map[string]ldapUser{
+	"alice": {"uid=alice,ou=people,dc=example,dc=com", "alicePass", []string{"cn=s3-users,ou=groups,dc=example,dc=com"}},
+	"bob":   {"uid=bob,ou=people,dc=example,dc=com", "bobPass", []string{"cn=others,ou=groups,dc=example,dc=com"}},
+}
+
+// runLdapServer starts an in-process directory with ldapUsers and a
+// service account cn=admin,dc=example,dc=com.
+func runLdapServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Fail to find free port: %s", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	server, err := gldap.NewServer()
+	if err != nil {
+		t.Fatalf("Fail to create ldap server: %s", err)
+	}
+	mux, err := gldap.NewMux()
+	if err != nil {
+		t.Fatalf("Fail to create ldap mux: %s", err)
+	}
+	_ = mux.Bind(func(w *gldap.ResponseWriter, r *gldap.Request) {
+		resp := r.NewBindResponse(gldap.WithResponseCode(gldap.ResultInvalidCredentials))
+		defer func() { _ = w.Write(resp) }()
+		msg, err := r.GetSimpleBindMessage()
+		if err != nil {
+			return
+		}
+		if msg.UserName == "cn=admin,dc=example,dc=com" && msg.Password == "adminPass" {
+			resp.SetResultCode(gldap.ResultSuccess)
+		}
+		for _, user := range ldapUsers {
+			if msg.UserName == user.dn && string(msg.Password) == user.password {
+				resp.SetResultCode(gldap.ResultSuccess)
+			}
+		}
+	})
+	_ = mux.Search(func(w *gldap.ResponseWriter, r *gldap.Request) {
+		resp := r.NewSearchDoneResponse()
+		defer func() { _ = w.Write(resp) }()
+		msg, err := r.GetSearchMessage()
+		if err != nil {
+			return
+		}
+		for uid, user := range ldapUsers {
+			if msg.Filter == fmt.Sprintf("(uid=%s)", uid) {
+				_ = w.Write(r.NewSearchResponseEntry(user.dn, gldap.WithAttributes(map[string][]string{
+					"uid":      {uid},
+					"memberOf": user.groups,
+				})))
+			}
+		}
+		resp.SetResultCode(gldap.ResultSuccess)
+	})
+	_ = server.Router(mux)
+	go func() { _ = server.Run(addr) }()
+	t.Cleanup(func() { _ = server.Stop() })
+	for range 100 {
+		if server.Ready() {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return "ldap://" + addr
+}
+
+func TestLdapAuthenticator(t *testing.T) {
+	ldapURL := runLdapServer(t)
+	authenticator := srv.LdapAuthenticatorCtor(srv.LdapConfig{
+		URL:           ldapURL,
+		BindDN:        "cn=admin,dc=example,dc=com",
+		BindPassword:  "adminPass",
+		BaseDN:        "ou=people,dc=example,dc=com",
+		AllowedGroups: []string{"cn=s3-users,ou=groups,dc=example,dc=com"},
+	}, repo.FkIdentitiesRepoCtor())
+	userID, username, err := authenticator.Authenticate("alice", "alicePass")
+	if err != nil {
+		t.Fatalf("Fail on authenticate: %s", err)
+	}
+	if username != "alice" {
+		t.Fatalf("Unexpected username %s", username)
+	}
+	secondID, _, err := authenticator.Authenticate("alice", "alicePass")
+	if err != nil || secondID != userID {
+		t.Fatalf("Identity not reused: %d != %d, err=%v", secondID, userID, err)
+	}
+	_, _, err = authenticator.Authenticate("alice", "wrong")
+	if !errors.Is(err, srv.ErrInvalidPassword) {
+		t.Fatalf("Wrong password accepted: %v", err)
+	}
+	_, _, err = authenticator.Authenticate("alice", "")
+	if !errors.Is(err, srv.ErrInvalidPassword) {
+		t.Fatalf("Empty password accepted: %v", err)
+	}
+	_, _, err = authenticator.Authenticate("bob", "bobPass")
+	if !errors.Is(err, srv.ErrInvalidPassword) {
+		t.Fatalf("User outside of allowed groups accepted: %v", err)
+	}
+	_, _, err = authenticator.Authenticate("carol", "carolPass")
+	if !errors.Is(err, repo.ErrUserNotFound) {
+		t.Fatalf("Unknown user accepted: %v", err)
+	}
+}
+
+func TestChainAuthenticator(t *testing.T) {
+	ldapURL := runLdapServer(t)
+	pswrdHash, err := srv.PswrdCtor("fkPassword").Hash()
+	if err != nil {
+		t.Fatalf("Fail to generate hash: %s", err.Error())
+	}
+	authenticator := srv.ChainAuthenticatorCtor(
+		srv.LocalAuthenticatorCtor(repo.FkUserAuthRepoCtor(1, pswrdHash)),
+		srv.LdapAuthenticatorCtor(srv.LdapConfig{
+			URL:    ldapURL,
+			BaseDN: "ou=people,dc=example,dc=com",
+		}, repo.FkIdentitiesRepoCtor()),
+	)
+	_, _, err = authenticator.Authenticate("alice", "fkPassword")
+	if err != nil {
+		t.Fatalf("Local user rejected: %s", err)
+	}
+	_, _, err = authenticator.Authenticate("alice", "alicePass")
+	if err != nil {
+		t.Fatalf("Ldap user rejected: %s", err)
+	}
+	_, _, err = authenticator.Authenticate("alice", "wrong")
+	if !errors.Is(err, srv.ErrInvalidPassword) {
+		t.Fatalf("Wrong password accepted: %v", err)
+	}
+}
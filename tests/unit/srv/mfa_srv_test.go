@@ -0,0 +1,85 @@
+package srv_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	repo "github.com/blablatdinov/web-s3/src/repo"
+	srv "github.com/blablatdinov/web-s3/src/srv"
+)
+
+func TestTotpCode(t *testing.T) {
+	// RFC 6238 appendix B, SHA1 secret "12345678901234567890"
+	cases := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, expected := range cases {
+		code, err := srv.TotpCode("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", time.Unix(unix, 0))
+		if err != nil {
+			t.Fatalf("Fail on generate code: %s", err)
+		}
+		if code != expected {
+			t.Fatalf("Code at %d = %s, expected %s", unix, code, expected)
+		}
+	}
+}
+
+func TestMfaLogin(t *testing.T) {
+	pswrdHash, err := srv.PswrdCtor("fkPassword").Hash()
+	if err != nil {
+		t.Fatalf("Fail to generate hash: %s", err.Error())
+	}
+	mfa := srv.TotpMfaSrvCtor(repo.FkMfaRepoCtor(), "web-s3")
+	authSrv := srv.UserAuthSrvCtor("fkSecret", srv.LocalAuthenticatorCtor(repo.FkUserAuthRepoCtor(1, pswrdHash)), repo.FkTokenStoreCtor(), mfa)
+	setup, err := mfa.Enroll(1, "user1")
+	if err != nil {
+		t.Fatalf("Fail on enroll: %s", err)
+	}
+	code, err := srv.TotpCode(setup.Secret, time.Now())
+	if err != nil {
+		t.Fatalf("Fail on generate code: %s", err)
+	}
+	recoveryCodes, err := mfa.Confirm(1, code)
+	if err != nil || len(recoveryCodes) == 0 {
+		t.Fatalf("Fail on confirm: %v", err)
+	}
+	tokens, err := authSrv.Jwt("user1", "fkPassword", srv.Client{})
+	if err != nil {
+		t.Fatalf("Fail on login: %s", err)
+	}
+	if tokens.Access != "" || tokens.MfaToken == "" {
+		t.Fatalf("Second factor skipped")
+	}
+	_, err = authSrv.VerifyMfa(tokens.MfaToken, code, srv.Client{})
+	if !errors.Is(err, srv.ErrInvalidMfaCode) {
+		t.Fatalf("Used code accepted again")
+	}
+	_, err = authSrv.VerifyMfa(tokens.MfaToken, recoveryCodes[0], srv.Client{})
+	if !errors.Is(err, srv.ErrTokenRevoked) {
+		t.Fatalf("Mfa token reused after a wrong code")
+	}
+	tokens, err = authSrv.Jwt("user1", "fkPassword", srv.Client{})
+	if err != nil {
+		t.Fatalf("Fail on login: %s", err)
+	}
+	verified, err := authSrv.VerifyMfa(tokens.MfaToken, recoveryCodes[0], srv.Client{})
+	if err != nil || verified.Access == "" {
+		t.Fatalf("Fail on verify recovery code: %v", err)
+	}
+	_, err = authSrv.VerifyMfa(tokens.MfaToken, recoveryCodes[1], srv.Client{})
+	if !errors.Is(err, srv.ErrTokenRevoked) {
+		t.Fatalf("Mfa token reused")
+	}
+	tokens, err = authSrv.Jwt("user1", "fkPassword", srv.Client{})
+	if err != nil {
+		t.Fatalf("Fail on login: %s", err)
+	}
+	_, err = authSrv.VerifyMfa(tokens.MfaToken, recoveryCodes[0], srv.Client{})
+	if !errors.Is(err, srv.ErrInvalidMfaCode) {
+		t.Fatalf("Recovery code reused")
+	}
+}
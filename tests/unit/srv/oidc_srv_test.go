@@ -0,0 +1,266 @@
+package srv_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	repo "github.com/blablatdinov/web-s3/src/repo"
+	srv "github.com/blablatdinov/web-s3/src/srv"
+	"github.com/golang-jwt/jwt"
+)
+
+type authorization struct {
+	nonce     string
+	challenge string
+}
+
+// mockOidcProvider serves discovery, JWKS and token endpoints, the
+// authorization step is done by the test with authorize.
+type mockOidcProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	codes  map[string]authorization
+}
+
+func newMockOidcProvider(t *testing.T) *mockOidcProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Fail to generate key: %s", err)
+	}
+	provider := &mockOidcProvider{key: key, codes: map[string]authorization{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                provider.server.URL,
+			"authorization_endpoint":                provider.server.URL + "/authorize",
+			"token_endpoint":                        provider.server.URL + "/token",
+			"jwks_uri":                              provider.server.URL + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		auth, ok := provider.codes[r.FormValue("code")]
+		verifierSum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(verifierSum[:]) != auth.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":                provider.server.URL,
+			"sub":                "subject-1",
+			"aud":                "web-s3",
+			"nonce":              auth.nonce,
+			"preferred_username": "sso-user",
+			"iat":                time.Now().Unix(),
+			"exp":                time.Now().Add(time.Minute).Unix(),
+		})
+		token.Header["kid"] = "test"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "fkAccessToken",
+			"token_type":   "Bearer",
+			"expires_in":   60,
+			"id_token":     idToken,
+		})
+	})
+	provider.server = httptest.NewServer(mux)
+	t.Cleanup(provider.server.Close)
+	return provider
+}
+
+// authorize plays the user approving the login, it returns the code and
+// the state the provider would redirect back with.
+func (p *mockOidcProvider) authorize(t *testing.T, authURL string) (string, string) {
+	t.Helper()
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("Invalid auth url: %s", err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("PKCE is not used: %s", authURL)
+	}
+	code := "code-" + query.Get("state")
+	p.codes[code] = authorization{query.Get("nonce"), query.Get("code_challenge")}
+	return code, query.Get("state")
+}
+
+func oidcLogin(t *testing.T, provider *mockOidcProvider, autoProvision bool) srv.OidcLogin {
+	t.Helper()
+	return oidcLoginWith(t, provider, autoProvision, fkAuthSrv(t))
+}
+
+func oidcLoginWith(t *testing.T, provider *mockOidcProvider, autoProvision bool, userAuth srv.UserAuth) srv.OidcLogin {
+	t.Helper()
+	oidcLogin, err := srv.OidcSrvCtor(
+		context.Background(),
+		srv.OidcConfig{
+			Issuer:        provider.server.URL,
+			ClientID:      "web-s3",
+			RedirectURL:   "http://localhost/callback",
+			AutoProvision: autoProvision,
+		},
+		repo.FkOidcStateStoreCtor(),
+		repo.FkIdentitiesRepoCtor(),
+		userAuth,
+	)
+	if err != nil {
+		t.Fatalf("Fail on create oidc srv: %s", err)
+	}
+	return oidcLogin
+}
+
+func TestOidcLogin(t *testing.T) {
+	provider := newMockOidcProvider(t)
+	login := oidcLogin(t, provider, true)
+	authURL, browserState, err := login.AuthURL()
+	if err != nil {
+		t.Fatalf("Fail on auth url: %s", err)
+	}
+	code, state := provider.authorize(t, authURL)
+	tokens, err := login.Callback(code, state, browserState, srv.Client{})
+	if err != nil {
+		t.Fatalf("Fail on callback: %s", err)
+	}
+	if tokens.Access == "" || tokens.Refresh == "" {
+		t.Fatalf("Tokens not issued")
+	}
+	_, err = login.Callback(code, state, browserState, srv.Client{})
+	if !errors.Is(err, srv.ErrOidcInvalidState) {
+		t.Fatalf("State reused")
+	}
+}
+
+func TestOidcLoginWithoutProvisioning(t *testing.T) {
+	provider := newMockOidcProvider(t)
+	login := oidcLogin(t, provider, false)
+	authURL, browserState, err := login.AuthURL()
+	if err != nil {
+		t.Fatalf("Fail on auth url: %s", err)
+	}
+	code, state := provider.authorize(t, authURL)
+	_, err = login.Callback(code, state, browserState, srv.Client{})
+	if !errors.Is(err, srv.ErrOidcUserNotAllowed) {
+		t.Fatalf("Unknown subject logged in: %v", err)
+	}
+}
+
+func TestOidcStateBoundToBrowser(t *testing.T) {
+	provider := newMockOidcProvider(t)
+	login := oidcLogin(t, provider, true)
+	authURL, _, err := login.AuthURL()
+	if err != nil {
+		t.Fatalf("Fail on auth url: %s", err)
+	}
+	_, victimState, err := login.AuthURL()
+	if err != nil {
+		t.Fatalf("Fail on auth url: %s", err)
+	}
+	code, state := provider.authorize(t, authURL)
+	_, err = login.Callback(code, state, victimState, srv.Client{})
+	if !errors.Is(err, srv.ErrOidcInvalidState) {
+		t.Fatalf("Login finished in another browser: %v", err)
+	}
+}
+
+func TestOidcLinkExistingUser(t *testing.T) {
+	provider := newMockOidcProvider(t)
+	login := oidcLogin(t, provider, false)
+	linkURL, browserState, err := login.LinkURL(7)
+	if err != nil {
+		t.Fatalf("Fail on link url: %s", err)
+	}
+	code, state := provider.authorize(t, linkURL)
+	_, err = login.Callback(code, state, browserState, srv.Client{})
+	if err != nil {
+		t.Fatalf("Fail on link callback: %s", err)
+	}
+	authURL, browserState, err := login.AuthURL()
+	if err != nil {
+		t.Fatalf("Fail on auth url: %s", err)
+	}
+	code, state = provider.authorize(t, authURL)
+	tokens, err := login.Callback(code, state, browserState, srv.Client{})
+	if err != nil {
+		t.Fatalf("Linked identity can not log in: %s", err)
+	}
+	if tokens.Access == "" {
+		t.Fatalf("Tokens not issued")
+	}
+	linkURL, browserState, err = login.LinkURL(8)
+	if err != nil {
+		t.Fatalf("Fail on link url: %s", err)
+	}
+	code, state = provider.authorize(t, linkURL)
+	_, err = login.Callback(code, state, browserState, srv.Client{})
+	if !errors.Is(err, repo.ErrIdentityAlreadyLinked) {
+		t.Fatalf("Identity linked to a second user: %v", err)
+	}
+}
+
+func TestOidcLoginAsksForSecondFactor(t *testing.T) {
+	provider := newMockOidcProvider(t)
+	mfa := srv.TotpMfaSrvCtor(repo.FkMfaRepoCtor(), "web-s3")
+	authSrv := srv.UserAuthSrvCtor("fkSecret", srv.LocalAuthenticatorCtor(repo.FkUserAuthRepoCtor(1, repo.UnusablePasswordHash)), repo.FkTokenStoreCtor(), mfa)
+	login := oidcLoginWith(t, provider, false, authSrv)
+	setup, err := mfa.Enroll(7, "user7")
+	if err != nil {
+		t.Fatalf("Fail on enroll: %s", err)
+	}
+	code, err := srv.TotpCode(setup.Secret, time.Now())
+	if err != nil {
+		t.Fatalf("Fail on generate code: %s", err)
+	}
+	_, err = mfa.Confirm(7, code)
+	if err != nil {
+		t.Fatalf("Fail on confirm: %s", err)
+	}
+	linkURL, browserState, err := login.LinkURL(7)
+	if err != nil {
+		t.Fatalf("Fail on link url: %s", err)
+	}
+	providerCode, state := provider.authorize(t, linkURL)
+	tokens, err := login.Callback(providerCode, state, browserState, srv.Client{})
+	if err != nil {
+		t.Fatalf("Fail on link callback: %s", err)
+	}
+	if tokens.Access != "" || tokens.MfaToken == "" {
+		t.Fatalf("Second factor skipped on single sign-on")
+	}
+	username, err := authSrv.MfaUsername(tokens.MfaToken)
+	if err != nil || username != "user7" {
+		t.Fatalf("Mfa token issued for %q: %v", username, err)
+	}
+}
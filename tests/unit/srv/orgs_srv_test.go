@@ -0,0 +1,92 @@
+package srv_test
+
+import (
+	"errors"
+	"testing"
+
+	repo "github.com/blablatdinov/web-s3/src/repo"
+	srv "github.com/blablatdinov/web-s3/src/srv"
+)
+
+func TestOrgInvites(t *testing.T) {
+	orgs := srv.OrgsSrvCtor(repo.FkOrgsRepoCtor(map[string]int{"alice": 1, "bob": 2, "carol": 3}))
+	_, err := orgs.Create(1, " ")
+	if !errors.Is(err, srv.ErrInvalidOrgName) {
+		t.Fatalf("Blank organization name accepted")
+	}
+	org, err := orgs.Create(1, "team")
+	if err != nil {
+		t.Fatalf("Fail on create organization: %s", err)
+	}
+	_, err = orgs.Invite(1, org.OrgID, "dave")
+	if !errors.Is(err, repo.ErrUserNotFound) {
+		t.Fatalf("Unknown user invited")
+	}
+	invite, err := orgs.Invite(1, org.OrgID, "bob")
+	if err != nil {
+		t.Fatalf("Fail on invite: %s", err)
+	}
+	_, err = orgs.Members(2, org.OrgID)
+	if !errors.Is(err, repo.ErrOrgNotFound) {
+		t.Fatalf("Invited user sees members before accepting")
+	}
+	_, err = orgs.Accept(3, invite.OrgInviteID)
+	if !errors.Is(err, repo.ErrOrgInviteNotFound) {
+		t.Fatalf("Invite of another user accepted")
+	}
+	orgID, err := orgs.Accept(2, invite.OrgInviteID)
+	if err != nil || orgID != org.OrgID {
+		t.Fatalf("Fail on accept: %v", err)
+	}
+	members, err := orgs.Members(2, org.OrgID)
+	if err != nil || len(members) != 2 {
+		t.Fatalf("Unexpected members %+v, err=%v", members, err)
+	}
+	_, err = orgs.Invite(2, org.OrgID, "carol")
+	if !errors.Is(err, srv.ErrOrgForbidden) {
+		t.Fatalf("Member invited another user")
+	}
+	_, err = orgs.Invite(1, org.OrgID, "bob")
+	if !errors.Is(err, repo.ErrOrgMemberExists) {
+		t.Fatalf("Member invited twice")
+	}
+}
+
+func TestOrgOwners(t *testing.T) {
+	orgs := srv.OrgsSrvCtor(repo.FkOrgsRepoCtor(map[string]int{"alice": 1, "bob": 2}))
+	org, _ := orgs.Create(1, "team")
+	invite, _ := orgs.Invite(1, org.OrgID, "bob")
+	_, _ = orgs.Accept(2, invite.OrgInviteID)
+	err := orgs.RemoveMember(1, org.OrgID, 1)
+	if !errors.Is(err, srv.ErrLastOrgOwner) {
+		t.Fatalf("Last owner left the organization")
+	}
+	err = orgs.SetRole(1, org.OrgID, 1, repo.OrgRoleMember)
+	if !errors.Is(err, srv.ErrOrgSelfDemotion) {
+		t.Fatalf("Owner demoted themselves")
+	}
+	err = orgs.RemoveMember(2, org.OrgID, 1)
+	if !errors.Is(err, srv.ErrOrgForbidden) {
+		t.Fatalf("Member removed the owner")
+	}
+	err = orgs.SetRole(1, org.OrgID, 2, repo.OrgRoleOwner)
+	if err != nil {
+		t.Fatalf("Fail on promote: %s", err)
+	}
+	err = orgs.RemoveMember(1, org.OrgID, 1)
+	if err != nil {
+		t.Fatalf("Owner cannot leave while another owner stays: %s", err)
+	}
+	list, _ := orgs.List(1)
+	if len(list) != 0 {
+		t.Fatalf("Former member still lists the organization")
+	}
+	err = orgs.Delete(1, org.OrgID)
+	if !errors.Is(err, repo.ErrOrgNotFound) {
+		t.Fatalf("Former member deleted the organization")
+	}
+	err = orgs.Delete(2, org.OrgID)
+	if err != nil {
+		t.Fatalf("Fail on delete: %s", err)
+	}
+}
@@ -0,0 +1,53 @@
+package srv_test
+
+import (
+	"errors"
+	"testing"
+
+	repo "github.com/blablatdinov/web-s3/src/repo"
+	srv "github.com/blablatdinov/web-s3/src/srv"
+)
+
+func TestPasswordChange(t *testing.T) {
+	pswrdHash, err := srv.PswrdCtor("fkPassword").Hash()
+	if err != nil {
+		t.Fatalf("Fail to generate hash: %s", err.Error())
+	}
+	authRepo := repo.FkUserAuthRepoCtor(1, pswrdHash)
+	authSrv := srv.UserAuthSrvCtor("fkSecret", srv.LocalAuthenticatorCtor(authRepo), repo.FkTokenStoreCtor(), srv.TotpMfaSrvCtor(repo.FkMfaRepoCtor(), "web-s3"))
+	passwordChange := srv.PasswordChangeSrvCtor(authRepo, srv.DefaultPasswordPolicy(), authSrv)
+	tokens, err := authSrv.Jwt("user1", "fkPassword", srv.Client{})
+	if err != nil {
+		t.Fatalf("Fail on generate token: %s", err.Error())
+	}
+	err = passwordChange.Change(1, "user1", "wrong", "Correct1Horse")
+	if !errors.Is(err, srv.ErrInvalidPassword) {
+		t.Fatalf("Wrong old password accepted")
+	}
+	err = passwordChange.Change(1, "user1", "fkPassword", "weak")
+	if !errors.Is(err, srv.ErrWeakPassword) {
+		t.Fatalf("Weak password accepted")
+	}
+	err = passwordChange.Change(1, "user1", "fkPassword", "Correct1Horse")
+	if err != nil {
+		t.Fatalf("Fail on change password: %s", err.Error())
+	}
+	_, err = authSrv.Validate(tokens.Access)
+	if !errors.Is(err, srv.ErrTokenRevoked) {
+		t.Fatalf("Session survived password change")
+	}
+	_, err = authSrv.Jwt("user1", "Correct1Horse", srv.Client{})
+	if err != nil {
+		t.Fatalf("New password rejected: %s", err.Error())
+	}
+}
+
+func TestPasswordChangeWithoutLocalPassword(t *testing.T) {
+	authRepo := repo.FkUserAuthRepoCtor(1, repo.UnusablePasswordHash)
+	authSrv := srv.UserAuthSrvCtor("fkSecret", srv.LocalAuthenticatorCtor(authRepo), repo.FkTokenStoreCtor(), srv.TotpMfaSrvCtor(repo.FkMfaRepoCtor(), "web-s3"))
+	passwordChange := srv.PasswordChangeSrvCtor(authRepo, srv.DefaultPasswordPolicy(), authSrv)
+	err := passwordChange.Change(1, "user1", "", "Correct1Horse")
+	if !errors.Is(err, srv.ErrNoLocalPassword) {
+		t.Fatalf("Password set for an account without a local password: %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+package srv_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	repo "github.com/blablatdinov/web-s3/src/repo"
+	srv "github.com/blablatdinov/web-s3/src/srv"
+)
+
+func TestArchiveEntriesForKeysKeepFolders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "3")
+	}))
+	defer server.Close()
+	ctx := context.Background()
+	s3Client, err := srv.CreateS3ClientFromBucket(ctx, &repo.Bucket{
+		BucketName:      "fk-bucket",
+		AccessKeyID:     "fkAccessKey",
+		SecretAccessKey: "fkSecretKey",
+		Region:          "us-east-1",
+		Endpoint:        &server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Fail on client: %s", err)
+	}
+	entries, err := srv.ArchiveEntriesForKeys(ctx, s3Client, "fk-bucket", []string{
+		"reports/2024/summary.csv",
+		"reports/2025/summary.csv",
+		"reports/2025/summary.csv",
+	})
+	if err != nil {
+		t.Fatalf("Fail on entries: %s", err)
+	}
+	if len(entries) != 2 || entries[0].Name != "2024/summary.csv" || entries[1].Name != "2025/summary.csv" {
+		t.Fatalf("Unexpected entries %+v", entries)
+	}
+}
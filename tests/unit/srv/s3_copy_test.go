@@ -0,0 +1,86 @@
+package srv_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	repo "github.com/blablatdinov/web-s3/src/repo"
+	srv "github.com/blablatdinov/web-s3/src/srv"
+)
+
+func TestMultipartCopyKeepsMetadata(t *testing.T) {
+	created := http.Header{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		w.Header().Set("Content-Type", "application/xml")
+		switch {
+		case r.Method == http.MethodHead:
+			w.Header().Set("Content-Length", "6442450944")
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Content-Disposition", "attachment")
+			w.Header().Set("X-Amz-Meta-Owner", "alice")
+		case r.Method == http.MethodPost && query.Has("uploads"):
+			created = r.Header.Clone()
+			_, _ = w.Write([]byte(`<InitiateMultipartUploadResult><UploadId>fk-upload</UploadId></InitiateMultipartUploadResult>`))
+		case r.Method == http.MethodPut:
+			_, _ = w.Write([]byte(`<CopyPartResult><ETag>"fk-etag"</ETag></CopyPartResult>`))
+		case r.Method == http.MethodPost:
+			_, _ = w.Write([]byte(`<CompleteMultipartUploadResult><ETag>"fk-etag"</ETag></CompleteMultipartUploadResult>`))
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	defer server.Close()
+	ctx := context.Background()
+	bucket := &repo.Bucket{
+		BucketID:        1,
+		BucketName:      "fk-bucket",
+		AccessKeyID:     "fkAccessKey",
+		SecretAccessKey: "fkSecretKey",
+		Region:          "us-east-1",
+		Endpoint:        &server.URL,
+	}
+	s3Client, err := srv.CreateS3ClientFromBucket(ctx, bucket)
+	if err != nil {
+		t.Fatalf("Fail on client: %s", err)
+	}
+	err = srv.S3ObjectCopierCtor(s3Client, bucket, s3Client, bucket).Copy(ctx, "big.csv", "copy.csv")
+	if err != nil {
+		t.Fatalf("Fail on copy: %s", err)
+	}
+	if created.Get("Content-Type") != "text/csv" ||
+		created.Get("Cache-Control") != "max-age=60" ||
+		created.Get("Content-Disposition") != "attachment" ||
+		created.Get("X-Amz-Meta-Owner") != "alice" {
+		t.Fatalf("Metadata is lost: %v", created)
+	}
+}
+
+func TestSamePhysicalBucket(t *testing.T) {
+	endpoint := "https://s3.example.com/"
+	other := "https://S3.example.com"
+	a := &repo.Bucket{BucketID: 1, BucketName: "photos", AccessKeyID: "first", Endpoint: &endpoint}
+	b := &repo.Bucket{BucketID: 2, BucketName: "photos", AccessKeyID: "second", Endpoint: &other}
+	if !srv.SamePhysicalBucket(a, b) {
+		t.Fatalf("Registrations of one bucket are treated as different")
+	}
+	b.BucketName = "videos"
+	if srv.SamePhysicalBucket(a, b) {
+		t.Fatalf("Different buckets are treated as the same")
+	}
+}
+
+func TestCopyPartSizeFitsPartLimit(t *testing.T) {
+	const mib = int64(1024 * 1024)
+	if srv.CopyPartSize(6*1024*mib) != 512*mib {
+		t.Fatalf("Small objects should use the default part size")
+	}
+	size := 5 * 1024 * 1024 * mib
+	partSize := srv.CopyPartSize(size)
+	if (size+partSize-1)/partSize > 10000 || partSize%mib != 0 {
+		t.Fatalf("Part size %d does not fit %d bytes in 10000 parts", partSize, size)
+	}
+}
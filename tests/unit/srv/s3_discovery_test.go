@@ -0,0 +1,80 @@
+package srv_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	repo "github.com/blablatdinov/web-s3/src/repo"
+	srv "github.com/blablatdinov/web-s3/src/srv"
+)
+
+func discoveryServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "application/xml")
+			_, _ = w.Write([]byte(`<ListAllMyBucketsResult><Buckets>` +
+				`<Bucket><Name>photos</Name><BucketRegion>eu-west-1</BucketRegion></Bucket>` +
+				`<Bucket><Name>broken</Name><BucketRegion>eu-west-1</BucketRegion></Bucket>` +
+				`</Buckets></ListAllMyBucketsResult>`))
+		case "/photos":
+			if r.Method == http.MethodHead {
+				return
+			}
+			w.Header().Set("Content-Type", "application/xml")
+			_, _ = w.Write([]byte(`<ListBucketResult><Name>photos</Name><KeyCount>0</KeyCount></ListBucketResult>`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func discoveryCredentials(server *httptest.Server) *repo.Bucket {
+	return &repo.Bucket{
+		AccessKeyID:     "fkAccessKey",
+		SecretAccessKey: "fkSecretKey",
+		Region:          "us-east-1",
+		Endpoint:        &server.URL,
+	}
+}
+
+func TestDiscoverBuckets(t *testing.T) {
+	server := discoveryServer(t)
+	discovered, err := srv.DiscoverBuckets(context.Background(), discoveryCredentials(server))
+	if err != nil {
+		t.Fatalf("Fail discover buckets: %s", err)
+	}
+	if len(discovered) != 2 || discovered[0].BucketName != "photos" || discovered[0].Region != "eu-west-1" {
+		t.Fatalf("Unexpected discovered buckets: %+v", discovered)
+	}
+}
+
+func TestResolveImport(t *testing.T) {
+	server := discoveryServer(t)
+	resolved, rejected, err := srv.ResolveImport(
+		context.Background(),
+		discoveryCredentials(server),
+		[]string{"photos", "broken", "secret"},
+		srv.S3BucketProbeCtor(),
+	)
+	if err != nil {
+		t.Fatalf("Fail resolve import: %s", err)
+	}
+	if len(resolved) != 1 || resolved[0].BucketName != "photos" || resolved[0].Region != "eu-west-1" {
+		t.Fatalf("Unexpected resolved buckets: %+v", resolved)
+	}
+	if len(rejected) != 2 {
+		t.Fatalf("Expected 2 rejected buckets, got %+v", rejected)
+	}
+	if rejected[0].BucketName != "broken" || rejected[0].Diagnosis == nil || rejected[0].Diagnosis.Status != srv.ProbeBucketNotFound {
+		t.Fatalf("Expected broken bucket to fail the probe, got %+v", rejected[0])
+	}
+	if rejected[1].BucketName != "secret" || rejected[1].Diagnosis != nil {
+		t.Fatalf("Expected secret bucket to be invisible, got %+v", rejected[1])
+	}
+}
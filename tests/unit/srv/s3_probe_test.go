@@ -0,0 +1,83 @@
+package srv_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	repo "github.com/blablatdinov/web-s3/src/repo"
+	srv "github.com/blablatdinov/web-s3/src/srv"
+)
+
+func probeAgainst(t *testing.T, handler http.HandlerFunc) srv.BucketDiagnosis {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	return srv.S3BucketProbeCtor().Probe(context.Background(), &repo.Bucket{
+		BucketName:      "fk-bucket",
+		AccessKeyID:     "fkAccessKey",
+		SecretAccessKey: "fkSecretKey",
+		Region:          "us-east-1",
+		Endpoint:        &server.URL,
+	})
+}
+
+func TestProbeOK(t *testing.T) {
+	diagnosis := probeAgainst(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = w.Write([]byte(`<ListBucketResult><Name>fk-bucket</Name><KeyCount>0</KeyCount></ListBucketResult>`))
+	})
+	if !diagnosis.OK {
+		t.Fatalf("Expected ok, got %+v", diagnosis)
+	}
+}
+
+func TestProbeBucketNotFound(t *testing.T) {
+	diagnosis := probeAgainst(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	if diagnosis.Status != srv.ProbeBucketNotFound {
+		t.Fatalf("Expected %s, got %+v", srv.ProbeBucketNotFound, diagnosis)
+	}
+}
+
+func TestProbeAuthFailed(t *testing.T) {
+	diagnosis := probeAgainst(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		if r.Method == http.MethodHead {
+			return
+		}
+		_, _ = w.Write([]byte(`<Error><Code>InvalidAccessKeyId</Code><Message>bad key</Message></Error>`))
+	})
+	if diagnosis.Status != srv.ProbeAuthFailed {
+		t.Fatalf("Expected %s, got %+v", srv.ProbeAuthFailed, diagnosis)
+	}
+}
+
+func TestProbeWrongRegion(t *testing.T) {
+	diagnosis := probeAgainst(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Amz-Bucket-Region", "eu-west-1")
+		w.WriteHeader(http.StatusMovedPermanently)
+	})
+	if diagnosis.Status != srv.ProbeWrongRegion || diagnosis.SuggestedRegion != "eu-west-1" {
+		t.Fatalf("Expected %s with eu-west-1, got %+v", srv.ProbeWrongRegion, diagnosis)
+	}
+}
+
+func TestProbeEndpointUnreachable(t *testing.T) {
+	endpoint := "http://127.0.0.1:1"
+	diagnosis := srv.S3BucketProbeCtor().Probe(context.Background(), &repo.Bucket{
+		BucketName:      "fk-bucket",
+		AccessKeyID:     "fkAccessKey",
+		SecretAccessKey: "fkSecretKey",
+		Region:          "us-east-1",
+		Endpoint:        &endpoint,
+	})
+	if diagnosis.Status != srv.ProbeEndpointUnreachable {
+		t.Fatalf("Expected %s, got %+v", srv.ProbeEndpointUnreachable, diagnosis)
+	}
+}
@@ -0,0 +1,101 @@
+package srv_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	repo "github.com/blablatdinov/web-s3/src/repo"
+	srv "github.com/blablatdinov/web-s3/src/srv"
+)
+
+func fkShareLinks() srv.ShareLinks {
+	return srv.ShareLinksSrvCtor(repo.FkShareLinksRepoCtor(), fkBucketAuthz(map[string]int{"alice": 1, "bob": 2}))
+}
+
+func TestShareLinkDownloads(t *testing.T) {
+	shareLinks := fkShareLinks()
+	bucket := &repo.Bucket{BucketID: 1, UserID: 1}
+	_, _, err := shareLinks.Create(2, bucket, srv.NewShareLink{Key: "report.pdf"})
+	if !errors.Is(err, srv.ErrBucketForbidden) {
+		t.Fatalf("Stranger shared a file")
+	}
+	maxDownloads := 1
+	created, rawToken, err := shareLinks.Create(1, bucket, srv.NewShareLink{Key: "/report.pdf", MaxDownloads: &maxDownloads})
+	if err != nil {
+		t.Fatalf("Fail on create share link: %s", err)
+	}
+	if created.Key != "report.pdf" || srv.IsShareFolder(created) {
+		t.Fatalf("Unexpected link %+v", created)
+	}
+	link, err := shareLinks.Open(rawToken, "")
+	if err != nil {
+		t.Fatalf("Fail on open: %s", err)
+	}
+	err = shareLinks.Download(link)
+	if err != nil {
+		t.Fatalf("Fail on first download: %s", err)
+	}
+	err = shareLinks.Download(link)
+	if !errors.Is(err, repo.ErrShareLinkExhausted) {
+		t.Fatalf("Download limit not enforced")
+	}
+	err = shareLinks.Revoke(1, created.ShareLinkID)
+	if err != nil {
+		t.Fatalf("Fail on revoke: %s", err)
+	}
+	_, err = shareLinks.Open(rawToken, "")
+	if !errors.Is(err, srv.ErrInvalidShareLink) {
+		t.Fatalf("Revoked link opened")
+	}
+}
+
+func TestShareLinkPasswordAndExpiry(t *testing.T) {
+	shareLinks := fkShareLinks()
+	bucket := &repo.Bucket{BucketID: 1, UserID: 1}
+	_, rawToken, err := shareLinks.Create(1, bucket, srv.NewShareLink{Key: "docs/", Password: "s3cret"})
+	if err != nil {
+		t.Fatalf("Fail on create share link: %s", err)
+	}
+	_, err = shareLinks.Open(rawToken, "")
+	if !errors.Is(err, srv.ErrSharePasswordRequired) {
+		t.Fatalf("Link opened without password")
+	}
+	_, err = shareLinks.Open(rawToken, "wrong")
+	if !errors.Is(err, srv.ErrInvalidSharePassword) {
+		t.Fatalf("Link opened with wrong password")
+	}
+	link, err := shareLinks.Open(rawToken, "s3cret")
+	if err != nil || !srv.IsShareFolder(link) {
+		t.Fatalf("Fail on open folder link: %v", err)
+	}
+	expiresAt := time.Now().Add(-time.Minute)
+	_, rawToken, err = shareLinks.Create(1, bucket, srv.NewShareLink{Key: "a.txt", ExpiresAt: &expiresAt})
+	if err != nil {
+		t.Fatalf("Fail on create share link: %s", err)
+	}
+	_, err = shareLinks.Open(rawToken, "")
+	if !errors.Is(err, srv.ErrInvalidShareLink) {
+		t.Fatalf("Expired link opened")
+	}
+}
+
+func TestShareLinkDownloadCounting(t *testing.T) {
+	maxDownloads := 1
+	link := repo.ShareLink{MaxDownloads: &maxDownloads}
+	if srv.IsShareLinkExhausted(link) {
+		t.Fatalf("Fresh link is exhausted")
+	}
+	link.Downloads = 1
+	if !srv.IsShareLinkExhausted(link) {
+		t.Fatalf("Used up link is not exhausted")
+	}
+	fromStart := "bytes 0-99/1000"
+	seek := "bytes 500-999/1000"
+	if !srv.CountsAsShareDownload(nil) || !srv.CountsAsShareDownload(&fromStart) {
+		t.Fatalf("Full download is not counted")
+	}
+	if srv.CountsAsShareDownload(&seek) {
+		t.Fatalf("Range request counted as a download")
+	}
+}
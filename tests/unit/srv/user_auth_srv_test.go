@@ -1,6 +1,7 @@
 package srv_test
 
 import (
+	"errors"
 	"testing"
 
 	repo "github.com/blablatdinov/web-s3/src/repo"
@@ -14,16 +15,20 @@ func Test(t *testing.T) {
 	}
 	authSrv := srv.UserAuthSrvCtor(
 		"fkSecret",
-		repo.FkUserAuthRepoCtor(
-			0,
-			pswrdHash,
+		srv.LocalAuthenticatorCtor(
+			repo.FkUserAuthRepoCtor(
+				0,
+				pswrdHash,
+			),
 		),
+		repo.FkTokenStoreCtor(),
+		srv.TotpMfaSrvCtor(repo.FkMfaRepoCtor(), "web-s3"),
 	)
-	token, err := authSrv.Jwt("user1", "fkPassword")
+	tokens, err := authSrv.Jwt("user1", "fkPassword", srv.Client{})
 	if err != nil {
 		t.Fatalf("Fail on generate token: %s", err.Error())
 	}
-	valid, err := authSrv.Validate(token)
+	valid, err := authSrv.Validate(tokens.Access)
 	if err != nil {
 		t.Fatalf("Fail on validate token: %s", err.Error())
 	}
@@ -31,3 +36,92 @@ func Test(t *testing.T) {
 		t.Fatalf("Invalid token")
 	}
 }
+
+func fkAuthSrv(t *testing.T) srv.UserAuth {
+	t.Helper()
+	pswrdHash, err := srv.PswrdCtor("fkPassword").Hash()
+	if err != nil {
+		t.Fatalf("Fail to generate hash: %s", err.Error())
+	}
+	return srv.UserAuthSrvCtor("fkSecret", srv.LocalAuthenticatorCtor(repo.FkUserAuthRepoCtor(1, pswrdHash)), repo.FkTokenStoreCtor(), srv.TotpMfaSrvCtor(repo.FkMfaRepoCtor(), "web-s3"))
+}
+
+func TestRefreshRotation(t *testing.T) {
+	authSrv := fkAuthSrv(t)
+	tokens, err := authSrv.Jwt("user1", "fkPassword", srv.Client{})
+	if err != nil {
+		t.Fatalf("Fail on generate token: %s", err.Error())
+	}
+	rotated, err := authSrv.Refresh(tokens.Refresh, srv.Client{})
+	if err != nil {
+		t.Fatalf("Fail on refresh token: %s", err.Error())
+	}
+	_, err = authSrv.Refresh(tokens.Refresh, srv.Client{})
+	if !errors.Is(err, srv.ErrTokenRevoked) {
+		t.Fatalf("Reused refresh token accepted")
+	}
+	_, err = authSrv.Validate(rotated.Access)
+	if !errors.Is(err, srv.ErrTokenRevoked) {
+		t.Fatalf("Session not revoked after refresh token reuse")
+	}
+}
+
+func TestLogout(t *testing.T) {
+	authSrv := fkAuthSrv(t)
+	tokens, err := authSrv.Jwt("user1", "fkPassword", srv.Client{})
+	if err != nil {
+		t.Fatalf("Fail on generate token: %s", err.Error())
+	}
+	err = authSrv.Logout(tokens.Access)
+	if err != nil {
+		t.Fatalf("Fail on logout: %s", err.Error())
+	}
+	_, err = authSrv.Validate(tokens.Access)
+	if !errors.Is(err, srv.ErrTokenRevoked) {
+		t.Fatalf("Access token valid after logout")
+	}
+	_, err = authSrv.Refresh(tokens.Refresh, srv.Client{})
+	if !errors.Is(err, srv.ErrTokenRevoked) {
+		t.Fatalf("Refresh token valid after logout")
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	authSrv := fkAuthSrv(t)
+	tokens, err := authSrv.Jwt("user1", "fkPassword", srv.Client{IP: "127.0.0.1", UserAgent: "fkAgent"})
+	if err != nil {
+		t.Fatalf("Fail on generate token: %s", err.Error())
+	}
+	sessions, err := authSrv.Sessions(1)
+	if err != nil || len(sessions) != 1 || sessions[0].IP != "127.0.0.1" {
+		t.Fatalf("Unexpected sessions %+v, err=%v", sessions, err)
+	}
+	err = authSrv.RevokeSession(2, sessions[0].SessionID)
+	if !errors.Is(err, repo.ErrSessionNotFound) {
+		t.Fatalf("Session of another user revoked")
+	}
+	err = authSrv.RevokeSession(1, sessions[0].SessionID)
+	if err != nil {
+		t.Fatalf("Fail on revoke session: %s", err.Error())
+	}
+	_, err = authSrv.Validate(tokens.Access)
+	if !errors.Is(err, srv.ErrTokenRevoked) {
+		t.Fatalf("Access token valid after session revoked")
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	authSrv := fkAuthSrv(t)
+	err := authSrv.CheckPassword(1, "user1", "fkPassword")
+	if err != nil {
+		t.Fatalf("Fail on check password: %s", err)
+	}
+	err = authSrv.CheckPassword(1, "user1", "wrongPassword")
+	if !errors.Is(err, srv.ErrInvalidPassword) {
+		t.Fatalf("Wrong password accepted")
+	}
+	err = authSrv.CheckPassword(2, "user1", "fkPassword")
+	if !errors.Is(err, srv.ErrInvalidPassword) {
+		t.Fatalf("Password of another user accepted")
+	}
+}
@@ -9,7 +9,7 @@ import (
 )
 
 func TestEmptyUsername(t *testing.T) {
-	usrSignupSrv := srv.UsrSignupSrvCtor(repo.FkUserSignupRepoCtor(0, nil))
+	usrSignupSrv := srv.UsrSignupSrvCtor(repo.FkUserSignupRepoCtor(0, nil), srv.DefaultPasswordPolicy())
 	_, err := usrSignupSrv.Create("", "pass")
 	if err == nil {
 		t.Fatalf("Error not raised")
@@ -18,3 +18,17 @@ func TestEmptyUsername(t *testing.T) {
 		t.Fatalf("Error not matched")
 	}
 }
+
+func TestWeakPassword(t *testing.T) {
+	usrSignupSrv := srv.UsrSignupSrvCtor(repo.FkUserSignupRepoCtor(0, nil), srv.DefaultPasswordPolicy())
+	for _, password := range []string{"", "Sh0rt", "alllowercase1", "Password1", "Username1"} {
+		_, err := usrSignupSrv.Create("username1", password)
+		if !errors.Is(err, srv.ErrWeakPassword) {
+			t.Fatalf("Password %q accepted", password)
+		}
+	}
+	_, err := usrSignupSrv.Create("username1", "Correct1Horse")
+	if err != nil {
+		t.Fatalf("Strong password rejected: %s", err)
+	}
+}